@@ -0,0 +1,204 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"lolarobins.ca/overload/input"
+	"lolarobins.ca/overload/log"
+)
+
+const (
+	CLI       = "cli"
+	API       = "api"
+	Scheduler = "scheduler"
+)
+
+// actor used for actions taken at the console
+const Console = "console"
+
+type Entry struct {
+	Time   time.Time `json:"time"`
+	Actor  string    `json:"actor"`
+	Source string    `json:"source"`
+	Action string    `json:"action"`
+	Target string    `json:"target,omitempty"`
+	Old    string    `json:"old,omitempty"`
+	New    string    `json:"new,omitempty"`
+}
+
+type Filter struct {
+	Actor  string
+	Source string
+	Action string // a trailing '*' matches by prefix
+	Target string
+	Since  time.Time
+	Limit  int
+}
+
+const file = "data/audit.jsonl"
+
+var lock = new(sync.Mutex)
+
+func Init() {
+	input.Command{
+		Function: func(s []string) {
+			filter := Filter{Limit: 20}
+
+			for _, arg := range s[1:] {
+				key, val, ok := strings.Cut(arg, "=")
+				if !ok {
+					limit, err := strconv.Atoi(arg)
+					if err != nil {
+						log.Error("Invalid arguments")
+						return
+					}
+
+					filter.Limit = limit
+					continue
+				}
+
+				switch strings.ToLower(key) {
+				case "actor":
+					filter.Actor = val
+				case "source":
+					filter.Source = val
+				case "action":
+					filter.Action = val
+				case "target":
+					filter.Target = val
+				case "since":
+					d, err := time.ParseDuration(val)
+					if err != nil {
+						log.Error("Invalid duration '" + val + "'")
+						return
+					}
+
+					filter.Since = time.Now().Add(-d)
+				default:
+					log.Error("Invalid filter '" + key + "'")
+					return
+				}
+			}
+
+			entries, err := Query(filter)
+			if err != nil {
+				log.Error("Reading audit log: " + err.Error())
+				return
+			}
+
+			log.Info("Showing " + strconv.Itoa(len(entries)) + " audit log entries:")
+			for _, e := range entries {
+				line := e.Time.Local().Format("2006-01-02 15:04:05") + " " + e.Actor + " (" + e.Source + ") > " + e.Action
+				if e.Target != "" {
+					line += " " + e.Target
+				}
+				if e.Old != "" || e.New != "" {
+					line += ": '" + e.Old + "' -> '" + e.New + "'"
+				}
+
+				log.Info(line)
+			}
+		},
+		Command:     "audit",
+		Args:        " [count] [actor/source/action/target/since=value]",
+		Description: "Show recent administrative actions, since takes a duration such as 24h",
+	}.Register()
+}
+
+// appends an entry to the audit log, failures are logged rather than stopping the action
+func Record(e Entry) {
+	if e.Time.IsZero() {
+		e.Time = time.Now().UTC()
+	}
+
+	data, err := json.Marshal(e)
+	if err != nil {
+		log.Error("Marshalling audit log entry: " + err.Error())
+		return
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	f, err := os.OpenFile(file, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		log.Error("Opening audit log: " + err.Error())
+		return
+	}
+	defer f.Close()
+
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		log.Error("Writing audit log: " + err.Error())
+	}
+}
+
+// entries matching the filter, oldest first, limited to the most recent
+func Query(filter Filter) ([]Entry, error) {
+	lock.Lock()
+	defer lock.Unlock()
+
+	entries := []Entry{}
+
+	f, err := os.Open(file)
+	if os.IsNotExist(err) {
+		return entries, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	for scanner.Scan() {
+		e := Entry{}
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			continue
+		}
+
+		if filter.matches(e) {
+			entries = append(entries, e)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, errors.New("reading '" + file + "': " + err.Error())
+	}
+
+	if filter.Limit > 0 && len(entries) > filter.Limit {
+		entries = entries[len(entries)-filter.Limit:]
+	}
+
+	return entries, nil
+}
+
+func (f Filter) matches(e Entry) bool {
+	if f.Actor != "" && !strings.EqualFold(f.Actor, e.Actor) {
+		return false
+	}
+
+	if f.Source != "" && f.Source != e.Source {
+		return false
+	}
+
+	if strings.HasSuffix(f.Action, "*") {
+		if !strings.HasPrefix(e.Action, strings.TrimSuffix(f.Action, "*")) {
+			return false
+		}
+	} else if f.Action != "" && f.Action != e.Action {
+		return false
+	}
+
+	if f.Target != "" && f.Target != e.Target {
+		return false
+	}
+
+	return f.Since.IsZero() || !e.Time.Before(f.Since)
+}
@@ -0,0 +1,712 @@
+package backup
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"lolarobins.ca/overload/audit"
+	"lolarobins.ca/overload/event"
+	"lolarobins.ca/overload/input"
+	"lolarobins.ca/overload/log"
+	"lolarobins.ca/overload/node"
+)
+
+type Config struct {
+	Format       string                `json:"format"`   // 'tar.gz' for whole archives, or 'chunks' to store only what changed
+	Excludes     []string              `json:"excludes"` // paths or names within a node directory, may use wildcards
+	Retention    Retention             `json:"retention"`
+	SafetyBackup bool                  `json:"safetybackup"` // back up a node before a restore overwrites it
+	Nodes        map[string]NodeConfig `json:"nodes"`
+	Destinations []Destination         `json:"destinations"`
+}
+
+// settings of one node, in place of the defaults
+type NodeConfig struct {
+	Format    string     `json:"format,omitempty"`
+	Excludes  []string   `json:"excludes,omitempty"`
+	Retention *Retention `json:"retention,omitempty"`
+}
+
+// written beside each archive, describing what it holds, a chunked backup is only its manifest
+type Manifest struct {
+	Id       string    `json:"id"`
+	Node     string    `json:"node"`
+	Time     time.Time `json:"time"`
+	Format   string    `json:"format"`
+	Archive  string    `json:"archive,omitempty"`
+	Size     int64     `json:"size"`             // of the archive, or of the chunks the backup added to the store
+	Bytes    int64     `json:"bytes"`            // of the files before compression
+	SHA256   string    `json:"sha256,omitempty"` // of the archive
+	Running  bool      `json:"running"`          // whether saving was paused on a running server while it was taken
+	Note     string    `json:"note,omitempty"`
+	Excludes []string  `json:"excludes"`
+	Files    []File    `json:"files"`
+}
+
+type File struct {
+	Path     string      `json:"path"`
+	Size     int64       `json:"size"`
+	Mode     fs.FileMode `json:"mode"`
+	Modified time.Time   `json:"modified"`
+	SHA256   string      `json:"sha256,omitempty"`
+	Chunks   []string    `json:"chunks,omitempty"` // hashes of the file's content in order, in chunked backups
+}
+
+const file = "config/backups.json"
+const dir = "backups"
+
+const FormatTarGz = "tar.gz"
+const FormatChunks = "chunks"
+
+// how long a server is given to write the world to disk
+const saveTimeout = 2 * time.Minute
+
+var Settings = Config{
+	Format:       FormatTarGz,
+	Excludes:     []string{"logs", "cache", "crash-reports", "debug", "libraries", "versions", "threaddump-*.txt"},
+	Retention:    Retention{Last: 5, Hourly: 24, Daily: 7, Weekly: 4},
+	SafetyBackup: true,
+	Nodes:        map[string]NodeConfig{},
+	Destinations: []Destination{},
+}
+
+var lock = new(sync.Mutex)
+
+// nodes with a backup being taken
+var active = make(map[string]bool)
+
+// nodes with a backup being restored over them
+var restoring = make(map[string]bool)
+
+// whether unused chunks are being deleted, which no backup can be taken during
+var collecting bool
+
+// backups being copied from destinations
+var downloads int
+
+func Init() error {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		data, _ := json.MarshalIndent(Settings, "", "    ")
+
+		if err := os.WriteFile(file, data, 0600); err != nil {
+			return errors.New("fatal: unable to read/write in working directory")
+		}
+	} else if err := json.Unmarshal(data, &Settings); err != nil {
+		return errors.New("fatal: '" + file + "' cannot be parsed")
+	}
+
+	if err := startDestinations(); err != nil {
+		return err
+	}
+
+	input.Command{
+		Function: func(s []string) {
+			if len(s) != 2 {
+				log.Error("Invalid arguments")
+				return
+			}
+
+			nodes := []*node.Node{}
+
+			if s[1] == "*" {
+				nodes = node.All()
+			} else {
+				n, err := node.Get(s[1])
+				if err != nil {
+					log.Error("Error backing up node: " + err.Error())
+					return
+				}
+
+				nodes = append(nodes, n)
+			}
+
+			audit.Record(audit.Entry{Actor: audit.Console, Source: audit.CLI, Action: "node.backup", Target: s[1]})
+
+			// archiving takes a while, so the console is left free
+			go func() {
+				for _, n := range nodes {
+					Backup(n)
+				}
+			}()
+		},
+		Command:     "backup",
+		Args:        " <id/*>",
+		Description: "Back up a node's directory to '" + dir + "/<id>', pausing saving while it runs",
+	}.Register()
+
+	input.Command{
+		Function: func(s []string) {
+			if len(s) != 2 && len(s) != 3 {
+				log.Error("Invalid arguments")
+				return
+			}
+
+			list := List
+			where := ""
+
+			if len(s) == 3 {
+				d, err := getDestination(s[2])
+				if err != nil {
+					log.Error("Error listing backups: " + err.Error())
+					return
+				}
+
+				list = d.list
+				where = " at " + d.Name
+			}
+
+			manifests, err := list(s[1])
+			if err != nil {
+				log.Error("Error listing backups: " + err.Error())
+				return
+			}
+
+			log.Info("Showing " + strconv.Itoa(len(manifests)) + " backups of " + s[1] + where + ":")
+			for _, m := range manifests {
+				line := m.Id + " > " + m.Time.Local().Format("2006-01-02 15:04:05") + ", " + strconv.FormatInt(m.Size/1024, 10) + "KB, " + strconv.Itoa(len(m.Files)) + " files"
+				if m.Format == FormatChunks {
+					line = m.Id + " > " + m.Time.Local().Format("2006-01-02 15:04:05") + ", chunked, " + strconv.FormatInt(m.Bytes/1024, 10) + "KB (" + strconv.FormatInt(m.Size/1024, 10) + "KB new), " + strconv.Itoa(len(m.Files)) + " files"
+				}
+				if m.Running {
+					line += ", taken while running"
+				}
+
+				if m.Note != "" {
+					line += ", " + m.Note
+				}
+
+				log.Info(line)
+			}
+		},
+		Command:     "backups",
+		Args:        " <id> [destination]",
+		Description: "View the backups of a node, here or at a destination",
+	}.Register()
+
+	input.Command{
+		Function: func(s []string) {
+			if len(s) != 3 && len(s) != 4 {
+				log.Error("Invalid arguments")
+				return
+			}
+
+			n, err := node.Get(s[1])
+			if err != nil {
+				log.Error("Error restoring backup: " + err.Error())
+				return
+			}
+
+			target := n.Id
+			if len(s) == 4 {
+				target = s[3]
+			}
+
+			audit.Record(audit.Entry{Actor: audit.Console, Source: audit.CLI, Action: "node.restore", Target: target, New: s[2]})
+
+			go Restore(n, s[2], target)
+		},
+		Command:     "restore",
+		Args:        " <id> <backup> [new id]",
+		Description: "Restore a backup over a stopped node, or into a new node",
+	}.Register()
+
+	input.Command{
+		Function: func(s []string) {
+			if len(s) != 3 {
+				log.Error("Invalid arguments")
+				return
+			}
+
+			m, err := Get(s[1], s[2])
+			if err != nil {
+				log.Error("Error verifying backup: " + err.Error())
+				return
+			}
+
+			go func() {
+				log.Info("Verifying " + m.Id)
+
+				if err := Verify(m); err != nil {
+					log.Error("Backup " + m.Id + " is damaged: " + err.Error())
+					return
+				}
+
+				log.Info("Backup " + m.Id + " is intact (" + strconv.Itoa(len(m.Files)) + " files)")
+			}()
+		},
+		Command:     "verify",
+		Args:        " <id> <backup>",
+		Description: "Check that every file of a backup can be read back as it was",
+	}.Register()
+
+	input.Command{
+		Function: func(s []string) {
+			if len(s) != 1 {
+				log.Error("Invalid arguments")
+				return
+			}
+
+			audit.Record(audit.Entry{Actor: audit.Console, Source: audit.CLI, Action: "backup.gc"})
+
+			go func() {
+				removed, _, err := Collect()
+				if err != nil {
+					log.Error("Error collecting backup chunks: " + err.Error())
+				} else if removed == 0 {
+					log.Info("No unused backup chunks to remove")
+				}
+			}()
+		},
+		Command:     "gc",
+		Description: "Delete backup chunks no longer used by any backup",
+	}.Register()
+
+	input.Command{
+		Function: func(s []string) {
+			if len(s) != 1 {
+				log.Error("Invalid arguments")
+				return
+			}
+
+			log.Info("Showing " + strconv.Itoa(len(destinations)) + " backup destinations:")
+			for _, d := range destinations {
+				line := d.Name + " > " + d.Type
+				if d.Passphrase != "" {
+					line += ", encrypted"
+				}
+
+				d.lock.Lock()
+				if d.err != nil {
+					line += ", failing: " + d.err.Error()
+				} else if !d.synced.IsZero() {
+					line += ", copied " + d.synced.Format("2006-01-02 15:04:05")
+				}
+				d.lock.Unlock()
+
+				log.Info(line)
+			}
+		},
+		Command:     "destinations",
+		Description: "View the destinations backups are copied to",
+	}.Register()
+
+	input.Command{
+		Function: func(s []string) {
+			if len(s) != 1 && len(s) != 2 {
+				log.Error("Invalid arguments")
+				return
+			}
+
+			if len(s) == 2 {
+				d, err := getDestination(s[1])
+				if err != nil {
+					log.Error("Error copying backups: " + err.Error())
+					return
+				}
+
+				d.queue()
+				return
+			}
+
+			for _, d := range destinations {
+				d.queue()
+			}
+		},
+		Command:     "sync",
+		Args:        " [destination]",
+		Description: "Copy backups to destinations now, deleting those past their retention there",
+	}.Register()
+
+	input.Command{
+		Function: func(s []string) {
+			if len(s) != 4 {
+				log.Error("Invalid arguments")
+				return
+			}
+
+			d, err := getDestination(s[1])
+			if err != nil {
+				log.Error("Error downloading backup: " + err.Error())
+				return
+			}
+
+			audit.Record(audit.Entry{Actor: audit.Console, Source: audit.CLI, Action: "backup.download", Target: s[2], New: s[3]})
+
+			go func() {
+				log.Info("Downloading " + s[3] + " from " + d.Name)
+
+				if _, err := d.download(s[2], s[3]); err != nil {
+					log.Error("Error downloading backup: " + err.Error())
+					return
+				}
+
+				log.Info("Downloaded " + s[3] + " from " + d.Name + ", it can now be restored")
+			}()
+		},
+		Command:     "download",
+		Args:        " <destination> <id> <backup>",
+		Description: "Copy a backup from a destination, so it can be restored",
+	}.Register()
+
+	return nil
+}
+
+// how a node is backed up
+func FormatOf(id string) string {
+	if c, ok := Settings.Nodes[id]; ok && c.Format != "" {
+		return c.Format
+	}
+
+	return Settings.Format
+}
+
+// paths left out of a node's backups
+func Excludes(id string) []string {
+	if c, ok := Settings.Nodes[id]; ok && c.Excludes != nil {
+		return c.Excludes
+	}
+
+	return Settings.Excludes
+}
+
+// archives a node's directory, flushing the world to disk first and keeping saving off until it's done when the node is running
+func Backup(n *node.Node) (*Manifest, error) {
+	m, err := take(n, "")
+	if err != nil {
+		return nil, err
+	}
+
+	if err := prune(n.Id); err != nil {
+		log.Error("Pruning backups of " + n.Config.Name + " (" + n.Id + "): " + err.Error())
+	}
+
+	for _, d := range destinations {
+		if d.includes(n.Id) {
+			d.queue()
+		}
+	}
+
+	return m, nil
+}
+
+// takes a backup without pruning older ones
+func take(n *node.Node, note string) (*Manifest, error) {
+	lock.Lock()
+	if active[n.Id] {
+		lock.Unlock()
+		return nil, errors.New("a backup of " + n.Id + " is already being taken")
+	} else if restoring[n.Id] && note == "" { // only the safety backup of a restore is taken during it
+		lock.Unlock()
+		return nil, errors.New("a backup is being restored over " + n.Id)
+	} else if collecting {
+		lock.Unlock()
+		return nil, errors.New("unused backup chunks are being deleted")
+	}
+	active[n.Id] = true
+	lock.Unlock()
+
+	defer func() {
+		lock.Lock()
+		delete(active, n.Id)
+		lock.Unlock()
+	}()
+
+	log.Info("Backing up " + n.Config.Name + " (" + n.Id + ")")
+	event.Publish(event.BackupStart, n.Id, nil)
+
+	m, err := backup(n, note)
+	if err != nil {
+		log.Error("Backing up " + n.Config.Name + " (" + n.Id + "): " + err.Error())
+		event.Publish(event.BackupFail, n.Id, map[string]interface{}{"error": err.Error()})
+		return nil, err
+	}
+
+	if m.Format == FormatChunks {
+		log.Info("Backed up " + n.Config.Name + " (" + n.Id + ") as " + m.Id + " (" + strconv.Itoa(len(m.Files)) + " files, " + strconv.FormatInt(m.Size/1024, 10) + "KB of new chunks)")
+	} else {
+		log.Info("Backed up " + n.Config.Name + " (" + n.Id + ") to " + dir + "/" + n.Id + "/" + m.Archive + " (" + strconv.Itoa(len(m.Files)) + " files, " + strconv.FormatInt(m.Size/1024, 10) + "KB)")
+	}
+	event.Publish(event.BackupDone, n.Id, map[string]interface{}{"backup": m.Id, "size": m.Size, "files": len(m.Files)})
+
+	return m, nil
+}
+
+func backup(n *node.Node, note string) (*Manifest, error) {
+	m := &Manifest{
+		Node:     n.Id,
+		Time:     time.Now().UTC(),
+		Format:   FormatOf(n.Id),
+		Note:     note,
+		Excludes: Excludes(n.Id),
+		Files:    []File{},
+	}
+
+	if m.Format != FormatTarGz && m.Format != FormatChunks {
+		return nil, errors.New("unknown format '" + m.Format + "'")
+	}
+
+	m.Id = n.Id + "-" + m.Time.Format("20060102-150405")
+
+	// a second backup within the same second is numbered rather than written over the first
+	for i := 2; taken(n.Id, m.Id, m.Format); i++ {
+		m.Id = n.Id + "-" + m.Time.Format("20060102-150405") + "-" + strconv.Itoa(i)
+	}
+
+	if n.IsRunning() {
+		m.Running = true
+
+		// saving is turned back on however the backup ends
+		defer func() {
+			if _, _, err := n.Command("save-on"); err != nil && n.IsRunning() {
+				log.Error("Turning saving back on for " + n.Config.Name + " (" + n.Id + "): " + err.Error())
+			}
+		}()
+
+		if _, _, err := n.Command("save-off"); err != nil {
+			return nil, errors.New("turning saving off: " + err.Error())
+		}
+
+		if err := flush(n); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := os.MkdirAll(dir+"/"+n.Id, 0700); err != nil {
+		return nil, err
+	}
+
+	if m.Format == FormatChunks {
+		if err := snapshot("nodes/"+n.Id, m); err != nil {
+			return nil, err
+		}
+	} else {
+		m.Archive = m.Id + "." + m.Format
+		path := dir + "/" + n.Id + "/" + m.Archive
+
+		// written under another name so a failed backup is never mistaken for a finished one
+		if err := archive(path+".partial", "nodes/"+n.Id, m); err != nil {
+			os.Remove(path + ".partial")
+			return nil, err
+		}
+
+		if err := os.Rename(path+".partial", path); err != nil {
+			os.Remove(path + ".partial")
+			return nil, err
+		}
+	}
+
+	data, err := json.MarshalIndent(m, "", "    ")
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.WriteFile(dir+"/"+n.Id+"/"+m.Id+".json", data, 0600); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// whether a backup id is already used by a manifest or archive
+func taken(id string, backup string, format string) bool {
+	for _, name := range []string{backup + ".json", backup + "." + format} {
+		if _, err := os.Lstat(dir + "/" + id + "/" + name); err == nil {
+			return true
+		}
+	}
+
+	return false
+}
+
+// runs 'save-all flush' and waits until the server says the world is saved
+func flush(n *node.Node) error {
+	sub := event.Subscribe()
+	defer func() { sub.Unsubscribe() }()
+
+	reply, _, err := n.Command("save-all flush")
+
+	// a large world can take longer to save than rcon waits for a reply, the command was still sent
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		err = nil
+	}
+
+	if err != nil {
+		return errors.New("saving the world: " + err.Error())
+	}
+
+	// over rcon, the reply says when it's done
+	if strings.Contains(reply, "Saved the game") || strings.Contains(reply, "Save complete") {
+		return nil
+	}
+
+	timeout := time.After(saveTimeout)
+	var last uint64
+
+	for {
+		select {
+		case e, ok := <-sub.C:
+			if !ok {
+				// dropped for falling behind, so carry on from what was missed
+				sub = event.Subscribe()
+				for _, e := range event.Since(last) {
+					if e.Node == n.Id && e.Type == event.ServerSave {
+						return nil
+					}
+				}
+				continue
+			}
+
+			last = e.Id
+
+			if e.Node != n.Id {
+				continue
+			}
+
+			switch e.Type {
+			case event.ServerSave:
+				return nil
+			case event.NodeStop, event.NodeCrash:
+				return errors.New("node stopped while saving the world")
+			}
+		case <-timeout:
+			return errors.New("timed out waiting for the world to be saved")
+		}
+	}
+}
+
+// writes a node directory to a gzipped tar, recording each file in the manifest
+func archive(path string, root string, m *Manifest) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	sum := sha256.New()
+	gz := gzip.NewWriter(io.MultiWriter(f, sum))
+	tw := tar.NewWriter(gz)
+
+	err = walk(root, m.Excludes, func(p string, rel string, info fs.FileInfo) error {
+		hdr, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		hdr.Name = rel
+		if info.IsDir() {
+			hdr.Name += "/"
+		}
+
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+
+		if info.IsDir() {
+			return nil
+		}
+
+		src, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer src.Close()
+
+		fileSum := sha256.New()
+		if _, err := io.CopyN(io.MultiWriter(tw, fileSum), src, hdr.Size); err != nil {
+			return errors.New("reading '" + rel + "': " + err.Error())
+		}
+
+		m.Bytes += hdr.Size
+		m.Files = append(m.Files, File{Path: rel, Size: hdr.Size, Mode: info.Mode(), Modified: info.ModTime().UTC(), SHA256: hex.EncodeToString(fileSum.Sum(nil))})
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := tw.Close(); err != nil {
+		return err
+	}
+
+	if err := gz.Close(); err != nil {
+		return err
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	m.Size = info.Size()
+	m.SHA256 = hex.EncodeToString(sum.Sum(nil))
+
+	return f.Sync()
+}
+
+// calls fn for each directory and regular file in a node directory that isn't excluded
+func walk(root string, excludes []string, fn func(p string, rel string, info fs.FileInfo) error) error {
+	return filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, _ := filepath.Rel(root, p)
+		if rel == "." {
+			return nil
+		}
+		rel = filepath.ToSlash(rel)
+
+		if excluded(rel, excludes) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		// links and devices aren't kept, a restore should never write outside the node directory
+		if !info.Mode().IsRegular() && !info.IsDir() {
+			return nil
+		}
+
+		return fn(p, rel, info)
+	})
+}
+
+// whether a path within a node directory matches an exclude, by its whole path or its name
+func excluded(rel string, excludes []string) bool {
+	for _, pattern := range excludes {
+		pattern = strings.Trim(filepath.ToSlash(pattern), "/")
+
+		if ok, _ := filepath.Match(pattern, rel); ok {
+			return true
+		}
+
+		if !strings.Contains(pattern, "/") {
+			if ok, _ := filepath.Match(pattern, filepath.Base(rel)); ok {
+				return true
+			}
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,26 @@
+package backup
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTimeOf(t *testing.T) {
+	taken := time.Date(2024, 6, 10, 12, 30, 5, 0, time.UTC)
+
+	tests := map[string]time.Time{
+		"lobby-20240610-123005":     taken,
+		"lobby-20240610-123005-2":   taken,
+		"lobby-20240610-123005-13":  taken,
+		"my-node-20240610-123005":   taken,
+		"my-node-20240610-123005-2": taken,
+		"short":                     {},
+		"lobby-notatime-2":          {},
+	}
+
+	for id, want := range tests {
+		if got := timeOf(id); !got.Equal(want) {
+			t.Errorf("%s: got %s, expected %s", id, got, want)
+		}
+	}
+}
@@ -0,0 +1,346 @@
+package backup
+
+import (
+	"bufio"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/fs"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"lolarobins.ca/overload/log"
+)
+
+// chunks of every node's backups, stored once each by the hash of their content
+const chunkDir = dir + "/.chunks"
+
+// region files are split at fixed offsets, the server rewrites them a sector at a time, so a change only touches the chunk around it
+const regionChunk = 64 << 10
+
+// other files are split where a rolling hash of their content says, so data inserted part way through doesn't shift every chunk after it
+const minChunk = 256 << 10
+const maxChunk = 4 << 20
+const chunkMask = (1<<20 - 1) << 44 // a cut about once every megabyte past the minimum
+
+// random values the rolling hash adds for each byte, changing them moves every cut so nothing new would match older chunks
+var gear = func() (table [256]uint64) {
+	r := rand.New(rand.NewSource(1))
+	for i := range table {
+		table[i] = r.Uint64()
+	}
+	return
+}()
+
+var errCollectBusy = errors.New("backups are being taken or restored")
+
+type chunker struct {
+	r     *bufio.Reader
+	fixed bool
+	buf   []byte
+}
+
+func newChunker(r io.Reader, name string) *chunker {
+	return &chunker{
+		r:     bufio.NewReaderSize(r, 1<<20),
+		fixed: strings.HasSuffix(name, ".mca") || strings.HasSuffix(name, ".mcr"),
+		buf:   make([]byte, 0, maxChunk),
+	}
+}
+
+// the next chunk of the file, valid until the one after is read, or io.EOF
+func (c *chunker) next() ([]byte, error) {
+	if c.fixed {
+		c.buf = c.buf[:regionChunk]
+
+		n, err := io.ReadFull(c.r, c.buf)
+		if err == io.ErrUnexpectedEOF {
+			err = nil
+		}
+
+		return c.buf[:n], err
+	}
+
+	c.buf = c.buf[:0]
+	var h uint64
+
+	for len(c.buf) < maxChunk {
+		b, err := c.r.ReadByte()
+		if err == io.EOF && len(c.buf) > 0 {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		c.buf = append(c.buf, b)
+		h = h<<1 + gear[b]
+
+		if len(c.buf) >= minChunk && h&chunkMask == 0 {
+			break
+		}
+	}
+
+	return c.buf, nil
+}
+
+func chunkPath(id string) (string, error) {
+	if len(id) != sha256.Size*2 {
+		return "", errors.New("invalid chunk '" + id + "'")
+	}
+
+	if _, err := hex.DecodeString(id); err != nil {
+		return "", errors.New("invalid chunk '" + id + "'")
+	}
+
+	return chunkDir + "/" + id[:2] + "/" + id, nil
+}
+
+// stores the files of a node directory as chunks, recording each file's chunks in the manifest
+func snapshot(root string, m *Manifest) error {
+	return walk(root, m.Excludes, func(p string, rel string, info fs.FileInfo) error {
+		if info.IsDir() {
+			m.Files = append(m.Files, File{Path: rel, Mode: info.Mode(), Modified: info.ModTime().UTC()})
+			return nil
+		}
+
+		src, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer src.Close()
+
+		f := File{Path: rel, Size: info.Size(), Mode: info.Mode(), Modified: info.ModTime().UTC(), Chunks: []string{}}
+		fileSum := sha256.New()
+		c := newChunker(io.LimitReader(src, info.Size()), rel)
+
+		for {
+			data, err := c.next()
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				return errors.New("reading '" + rel + "': " + err.Error())
+			}
+
+			fileSum.Write(data)
+
+			id, added, err := store(data)
+			if err != nil {
+				return errors.New("storing '" + rel + "': " + err.Error())
+			}
+
+			f.Chunks = append(f.Chunks, id)
+			m.Size += added
+		}
+
+		f.SHA256 = hex.EncodeToString(fileSum.Sum(nil))
+		m.Bytes += f.Size
+		m.Files = append(m.Files, f)
+
+		return nil
+	})
+}
+
+// writes a chunk to the store unless it's already there, returning its id and the bytes it added
+func store(data []byte) (string, int64, error) {
+	sum := sha256.Sum256(data)
+	id := hex.EncodeToString(sum[:])
+	path, _ := chunkPath(id)
+
+	if _, err := os.Stat(path); err == nil {
+		return id, 0, nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return "", 0, err
+	}
+
+	// written under another name so a chunk is never seen half written
+	f, err := os.CreateTemp(filepath.Dir(path), ".partial-*")
+	if err != nil {
+		return "", 0, err
+	}
+
+	gz := gzip.NewWriter(f)
+	if _, err := gz.Write(data); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return "", 0, err
+	}
+
+	if err := gz.Close(); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return "", 0, err
+	}
+
+	info, err := f.Stat()
+	if err == nil {
+		err = f.Close()
+	} else {
+		f.Close()
+	}
+
+	if err != nil {
+		os.Remove(f.Name())
+		return "", 0, err
+	}
+
+	if err := os.Rename(f.Name(), path); err != nil {
+		os.Remove(f.Name())
+		return "", 0, err
+	}
+
+	return id, info.Size(), nil
+}
+
+// writes a file of a chunked backup, checking each chunk against its hash
+func readChunks(f File, w io.Writer) error {
+	for _, id := range f.Chunks {
+		if err := readChunk(id, w); err != nil {
+			return errors.New("chunk " + id + " of '" + f.Path + "': " + err.Error())
+		}
+	}
+
+	return nil
+}
+
+func readChunk(id string, w io.Writer) error {
+	path, err := chunkPath(id)
+	if err != nil {
+		return err
+	}
+
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	gz, err := gzip.NewReader(in)
+	if err != nil {
+		return err
+	}
+
+	sum := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(w, sum), gz); err != nil {
+		return err
+	}
+
+	if hex.EncodeToString(sum.Sum(nil)) != id {
+		return errors.New("does not match its hash")
+	}
+
+	return nil
+}
+
+// checks every chunk a chunked backup uses is in the store
+func missing(m *Manifest) error {
+	for _, f := range m.Files {
+		for _, id := range f.Chunks {
+			path, err := chunkPath(id)
+			if err != nil {
+				return err
+			}
+
+			if _, err := os.Stat(path); err != nil {
+				return errors.New("chunk " + id + " of '" + f.Path + "' is missing")
+			}
+		}
+	}
+
+	return nil
+}
+
+// deletes chunks that no backup of any node uses, returning how many and their size
+func Collect() (int, int64, error) {
+	lock.Lock()
+	// chunks of a backup being taken aren't in a manifest until it's done
+	if len(active) > 0 || len(restoring) > 0 || downloads > 0 || collecting {
+		lock.Unlock()
+		return 0, 0, errCollectBusy
+	}
+	collecting = true
+	lock.Unlock()
+
+	defer func() {
+		lock.Lock()
+		collecting = false
+		lock.Unlock()
+	}()
+
+	used := make(map[string]bool)
+
+	nodes, err := os.ReadDir(dir)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	for _, n := range nodes {
+		if !n.IsDir() || strings.HasPrefix(n.Name(), ".") {
+			continue
+		}
+
+		files, err := os.ReadDir(dir + "/" + n.Name())
+		if err != nil {
+			return 0, 0, err
+		}
+
+		for _, f := range files {
+			if f.IsDir() || !strings.HasSuffix(f.Name(), ".json") {
+				continue
+			}
+
+			// a manifest that can't be read might still use any chunk
+			m, err := read(n.Name(), strings.TrimSuffix(f.Name(), ".json"))
+			if err != nil {
+				return 0, 0, errors.New("reading '" + dir + "/" + n.Name() + "/" + f.Name() + "': " + err.Error())
+			}
+
+			for _, file := range m.Files {
+				for _, id := range file.Chunks {
+					used[id] = true
+				}
+			}
+		}
+	}
+
+	removed := 0
+	var size int64
+
+	err = filepath.WalkDir(chunkDir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && p == chunkDir {
+				return filepath.SkipDir
+			}
+			return err
+		}
+
+		if d.IsDir() || used[d.Name()] {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		if err := os.Remove(p); err != nil {
+			return err
+		}
+
+		removed++
+		size += info.Size()
+		return nil
+	})
+
+	if removed > 0 {
+		log.Info("Removed " + strconv.Itoa(removed) + " unused backup chunks (" + strconv.FormatInt(size/1024, 10) + "KB)")
+	}
+
+	return removed, size, err
+}
@@ -0,0 +1,223 @@
+package backup
+
+import (
+	"bufio"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// kept unencrypted at the root of a destination, so the key can be derived from the passphrase again
+type keyFile struct {
+	KDF     string `json:"kdf"`
+	Time    uint32 `json:"time"`
+	Memory  uint32 `json:"memory"` // KiB
+	Threads uint8  `json:"threads"`
+	Salt    string `json:"salt"`
+	Check   string `json:"check"` // tells whether a passphrase is the right one
+}
+
+// keys derived from a destination's passphrase
+type keys struct {
+	data   []byte // encrypts uploads
+	nonces []byte // picks each upload's nonces from its content
+	names  []byte // hides the hashes chunks are named by
+	check  []byte
+}
+
+const keyObject = "overload-key.json"
+
+// each upload starts with this and the nonce prefix, then its content in sealed segments
+var magic = []byte("OVLE\x01")
+
+const segment = 64 << 10
+const prefixSize = 16
+const overhead = 16 // the tag sealed onto each segment
+
+func newKeyFile() keyFile {
+	salt := make([]byte, 16)
+	rand.Read(salt)
+
+	return keyFile{KDF: "argon2id", Time: 3, Memory: 64 * 1024, Threads: 4, Salt: hex.EncodeToString(salt)}
+}
+
+// derives the keys of a key file from a passphrase, filling in its check when it's new
+func (k *keyFile) derive(passphrase string) (*keys, error) {
+	if k.KDF != "argon2id" {
+		return nil, errors.New("unknown key derivation '" + k.KDF + "'")
+	}
+
+	salt, err := hex.DecodeString(k.Salt)
+	if err != nil {
+		return nil, errors.New("invalid salt")
+	}
+
+	master := argon2.IDKey([]byte(passphrase), salt, k.Time, k.Memory, k.Threads, 32)
+
+	ks := &keys{
+		data:   mac(master, []byte("data")),
+		nonces: mac(master, []byte("nonces")),
+		names:  mac(master, []byte("names")),
+		check:  mac(master, []byte("check")),
+	}
+
+	if k.Check == "" {
+		k.Check = hex.EncodeToString(ks.check)
+	} else if check, _ := hex.DecodeString(k.Check); subtle.ConstantTimeCompare(check, ks.check) != 1 {
+		return nil, errors.New("passphrase does not match the one the destination was set up with")
+	}
+
+	return ks, nil
+}
+
+func (k *keyFile) marshal() []byte {
+	data, _ := json.MarshalIndent(k, "", "    ")
+	return data
+}
+
+func mac(key []byte, data []byte) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write(data)
+	return h.Sum(nil)
+}
+
+// name a chunk is stored under, so a destination doesn't show which known content it holds
+func (k *keys) name(id string) string {
+	return hex.EncodeToString(mac(k.names, []byte(id)))
+}
+
+// encrypts r as it's read, the same content under the same name always encrypts to the same bytes, so a failed upload can be carried on from where it stopped
+func (k *keys) seal(name string, sum string, r io.Reader) io.Reader {
+	prefix := mac(k.nonces, []byte(name+"\n"+sum))[:prefixSize]
+	aead, _ := chacha20poly1305.NewX(k.data)
+
+	return &sealer{aead: aead, src: bufio.NewReaderSize(r, segment), prefix: prefix, out: append(append([]byte{}, magic...), prefix...)}
+}
+
+// decrypts what seal wrote, failing if any of it was changed, reordered or cut short
+func (k *keys) open(r io.Reader) io.Reader {
+	aead, _ := chacha20poly1305.NewX(k.data)
+
+	return &opener{aead: aead, src: bufio.NewReaderSize(r, segment+overhead)}
+}
+
+type sealer struct {
+	aead    cipher.AEAD
+	src     *bufio.Reader
+	prefix  []byte
+	counter uint64
+	out     []byte
+	done    bool
+}
+
+type opener struct {
+	aead    cipher.AEAD
+	src     *bufio.Reader
+	prefix  []byte
+	counter uint64
+	out     []byte
+	done    bool
+}
+
+// nonce of a segment, the last one is marked so a cut short upload doesn't decrypt
+func nonce(prefix []byte, counter uint64, last bool) []byte {
+	n := make([]byte, chacha20poly1305.NonceSizeX)
+	copy(n, prefix)
+
+	if last {
+		counter |= 1 << 63
+	}
+	binary.BigEndian.PutUint64(n[prefixSize:], counter)
+
+	return n
+}
+
+func (s *sealer) Read(p []byte) (int, error) {
+	for len(s.out) == 0 {
+		if s.done {
+			return 0, io.EOF
+		}
+
+		buf := make([]byte, segment)
+		n, err := io.ReadFull(s.src, buf)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return 0, err
+		}
+
+		last := err != nil
+		if !last {
+			if _, err := s.src.Peek(1); err == io.EOF {
+				last = true
+			} else if err != nil {
+				return 0, err
+			}
+		}
+
+		s.out = s.aead.Seal(nil, nonce(s.prefix, s.counter, last), buf[:n], nil)
+		s.counter++
+		s.done = last
+	}
+
+	n := copy(p, s.out)
+	s.out = s.out[n:]
+	return n, nil
+}
+
+func (o *opener) Read(p []byte) (int, error) {
+	if o.prefix == nil {
+		header := make([]byte, len(magic)+prefixSize)
+		if _, err := io.ReadFull(o.src, header); err != nil {
+			return 0, errors.New("not encrypted by overload")
+		}
+
+		if string(header[:len(magic)]) != string(magic) {
+			return 0, errors.New("not encrypted by overload")
+		}
+
+		o.prefix = header[len(magic):]
+	}
+
+	for len(o.out) == 0 {
+		if o.done {
+			return 0, io.EOF
+		}
+
+		buf := make([]byte, segment+overhead)
+		n, err := io.ReadFull(o.src, buf)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return 0, err
+		}
+
+		last := err != nil
+		if !last {
+			if _, err := o.src.Peek(1); err == io.EOF {
+				last = true
+			} else if err != nil {
+				return 0, err
+			}
+		}
+
+		out, err := o.aead.Open(nil, nonce(o.prefix, o.counter, last), buf[:n], nil)
+		if err != nil {
+			return 0, errors.New("cannot be decrypted, it was changed or cut short")
+		}
+
+		o.out = out
+		o.counter++
+		o.done = last
+	}
+
+	n := copy(p, o.out)
+	o.out = o.out[n:]
+	return n, nil
+}
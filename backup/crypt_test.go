@@ -0,0 +1,154 @@
+package backup
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"testing"
+)
+
+func testKeys(t *testing.T) *keys {
+	k := keyFile{KDF: "argon2id", Time: 1, Memory: 64, Threads: 1, Salt: "00112233445566778899aabbccddeeff"}
+
+	ks, err := k.derive("passphrase")
+	if err != nil {
+		t.Fatal("deriving keys: " + err.Error())
+	}
+
+	return ks
+}
+
+func sealed(t *testing.T, ks *keys, data []byte) []byte {
+	out, err := io.ReadAll(ks.seal("name", "sum", bytes.NewReader(data)))
+	if err != nil {
+		t.Fatal("sealing: " + err.Error())
+	}
+
+	return out
+}
+
+func random(size int) []byte {
+	data := make([]byte, size)
+	rand.Read(data)
+	return data
+}
+
+func TestSealRoundTrip(t *testing.T) {
+	ks := testKeys(t)
+
+	sizes := map[string]int{
+		"empty":                0,
+		"one byte":             1,
+		"under a segment":      segment - 1,
+		"exactly one segment":  segment,
+		"over a segment":       segment + 1,
+		"exactly two segments": 2 * segment,
+		"several segments":     3*segment + 1234,
+	}
+
+	for name, size := range sizes {
+		data := random(size)
+		enc := sealed(t, ks, data)
+
+		// each segment, including an empty last one, carries a tag
+		segments := size/segment + 1
+		if size > 0 && size%segment == 0 {
+			segments = size / segment
+		}
+
+		if want := len(magic) + prefixSize + size + segments*overhead; len(enc) != want {
+			t.Errorf("%s: sealed to %d bytes, expected %d", name, len(enc), want)
+		}
+
+		dec, err := io.ReadAll(ks.open(bytes.NewReader(enc)))
+		if err != nil {
+			t.Errorf("%s: opening: %s", name, err)
+			continue
+		}
+
+		if !bytes.Equal(dec, data) {
+			t.Errorf("%s: opened to different content", name)
+		}
+	}
+}
+
+func TestSealDeterministic(t *testing.T) {
+	ks := testKeys(t)
+	data := random(segment + 10)
+
+	// a resumed upload relies on the same content sealing to the same bytes
+	if !bytes.Equal(sealed(t, ks, data), sealed(t, ks, data)) {
+		t.Error("the same content under the same name sealed differently")
+	}
+
+	other, _ := io.ReadAll(ks.seal("other", "sum", bytes.NewReader(data)))
+	if bytes.Equal(sealed(t, ks, data), other) {
+		t.Error("the same content under different names sealed the same")
+	}
+}
+
+func TestOpenRejects(t *testing.T) {
+	ks := testKeys(t)
+	header := len(magic) + prefixSize
+	block := segment + overhead
+
+	enc := sealed(t, ks, random(3*segment+100))
+	single := sealed(t, ks, random(segment))
+
+	reordered := append([]byte{}, enc...)
+	copy(reordered[header:], enc[header+block:header+2*block])
+	copy(reordered[header+block:], enc[header:header+block])
+
+	flipped := append([]byte{}, enc...)
+	flipped[header+10] ^= 1
+
+	tests := map[string][]byte{
+		"empty":                       {},
+		"only a header":               enc[:header],
+		"cut at a segment boundary":   enc[:header+block],
+		"cut at two segments":         enc[:header+2*block],
+		"cut within a segment":        enc[:header+block+100],
+		"last segment dropped":        enc[:len(enc)-(len(enc)-header)%block],
+		"one segment cut short":       single[:len(single)-1],
+		"one segment with extra data": append(append([]byte{}, single...), 0),
+		"segments reordered":          reordered,
+		"changed byte":                flipped,
+		"wrong magic":                 append([]byte("XXXXX"), enc[len(magic):]...),
+	}
+
+	for name, data := range tests {
+		if _, err := io.ReadAll(ks.open(bytes.NewReader(data))); err == nil {
+			t.Errorf("%s: opened without an error", name)
+		}
+	}
+
+	other := keyFile{KDF: "argon2id", Time: 1, Memory: 64, Threads: 1, Salt: "ffeeddccbbaa99887766554433221100"}
+	otherKeys, err := other.derive("passphrase")
+	if err != nil {
+		t.Fatal("deriving keys: " + err.Error())
+	}
+
+	if _, err := io.ReadAll(otherKeys.open(bytes.NewReader(enc))); err == nil {
+		t.Error("opened with the keys of another destination")
+	}
+}
+
+func TestDeriveChecksPassphrase(t *testing.T) {
+	k := keyFile{KDF: "argon2id", Time: 1, Memory: 64, Threads: 1, Salt: "00112233445566778899aabbccddeeff"}
+
+	if _, err := k.derive("passphrase"); err != nil {
+		t.Fatal("deriving keys: " + err.Error())
+	}
+
+	if k.Check == "" {
+		t.Fatal("a new key file wasn't given a check")
+	}
+
+	if _, err := k.derive("passphrase"); err != nil {
+		t.Error("the same passphrase was rejected: " + err.Error())
+	}
+
+	if _, err := k.derive("another"); err == nil {
+		t.Error("a different passphrase was accepted")
+	}
+}
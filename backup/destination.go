@@ -0,0 +1,711 @@
+package backup
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"lolarobins.ca/overload/log"
+)
+
+// somewhere backups are copied to after they're taken, with its own retention
+type Destination struct {
+	Name       string     `json:"name"`
+	Type       string     `json:"type"`                 // 'dir', 's3' or 'sftp'
+	Path       string     `json:"path"`                 // directory, or prefix within the bucket
+	Nodes      []string   `json:"nodes,omitempty"`      // nodes copied, every node when empty
+	Retention  *Retention `json:"retention,omitempty"`  // in place of each node's own
+	Passphrase string     `json:"passphrase,omitempty"` // encrypts what's uploaded when set
+
+	Endpoint  string `json:"endpoint,omitempty"` // of s3, such as 'https://s3.us-east-1.amazonaws.com' or a MinIO server
+	Region    string `json:"region,omitempty"`
+	Bucket    string `json:"bucket,omitempty"`
+	AccessKey string `json:"accesskey,omitempty"`
+	SecretKey string `json:"secretkey,omitempty"`
+
+	Host     string `json:"host,omitempty"` // of sftp, as host:port
+	User     string `json:"user,omitempty"`
+	Password string `json:"password,omitempty"`
+	KeyFile  string `json:"keyfile,omitempty"` // private key to log in with
+	HostKey  string `json:"hostkey,omitempty"` // the server's public key as in authorized_keys
+}
+
+// where a destination stores things, keys are slash separated paths
+type remote interface {
+	// writes an object from the start of r, carrying on from whatever a failed upload of it left behind
+	put(key string, r io.Reader) error
+	get(key string) (io.ReadCloser, error)
+	// every key held
+	list() ([]string, error)
+	remove(key string) error
+	close() error
+}
+
+type destination struct {
+	Destination
+	lock    sync.Mutex // held while connected
+	trigger chan struct{}
+	keys    *keys
+	synced  time.Time
+	err     error
+}
+
+const retryMin = time.Minute
+const retryMax = time.Hour
+
+var errNotFound = errors.New("does not exist")
+
+var destinations = []*destination{}
+
+// checks the destinations in the config and starts copying backups to them
+func startDestinations() error {
+	names := make(map[string]bool)
+
+	for _, c := range Settings.Destinations {
+		if c.Name == "" || names[c.Name] {
+			return errors.New("fatal: destinations in '" + file + "' need unique names")
+		}
+		names[c.Name] = true
+
+		if c.Type != "dir" && c.Type != "s3" && c.Type != "sftp" {
+			return errors.New("fatal: destination '" + c.Name + "' has unknown type '" + c.Type + "'")
+		}
+
+		d := &destination{Destination: c, trigger: make(chan struct{}, 1)}
+		destinations = append(destinations, d)
+
+		go d.run()
+		d.queue()
+	}
+
+	return nil
+}
+
+// the destination with a name
+func getDestination(name string) (*destination, error) {
+	for _, d := range destinations {
+		if d.Name == name {
+			return d, nil
+		}
+	}
+
+	return nil, errors.New("destination '" + name + "' does not exist")
+}
+
+func (d *destination) includes(id string) bool {
+	if len(d.Nodes) == 0 {
+		return true
+	}
+
+	for _, n := range d.Nodes {
+		if n == id {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (d *destination) retention(id string) Retention {
+	if d.Retention != nil {
+		return *d.Retention
+	}
+
+	return RetentionOf(id)
+}
+
+// syncs the destination soon, once for however many times it's asked before then
+func (d *destination) queue() {
+	select {
+	case d.trigger <- struct{}{}:
+	default:
+	}
+}
+
+// syncs whenever asked, retrying failures with a growing wait
+func (d *destination) run() {
+	var retry <-chan time.Time
+	wait := retryMin
+
+	for {
+		select {
+		case <-d.trigger:
+		case <-retry:
+		}
+
+		if err := d.sync(); err != nil {
+			log.Error("Copying backups to " + d.Name + ": " + err.Error() + ", retrying in " + wait.String())
+
+			retry = time.After(wait)
+			if wait *= 2; wait > retryMax {
+				wait = retryMax
+			}
+		} else {
+			retry = nil
+			wait = retryMin
+		}
+	}
+}
+
+func (d *destination) connect() (remote, error) {
+	var r remote
+	var err error
+
+	switch d.Type {
+	case "s3":
+		r, err = openS3(d.Destination)
+	case "sftp":
+		r, err = openSFTP(d.Destination)
+	default:
+		r, err = openDir(d.Destination)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if d.Passphrase != "" && d.keys == nil {
+		if d.keys, err = d.loadKeys(r); err != nil {
+			r.close()
+			return nil, err
+		}
+	}
+
+	return r, nil
+}
+
+// derives the keys from the passphrase and the key file, writing a new key file when the destination doesn't have one yet
+func (d *destination) loadKeys(r remote) (*keys, error) {
+	k := newKeyFile()
+
+	rc, err := r.get(keyObject)
+	if err == errNotFound {
+		ks, err := k.derive(d.Passphrase)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := r.put(keyObject, bytes.NewReader(k.marshal())); err != nil {
+			return nil, errors.New("writing key file: " + err.Error())
+		}
+
+		return ks, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	data, err := io.ReadAll(rc)
+	if err != nil {
+		return nil, err
+	}
+
+	k = keyFile{}
+	if err := json.Unmarshal(data, &k); err != nil {
+		return nil, errors.New("key file cannot be parsed")
+	}
+
+	return k.derive(d.Passphrase)
+}
+
+// uploads the backups the destination's retention keeps that it doesn't have yet, oldest first, and deletes the ones it no longer keeps
+func (d *destination) sync() error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	r, err := d.connect()
+	if err != nil {
+		d.err = err
+		return err
+	}
+	defer r.close()
+
+	err = d.reconcile(r)
+
+	d.err = err
+	if err == nil {
+		d.synced = time.Now()
+	}
+
+	return err
+}
+
+func (d *destination) reconcile(r remote) error {
+	all, err := r.list()
+	if err != nil {
+		return err
+	}
+
+	manifests := make(map[string]map[string]bool) // backups with a manifest by node
+	objects := make(map[string]bool)
+	chunks := make(map[string]bool)
+
+	for _, key := range all {
+		objects[key] = true
+
+		parts := strings.Split(key, "/")
+		if parts[0] == ".chunks" {
+			chunks[key] = true
+		} else if len(parts) == 2 && strings.HasSuffix(parts[1], ".json") {
+			if manifests[parts[0]] == nil {
+				manifests[parts[0]] = make(map[string]bool)
+			}
+			manifests[parts[0]][strings.TrimSuffix(parts[1], ".json")] = true
+		}
+	}
+
+	nodes := make(map[string]bool)
+	for id := range manifests {
+		nodes[id] = true
+	}
+
+	if local, err := os.ReadDir(dir); err == nil {
+		for _, e := range local {
+			if e.IsDir() && !strings.HasPrefix(e.Name(), ".") {
+				nodes[e.Name()] = true
+			}
+		}
+	}
+
+	ids := []string{}
+	for id := range nodes {
+		if d.includes(id) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+
+	uploaded, removed := 0, 0
+	collect := false
+
+	for _, id := range ids {
+		local, err := List(id)
+		if err != nil {
+			return err
+		}
+
+		combined := append([]Manifest{}, local...)
+		have := make(map[string]bool)
+		for _, m := range local {
+			have[m.Id] = true
+		}
+
+		for backup := range manifests[id] {
+			if !have[backup] {
+				combined = append(combined, Manifest{Id: backup, Node: id, Time: timeOf(backup)})
+			}
+		}
+
+		keep := make(map[string]bool)
+		if r := d.retention(id); r == (Retention{}) {
+			for _, m := range combined {
+				keep[m.Id] = true
+			}
+		} else {
+			keep = Keep(combined, r)
+		}
+
+		for i := range local {
+			if !keep[local[i].Id] || manifests[id][local[i].Id] {
+				continue
+			}
+
+			if err := d.upload(r, &local[i], chunks); err != nil {
+				return errors.New("uploading " + local[i].Id + ": " + err.Error())
+			}
+
+			if manifests[id] == nil {
+				manifests[id] = make(map[string]bool)
+			}
+			manifests[id][local[i].Id] = true
+			uploaded++
+		}
+
+		for backup := range manifests[id] {
+			if keep[backup] {
+				continue
+			}
+
+			// the manifest goes first, so a backup is never listed without all of it
+			if err := r.remove(id + "/" + backup + ".json"); err != nil {
+				return err
+			}
+
+			if objects[id+"/"+backup+"."+FormatTarGz] {
+				if err := r.remove(id + "/" + backup + "." + FormatTarGz); err != nil {
+					return err
+				}
+			} else {
+				collect = true
+			}
+
+			delete(manifests[id], backup)
+			removed++
+		}
+
+		// archives of uploads that failed part way and aren't wanted any more
+		for key := range objects {
+			if !strings.HasPrefix(key, id+"/") || !strings.HasSuffix(key, "."+FormatTarGz) {
+				continue
+			}
+
+			backup := strings.TrimSuffix(strings.TrimPrefix(key, id+"/"), "."+FormatTarGz)
+			if !manifests[id][backup] && !keep[backup] {
+				if err := r.remove(key); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	if collect {
+		if err := d.collect(r, manifests, chunks); err != nil {
+			return errors.New("deleting unused chunks: " + err.Error())
+		}
+	}
+
+	if uploaded > 0 || removed > 0 {
+		log.Info("Copied " + strconv.Itoa(uploaded) + " backups to " + d.Name + " and removed " + strconv.Itoa(removed) + " past their retention")
+	}
+
+	return nil
+}
+
+// time a backup was taken, from its id, which may be numbered after the time when taken within the same second
+func timeOf(backup string) time.Time {
+	if i := strings.LastIndex(backup, "-"); i >= 0 && len(backup)-i <= 4 {
+		if _, err := strconv.Atoi(backup[i+1:]); err == nil {
+			backup = backup[:i]
+		}
+	}
+
+	if len(backup) < 15 {
+		return time.Time{}
+	}
+
+	t, _ := time.Parse("20060102-150405", backup[len(backup)-15:])
+	return t
+}
+
+// key of a chunk at the destination
+func (d *destination) chunkKey(id string) string {
+	name := id
+	if d.keys != nil {
+		name = d.keys.name(id)
+	}
+
+	return ".chunks/" + name[:2] + "/" + name
+}
+
+// uploads a backup's archive or the chunks the destination doesn't have, then its manifest
+func (d *destination) upload(r remote, m *Manifest, chunks map[string]bool) error {
+	if m.Format == FormatChunks {
+		for _, f := range m.Files {
+			for _, id := range f.Chunks {
+				key := d.chunkKey(id)
+				if chunks[key] {
+					continue
+				}
+
+				path, err := chunkPath(id)
+				if err != nil {
+					return err
+				}
+
+				if err := d.putFile(r, key, path, ""); err != nil {
+					return err
+				}
+
+				chunks[key] = true
+			}
+		}
+	} else if err := d.putFile(r, m.Node+"/"+m.Archive, dir+"/"+m.Node+"/"+m.Archive, m.SHA256); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(m, "", "    ")
+	if err != nil {
+		return err
+	}
+
+	sum := sha256.Sum256(data)
+	return d.put(r, m.Node+"/"+m.Id+".json", hex.EncodeToString(sum[:]), bytes.NewReader(data))
+}
+
+// uploads a file, sum is its hash if it's already known
+func (d *destination) putFile(r remote, key string, path string, sum string) error {
+	if sum == "" && d.keys != nil {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+
+		hash := sha256.New()
+		_, err = io.Copy(hash, f)
+		f.Close()
+		if err != nil {
+			return err
+		}
+
+		sum = hex.EncodeToString(hash.Sum(nil))
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return d.put(r, key, sum, f)
+}
+
+func (d *destination) put(r remote, key string, sum string, src io.Reader) error {
+	if d.keys != nil {
+		src = d.keys.seal(key, sum, src)
+	}
+
+	return r.put(key, src)
+}
+
+// reads an object, decrypting it when the destination is encrypted
+func (d *destination) get(r remote, key string) (io.ReadCloser, error) {
+	rc, err := r.get(key)
+	if err != nil || d.keys == nil {
+		return rc, err
+	}
+
+	return struct {
+		io.Reader
+		io.Closer
+	}{d.keys.open(rc), rc}, nil
+}
+
+func (d *destination) manifest(r remote, id string, backup string) (*Manifest, error) {
+	rc, err := d.get(r, id+"/"+backup+".json")
+	if err == errNotFound {
+		return nil, errors.New("backup '" + backup + "' of " + id + " is not at " + d.Name)
+	} else if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	data, err := io.ReadAll(rc)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &Manifest{}
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, errors.New("manifest of " + backup + " cannot be parsed")
+	}
+
+	return m, nil
+}
+
+// deletes chunks that no backup at the destination uses
+func (d *destination) collect(r remote, manifests map[string]map[string]bool, chunks map[string]bool) error {
+	used := make(map[string]bool)
+
+	for id, backups := range manifests {
+		for backup := range backups {
+			m, err := d.manifest(r, id, backup)
+			if err != nil {
+				return err
+			}
+
+			for _, f := range m.Files {
+				for _, c := range f.Chunks {
+					used[d.chunkKey(c)] = true
+				}
+			}
+		}
+	}
+
+	for key := range chunks {
+		if used[key] {
+			continue
+		}
+
+		if err := r.remove(key); err != nil {
+			return err
+		}
+
+		delete(chunks, key)
+	}
+
+	return nil
+}
+
+// backups of a node at the destination, oldest first
+func (d *destination) list(id string) ([]Manifest, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	r, err := d.connect()
+	if err != nil {
+		return nil, err
+	}
+	defer r.close()
+
+	all, err := r.list()
+	if err != nil {
+		return nil, err
+	}
+
+	manifests := []Manifest{}
+	for _, key := range all {
+		if !strings.HasPrefix(key, id+"/") || !strings.HasSuffix(key, ".json") {
+			continue
+		}
+
+		m, err := d.manifest(r, id, strings.TrimSuffix(strings.TrimPrefix(key, id+"/"), ".json"))
+		if err != nil {
+			return nil, err
+		}
+
+		manifests = append(manifests, *m)
+	}
+
+	sort.Slice(manifests, func(i, j int) bool { return manifests[i].Time.Before(manifests[j].Time) })
+
+	return manifests, nil
+}
+
+// copies a backup from the destination into the local backups, so it can be restored
+func (d *destination) download(id string, backup string) (*Manifest, error) {
+	if _, err := Get(id, backup); err == nil {
+		return nil, errors.New("backup '" + backup + "' of " + id + " is already here")
+	}
+
+	lock.Lock()
+	if collecting {
+		lock.Unlock()
+		return nil, errors.New("unused backup chunks are being deleted")
+	}
+	// chunks aren't used by any manifest here until the download is done
+	downloads++
+	lock.Unlock()
+
+	defer func() {
+		lock.Lock()
+		downloads--
+		lock.Unlock()
+	}()
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	r, err := d.connect()
+	if err != nil {
+		return nil, err
+	}
+	defer r.close()
+
+	m, err := d.manifest(r, id, backup)
+	if err != nil {
+		return nil, err
+	}
+
+	if m.Id != backup || m.Node != id {
+		return nil, errors.New("manifest of " + backup + " is for another backup")
+	}
+
+	if err := os.MkdirAll(dir+"/"+id, 0700); err != nil {
+		return nil, err
+	}
+
+	if m.Format == FormatChunks {
+		for _, f := range m.Files {
+			for _, c := range f.Chunks {
+				path, err := chunkPath(c)
+				if err != nil {
+					return nil, err
+				}
+
+				if _, err := os.Stat(path); err == nil {
+					continue
+				}
+
+				if err := d.fetch(r, d.chunkKey(c), path); err != nil {
+					return nil, err
+				}
+
+				if err := readChunk(c, io.Discard); err != nil {
+					os.Remove(path)
+					return nil, errors.New("chunk " + c + ": " + err.Error())
+				}
+			}
+		}
+	} else {
+		if m.Archive == "" || strings.ContainsAny(m.Archive, "/\\") {
+			return nil, errors.New("manifest of " + backup + " has an invalid archive")
+		}
+
+		path := dir + "/" + id + "/" + m.Archive
+		if err := d.fetch(r, id+"/"+m.Archive, path); err != nil {
+			return nil, err
+		}
+
+		if err := verify(path, m.SHA256); err != nil {
+			os.Remove(path)
+			return nil, err
+		}
+	}
+
+	data, err := json.MarshalIndent(m, "", "    ")
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.WriteFile(dir+"/"+id+"/"+m.Id+".json", data, 0600); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// downloads an object to a file, written under another name until it's complete
+func (d *destination) fetch(r remote, key string, path string) error {
+	rc, err := d.get(r, key)
+	if err != nil {
+		return errors.New("downloading '" + key + "': " + err.Error())
+	}
+	defer rc.Close()
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(path), ".partial-*")
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(f, rc); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return errors.New("downloading '" + key + "': " + err.Error())
+	}
+
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+
+	if err := os.Rename(f.Name(), path); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,150 @@
+package backup
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// a directory, such as a mounted network share or another disk
+type dirRemote struct {
+	root string
+}
+
+func openDir(d Destination) (remote, error) {
+	if d.Path == "" {
+		return nil, errors.New("no path set")
+	}
+
+	if err := os.MkdirAll(d.Path, 0700); err != nil {
+		return nil, err
+	}
+
+	return &dirRemote{root: d.Path}, nil
+}
+
+func (r *dirRemote) put(key string, src io.Reader) error {
+	path := filepath.Join(r.root, filepath.FromSlash(key))
+	partial := path + ".partial"
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(partial, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+
+	sum, err := resume(f, src)
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	// read back from the disk rather than trusting what was written
+	if err := checkFile(partial, sum); err != nil {
+		os.Remove(partial)
+		return err
+	}
+
+	return os.Rename(partial, path)
+}
+
+func (r *dirRemote) get(key string) (io.ReadCloser, error) {
+	f, err := os.Open(filepath.Join(r.root, filepath.FromSlash(key)))
+	if os.IsNotExist(err) {
+		return nil, errNotFound
+	}
+
+	return f, err
+}
+
+func (r *dirRemote) list() ([]string, error) {
+	keys := []string{}
+
+	err := filepath.WalkDir(r.root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if d.IsDir() || strings.HasSuffix(p, ".partial") {
+			return nil
+		}
+
+		rel, _ := filepath.Rel(r.root, p)
+		keys = append(keys, filepath.ToSlash(rel))
+		return nil
+	})
+
+	return keys, err
+}
+
+func (r *dirRemote) remove(key string) error {
+	err := os.Remove(filepath.Join(r.root, filepath.FromSlash(key)))
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	return err
+}
+
+func (r *dirRemote) close() error {
+	return nil
+}
+
+// appends src to a partly uploaded file, skipping what the file already holds, returning the hash of all of src
+func resume(f interface {
+	io.Writer
+	Stat() (os.FileInfo, error)
+}, src io.Reader) (string, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.New()
+
+	if _, err := io.CopyN(sum, src, info.Size()); err != nil && err != io.EOF {
+		return "", err
+	}
+
+	if _, err := io.Copy(io.MultiWriter(f, sum), src); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(sum.Sum(nil)), nil
+}
+
+func checkFile(path string, sum string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return err
+	}
+
+	if hex.EncodeToString(hash.Sum(nil)) != sum {
+		return errors.New("written file does not match what was uploaded")
+	}
+
+	return nil
+}
@@ -0,0 +1,115 @@
+package backup
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDirPut(t *testing.T) {
+	r := &dirRemote{root: t.TempDir()}
+	data := random(3*segment + 7)
+
+	if err := r.put("a/b", bytes.NewReader(data)); err != nil {
+		t.Fatal("putting: " + err.Error())
+	}
+
+	got, err := os.ReadFile(filepath.Join(r.root, "a", "b"))
+	if err != nil || !bytes.Equal(got, data) {
+		t.Fatal("put wrote different content")
+	}
+
+	if _, err := os.Stat(filepath.Join(r.root, "a", "b.partial")); !os.IsNotExist(err) {
+		t.Error("partial file left behind")
+	}
+
+	keys, err := r.list()
+	if err != nil || len(keys) != 1 || keys[0] != "a/b" {
+		t.Errorf("listed %v, expected [a/b]", keys)
+	}
+}
+
+func TestDirResume(t *testing.T) {
+	r := &dirRemote{root: t.TempDir()}
+	data := random(2*segment + 100)
+	path := filepath.Join(r.root, "key")
+
+	// the start of the same content, as left by an upload that stopped
+	if err := os.WriteFile(path+".partial", data[:segment+3], 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := r.put("key", bytes.NewReader(data)); err != nil {
+		t.Fatal("resuming: " + err.Error())
+	}
+
+	if got, _ := os.ReadFile(path); !bytes.Equal(got, data) {
+		t.Error("resumed upload has different content")
+	}
+}
+
+func TestDirResumeStale(t *testing.T) {
+	data := random(2*segment + 100)
+
+	partials := map[string][]byte{
+		"different content": random(segment),
+		"longer than it":    random(len(data) + 50),
+		"same length":       random(len(data)),
+	}
+
+	for name, partial := range partials {
+		r := &dirRemote{root: t.TempDir()}
+		path := filepath.Join(r.root, "key")
+
+		if err := os.WriteFile(path+".partial", partial, 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		// a stale partial is never moved into place, and is removed so the next attempt starts over
+		if err := r.put("key", bytes.NewReader(data)); err == nil {
+			t.Errorf("%s: put over a stale partial succeeded", name)
+		}
+
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("%s: stale content was stored", name)
+		}
+
+		if _, err := os.Stat(path + ".partial"); !os.IsNotExist(err) {
+			t.Errorf("%s: stale partial was kept", name)
+		}
+
+		if err := r.put("key", bytes.NewReader(data)); err != nil {
+			t.Errorf("%s: retrying: %s", name, err)
+			continue
+		}
+
+		if got, _ := os.ReadFile(path); !bytes.Equal(got, data) {
+			t.Errorf("%s: retried upload has different content", name)
+		}
+	}
+}
+
+func TestResumeHash(t *testing.T) {
+	data := random(segment + 5)
+	f, err := os.CreateTemp(t.TempDir(), "partial")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	f.Write(data[:10])
+
+	sum, err := resume(f, bytes.NewReader(data))
+	if err != nil {
+		t.Fatal("resuming: " + err.Error())
+	}
+
+	// the hash covers all of the source, not only what was appended
+	hash := sha256.Sum256(data)
+	if want := hex.EncodeToString(hash[:]); sum != want {
+		t.Errorf("hash %s, expected %s", sum, want)
+	}
+}
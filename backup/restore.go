@@ -0,0 +1,406 @@
+package backup
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"lolarobins.ca/overload/event"
+	"lolarobins.ca/overload/log"
+	"lolarobins.ca/overload/node"
+)
+
+// restores one of a node's backups over the node, which has to be stopped, or into a new node with the target id
+func Restore(n *node.Node, backup string, target string) (*node.Node, error) {
+	restored, err := restore(n, backup, target)
+	if err != nil {
+		log.Error("Restoring " + backup + " to " + target + ": " + err.Error())
+		return nil, err
+	}
+
+	log.Info("Restored " + backup + " to " + restored.Config.Name + " (" + restored.Id + ")")
+	event.Publish(event.BackupRestore, restored.Id, map[string]interface{}{"backup": backup, "node": n.Id})
+
+	return restored, nil
+}
+
+func restore(n *node.Node, backup string, target string) (*node.Node, error) {
+	m, err := Get(n.Id, backup)
+	if err != nil {
+		return nil, err
+	}
+
+	if m.Format != FormatTarGz && m.Format != FormatChunks {
+		return nil, errors.New("backups in the '" + m.Format + "' format cannot be restored")
+	}
+
+	overwrite := target == n.Id
+
+	if !overwrite && (target == "" || strings.ContainsAny(target, "/\\") || strings.HasPrefix(target, ".")) {
+		return nil, errors.New("invalid node id")
+	}
+
+	lock.Lock()
+	if restoring[target] {
+		lock.Unlock()
+		return nil, errors.New("a backup is already being restored to " + target)
+	} else if collecting {
+		lock.Unlock()
+		return nil, errors.New("unused backup chunks are being deleted")
+	}
+	restoring[target] = true
+	lock.Unlock()
+
+	defer func() {
+		lock.Lock()
+		delete(restoring, target)
+		lock.Unlock()
+	}()
+
+	if overwrite {
+		if n.IsRunning() {
+			return nil, errors.New("node is running, stop it before restoring over it")
+		}
+	} else {
+
+		if _, err := node.Get(target); err == nil {
+			return nil, errors.New("node '" + target + "' already exists")
+		}
+
+		if _, err := os.Stat("nodes/" + target); err == nil {
+			return nil, errors.New("'nodes/" + target + "' already exists")
+		}
+	}
+
+	// chunks are checked as they're read, so a damaged backup is only found part way through
+	if m.Format == FormatChunks {
+		if err := missing(m); err != nil {
+			return nil, err
+		}
+	} else if err := verify(dir+"/"+n.Id+"/"+m.Archive, m.SHA256); err != nil {
+		return nil, err
+	}
+
+	if overwrite && Settings.SafetyBackup {
+		if _, err := take(n, "before restoring "+m.Id); err != nil {
+			return nil, errors.New("taking a safety backup: " + err.Error())
+		}
+	}
+
+	log.Info("Restoring " + m.Id + " to " + target)
+
+	// extracted beside the node directory first, dotfiles aren't loaded as nodes
+	tmp := "nodes/." + target + ".restore"
+	os.RemoveAll(tmp)
+
+	if err := unpack(m, tmp); err != nil {
+		os.RemoveAll(tmp)
+		return nil, err
+	}
+
+	if !overwrite {
+		if err := os.Rename(tmp, "nodes/"+target); err != nil {
+			os.RemoveAll(tmp)
+			return nil, err
+		}
+
+		return node.Adopt(target)
+	}
+
+	// what backups leave out, such as logs and downloaded libraries, is kept from the current directory
+	if err := carry("nodes/"+target, tmp, m.Excludes); err != nil {
+		os.RemoveAll(tmp)
+		return nil, err
+	}
+
+	if n.IsRunning() {
+		os.RemoveAll(tmp)
+		return nil, errors.New("node was started while restoring")
+	}
+
+	old := "nodes/." + target + ".old"
+	os.RemoveAll(old)
+
+	if err := os.Rename("nodes/"+target, old); err != nil {
+		os.RemoveAll(tmp)
+		return nil, err
+	}
+
+	if err := os.Rename(tmp, "nodes/"+target); err != nil {
+		os.Rename(old, "nodes/"+target)
+		os.RemoveAll(tmp)
+		return nil, err
+	}
+
+	os.RemoveAll(old)
+
+	if err := n.ReloadConfig(); err != nil {
+		return nil, err
+	}
+
+	return n, nil
+}
+
+// checks that every file of a backup can be read back as it was when it was taken
+func Verify(m *Manifest) error {
+	if m.Format == FormatChunks {
+		for _, f := range m.Files {
+			if f.Mode.IsDir() {
+				continue
+			}
+
+			if err := readFile(f, io.Discard); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	archive := dir + "/" + m.Node + "/" + m.Archive
+	if err := verify(archive, m.SHA256); err != nil {
+		return err
+	}
+
+	files := make(map[string]File)
+	for _, f := range m.Files {
+		files[f.Path] = f
+	}
+
+	in, err := os.Open(archive)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	gz, err := gzip.NewReader(in)
+	if err != nil {
+		return err
+	}
+
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+
+		f, ok := files[hdr.Name]
+		if !ok {
+			continue
+		}
+		delete(files, hdr.Name)
+
+		sum := sha256.New()
+		if _, err := io.Copy(sum, tr); err != nil {
+			return err
+		}
+
+		if hex.EncodeToString(sum.Sum(nil)) != f.SHA256 {
+			return errors.New("'" + f.Path + "' does not match its hash")
+		}
+	}
+
+	for path := range files {
+		return errors.New("'" + path + "' is missing from the archive")
+	}
+
+	return nil
+}
+
+// writes a file of a chunked backup, checking it against its hash
+func readFile(f File, w io.Writer) error {
+	sum := sha256.New()
+	if err := readChunks(f, io.MultiWriter(w, sum)); err != nil {
+		return err
+	}
+
+	if hex.EncodeToString(sum.Sum(nil)) != f.SHA256 {
+		return errors.New("'" + f.Path + "' does not match its hash")
+	}
+
+	return nil
+}
+
+// checks an archive hasn't changed since its manifest was written
+func verify(path string, sum string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return err
+	}
+
+	if hex.EncodeToString(hash.Sum(nil)) != sum {
+		return errors.New("archive does not match the checksum in its manifest")
+	}
+
+	return nil
+}
+
+// writes the files of a backup to a new directory
+func unpack(m *Manifest, dest string) error {
+	if m.Format != FormatChunks {
+		return extract(dir+"/"+m.Node+"/"+m.Archive, dest)
+	}
+
+	if err := os.MkdirAll(dest, 0755); err != nil {
+		return err
+	}
+
+	for _, f := range m.Files {
+		target, err := within(dest, f.Path)
+		if err != nil {
+			return err
+		}
+
+		if f.Mode.IsDir() {
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return err
+		}
+
+		out, err := os.OpenFile(target, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, f.Mode.Perm())
+		if err != nil {
+			return err
+		}
+
+		if err := readFile(f, out); err != nil {
+			out.Close()
+			return err
+		}
+
+		if err := out.Close(); err != nil {
+			return err
+		}
+
+		os.Chtimes(target, f.Modified, f.Modified)
+	}
+
+	return nil
+}
+
+// where a path from a backup goes within a directory, refusing anything that would end up outside it
+func within(dest string, path string) (string, error) {
+	name := filepath.Clean(filepath.FromSlash(path))
+	if filepath.IsAbs(name) || name == ".." || strings.HasPrefix(name, ".."+string(filepath.Separator)) {
+		return "", errors.New("backup contains an unsafe path '" + path + "'")
+	}
+
+	return filepath.Join(dest, name), nil
+}
+
+// extracts a gzipped tar into a new directory
+func extract(path string, dest string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(dest, 0755); err != nil {
+		return err
+	}
+
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		target, err := within(dest, hdr.Name)
+		if err != nil {
+			return err
+		}
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return err
+			}
+
+			out, err := os.OpenFile(target, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, hdr.FileInfo().Mode().Perm())
+			if err != nil {
+				return err
+			}
+
+			if _, err := io.Copy(out, tr); err != nil {
+				out.Close()
+				return err
+			}
+
+			if err := out.Close(); err != nil {
+				return err
+			}
+
+			os.Chtimes(target, hdr.ModTime, hdr.ModTime)
+		}
+	}
+}
+
+// moves excluded paths from the old node directory into the restored one, unless the backup has them
+func carry(from string, to string, excludes []string) error {
+	return filepath.WalkDir(from, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, _ := filepath.Rel(from, p)
+		if rel == "." || !excluded(filepath.ToSlash(rel), excludes) {
+			return nil
+		}
+
+		dest := filepath.Join(to, rel)
+		if _, err := os.Lstat(dest); err == nil {
+			return skip(d)
+		}
+
+		if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+			return err
+		}
+
+		if err := os.Rename(p, dest); err != nil {
+			return err
+		}
+
+		return skip(d)
+	})
+}
+
+func skip(d fs.DirEntry) error {
+	if d.IsDir() {
+		return filepath.SkipDir
+	}
+
+	return nil
+}
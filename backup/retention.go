@@ -0,0 +1,187 @@
+package backup
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"lolarobins.ca/overload/log"
+)
+
+// backups kept after each new one: the newest few, and the newest backup in each of the latest hours, days and weeks
+// that have one, counting only those with a backup, so a day without backups doesn't use up one of the days kept
+type Retention struct {
+	Last   int `json:"last"`
+	Hourly int `json:"hourly"`
+	Daily  int `json:"daily"`
+	Weekly int `json:"weekly"`
+}
+
+// retention of a node's backups, every backup is kept when nothing is set
+func RetentionOf(id string) Retention {
+	if c, ok := Settings.Nodes[id]; ok && c.Retention != nil {
+		return *c.Retention
+	}
+
+	return Settings.Retention
+}
+
+// a node's backups, oldest first
+func List(id string) ([]Manifest, error) {
+	manifests := []Manifest{}
+
+	files, err := os.ReadDir(dir + "/" + id)
+	if os.IsNotExist(err) {
+		return manifests, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	for _, f := range files {
+		if f.IsDir() || !strings.HasSuffix(f.Name(), ".json") {
+			continue
+		}
+
+		m, err := read(id, strings.TrimSuffix(f.Name(), ".json"))
+		if err != nil {
+			log.Error("Reading backup manifest '" + dir + "/" + id + "/" + f.Name() + "': " + err.Error())
+			continue
+		}
+
+		manifests = append(manifests, *m)
+	}
+
+	sort.Slice(manifests, func(i, j int) bool { return manifests[i].Time.Before(manifests[j].Time) })
+
+	return manifests, nil
+}
+
+// one of a node's backups by its id
+func Get(id string, backup string) (*Manifest, error) {
+	if strings.ContainsAny(backup, "/\\") || strings.HasPrefix(backup, ".") {
+		return nil, errors.New("invalid backup id")
+	}
+
+	m, err := read(id, backup)
+	if os.IsNotExist(err) {
+		return nil, errors.New("backup '" + backup + "' of " + id + " does not exist")
+	}
+
+	return m, err
+}
+
+func read(id string, backup string) (*Manifest, error) {
+	data, err := os.ReadFile(dir + "/" + id + "/" + backup + ".json")
+	if err != nil {
+		return nil, err
+	}
+
+	m := &Manifest{}
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, errors.New("cannot be parsed")
+	}
+
+	return m, nil
+}
+
+// deletes a backup and its manifest, the chunks of a chunked backup are left until unused chunks are collected
+func Delete(m *Manifest) error {
+	if m.Archive != "" {
+		if err := os.Remove(dir + "/" + m.Node + "/" + m.Archive); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	return os.Remove(dir + "/" + m.Node + "/" + m.Id + ".json")
+}
+
+// deletes the backups of a node that its retention no longer keeps
+func prune(id string) error {
+	r := RetentionOf(id)
+	if r == (Retention{}) {
+		return nil
+	}
+
+	manifests, err := List(id)
+	if err != nil {
+		return err
+	}
+
+	keep := Keep(manifests, r)
+
+	removed := 0
+	chunked := false
+	for i := range manifests {
+		if keep[manifests[i].Id] {
+			continue
+		}
+
+		if err := Delete(&manifests[i]); err != nil {
+			return err
+		}
+
+		removed++
+		chunked = chunked || manifests[i].Format == FormatChunks
+	}
+
+	if removed > 0 {
+		log.Info("Removed " + strconv.Itoa(removed) + " backups of " + id + " past their retention")
+	}
+
+	// left for next time when other backups are busy
+	if chunked {
+		if _, _, err := Collect(); err != nil && err != errCollectBusy {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ids of the backups a retention keeps: the newest few, and the newest in each of the latest hours, days and weeks with a backup
+func Keep(manifests []Manifest, r Retention) map[string]bool {
+	newest := append([]Manifest{}, manifests...)
+	sort.Slice(newest, func(i, j int) bool { return newest[i].Time.After(newest[j].Time) })
+
+	keep := make(map[string]bool)
+
+	for i := 0; i < r.Last && i < len(newest); i++ {
+		keep[newest[i].Id] = true
+	}
+
+	buckets := []struct {
+		count int
+		key   func(t time.Time) string
+	}{
+		{r.Hourly, func(t time.Time) string { return t.Format("2006-01-02 15") }},
+		{r.Daily, func(t time.Time) string { return t.Format("2006-01-02") }},
+		{r.Weekly, func(t time.Time) string {
+			year, week := t.ISOWeek()
+			return strconv.Itoa(year) + "-" + strconv.Itoa(week)
+		}},
+	}
+
+	for _, b := range buckets {
+		seen := make(map[string]bool)
+
+		for _, m := range newest {
+			if len(seen) == b.count {
+				break
+			}
+
+			key := b.key(m.Time.Local())
+			if seen[key] {
+				continue
+			}
+
+			seen[key] = true
+			keep[m.Id] = true
+		}
+	}
+
+	return keep
+}
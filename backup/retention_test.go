@@ -0,0 +1,92 @@
+package backup
+
+import (
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// backups taken at 'day hour:minute' in june 2024, june 3rd is a monday
+func backups(times ...string) []Manifest {
+	manifests := []Manifest{}
+
+	for _, s := range times {
+		t, err := time.ParseInLocation("2 15:04", s, time.Local)
+		if err != nil {
+			panic(err)
+		}
+
+		manifests = append(manifests, Manifest{Id: s, Time: time.Date(2024, time.June, t.Day(), t.Hour(), t.Minute(), 0, 0, time.Local)})
+	}
+
+	return manifests
+}
+
+func kept(keep map[string]bool) string {
+	ids := []string{}
+	for id := range keep {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	return strings.Join(ids, ", ")
+}
+
+func TestKeep(t *testing.T) {
+	hours := backups("10 10:05", "10 10:40", "10 11:10", "10 11:50", "10 12:30")
+	days := backups("3 09:00", "3 18:00", "5 09:00", "5 18:00", "6 09:00", "9 23:00", "10 08:00", "10 20:00")
+
+	tests := []struct {
+		name      string
+		manifests []Manifest
+		retention Retention
+		keep      []string
+	}{
+		{"nothing", hours, Retention{}, []string{}},
+		{"no backups", []Manifest{}, Retention{Last: 3, Hourly: 2, Daily: 2, Weekly: 2}, []string{}},
+
+		{"last", hours, Retention{Last: 2}, []string{"10 11:50", "10 12:30"}},
+		{"last over count", hours, Retention{Last: 10}, []string{"10 10:05", "10 10:40", "10 11:10", "10 11:50", "10 12:30"}},
+
+		// the newest of each hour
+		{"hourly", hours, Retention{Hourly: 2}, []string{"10 11:50", "10 12:30"}},
+		{"hourly over count", hours, Retention{Hourly: 5}, []string{"10 10:40", "10 11:50", "10 12:30"}},
+
+		// days without a backup aren't counted
+		{"daily", days, Retention{Daily: 3}, []string{"10 20:00", "6 09:00", "9 23:00"}},
+		{"daily all", days, Retention{Daily: 10}, []string{"10 20:00", "3 18:00", "5 18:00", "6 09:00", "9 23:00"}},
+
+		// the 3rd to 9th are one week, the 10th starts the next
+		{"weekly", days, Retention{Weekly: 1}, []string{"10 20:00"}},
+		{"weekly two", days, Retention{Weekly: 2}, []string{"10 20:00", "9 23:00"}},
+
+		// each rule keeps its own backups, a backup kept by one still counts towards the others
+		{"last and daily", days, Retention{Last: 2, Daily: 2}, []string{"10 08:00", "10 20:00", "9 23:00"}},
+		{"last and weekly", days, Retention{Last: 1, Weekly: 2}, []string{"10 20:00", "9 23:00"}},
+		{"hourly and daily", days, Retention{Hourly: 2, Daily: 3}, []string{"10 08:00", "10 20:00", "6 09:00", "9 23:00"}},
+		{"all rules", days, Retention{Last: 1, Hourly: 1, Daily: 4, Weekly: 2}, []string{"10 20:00", "5 18:00", "6 09:00", "9 23:00"}},
+
+		// a zero count keeps nothing of its own
+		{"zero daily", days, Retention{Last: 1, Hourly: 0, Daily: 0, Weekly: 2}, []string{"10 20:00", "9 23:00"}},
+		{"only weekly zero others", days, Retention{Last: 0, Hourly: 0, Daily: 0, Weekly: 3}, []string{"10 20:00", "9 23:00"}},
+	}
+
+	for _, test := range tests {
+		want := append([]string{}, test.keep...)
+		sort.Strings(want)
+
+		got := Keep(test.manifests, test.retention)
+		if kept(got) != strings.Join(want, ", ") {
+			t.Errorf("%s: kept [%s], expected [%s]", test.name, kept(got), strings.Join(want, ", "))
+		}
+
+		// the order backups are listed in doesn't change what's kept
+		reversed := append([]Manifest{}, test.manifests...)
+		sort.Slice(reversed, func(i, j int) bool { return reversed[i].Time.After(reversed[j].Time) })
+
+		if again := Keep(reversed, test.retention); kept(again) != kept(got) {
+			t.Errorf("%s: kept [%s] in reverse order, expected [%s]", test.name, kept(again), kept(got))
+		}
+	}
+}
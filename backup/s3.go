@@ -0,0 +1,381 @@
+package backup
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// an s3 compatible bucket, addressed by path so any endpoint such as MinIO works
+type s3Remote struct {
+	Destination
+	endpoint *url.URL
+	client   *http.Client
+}
+
+// uploads larger than this are sent in parts of this size, so a failed one only sends the parts that are missing again
+const partSize = 16 << 20
+
+type s3Error struct {
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+type listResult struct {
+	Contents []struct {
+		Key string `xml:"Key"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+type uploadsResult struct {
+	Uploads []struct {
+		Key      string    `xml:"Key"`
+		UploadId string    `xml:"UploadId"`
+		Started  time.Time `xml:"Initiated"`
+	} `xml:"Upload"`
+}
+
+type partsResult struct {
+	Parts []struct {
+		Number int    `xml:"PartNumber"`
+		ETag   string `xml:"ETag"`
+		Size   int64  `xml:"Size"`
+	} `xml:"Part"`
+	IsTruncated          bool `xml:"IsTruncated"`
+	NextPartNumberMarker int  `xml:"NextPartNumberMarker"`
+}
+
+type completeUpload struct {
+	XMLName xml.Name       `xml:"CompleteMultipartUpload"`
+	Parts   []completePart `xml:"Part"`
+}
+
+type completePart struct {
+	Number int    `xml:"PartNumber"`
+	ETag   string `xml:"ETag"`
+}
+
+func openS3(d Destination) (remote, error) {
+	if d.Endpoint == "" || d.Bucket == "" || d.AccessKey == "" || d.SecretKey == "" {
+		return nil, errors.New("endpoint, bucket, accesskey and secretkey need to be set")
+	}
+
+	endpoint, err := url.Parse(strings.TrimSuffix(d.Endpoint, "/"))
+	if err != nil || endpoint.Host == "" {
+		return nil, errors.New("invalid endpoint")
+	}
+
+	if d.Region == "" {
+		d.Region = "us-east-1"
+	}
+
+	return &s3Remote{Destination: d, endpoint: endpoint, client: &http.Client{Timeout: 10 * time.Minute}}, nil
+}
+
+func (s *s3Remote) key(key string) string {
+	if s.Path == "" {
+		return key
+	}
+
+	return strings.Trim(s.Path, "/") + "/" + key
+}
+
+// sends a signed request, s3 checks the body against the hash in the signature and the md5 header before storing it
+func (s *s3Remote) do(method string, key string, query url.Values, body []byte) (*http.Response, error) {
+	now := time.Now().UTC()
+	date := now.Format("20060102")
+	stamp := now.Format("20060102T150405Z")
+
+	path := "/" + s.Bucket
+	if key != "" {
+		path += "/" + s.key(key)
+	}
+	path = s.endpoint.Path + path
+
+	payload := sha256.Sum256(body)
+	payloadHash := hex.EncodeToString(payload[:])
+
+	headers := map[string]string{
+		"host":                 s.endpoint.Host,
+		"x-amz-date":           stamp,
+		"x-amz-content-sha256": payloadHash,
+	}
+
+	if body != nil {
+		sum := md5.Sum(body)
+		headers["content-md5"] = base64.StdEncoding.EncodeToString(sum[:])
+	}
+
+	names := []string{}
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	canonicalHeaders := ""
+	for _, name := range names {
+		canonicalHeaders += name + ":" + headers[name] + "\n"
+	}
+	signed := strings.Join(names, ";")
+
+	canonical := method + "\n" + escapePath(path) + "\n" + canonicalQuery(query) + "\n" + canonicalHeaders + "\n" + signed + "\n" + payloadHash
+	scope := date + "/" + s.Region + "/s3/aws4_request"
+	hash := sha256.Sum256([]byte(canonical))
+	toSign := "AWS4-HMAC-SHA256\n" + stamp + "\n" + scope + "\n" + hex.EncodeToString(hash[:])
+
+	signingKey := mac([]byte("AWS4"+s.SecretKey), []byte(date))
+	signingKey = mac(signingKey, []byte(s.Region))
+	signingKey = mac(signingKey, []byte("s3"))
+	signingKey = mac(signingKey, []byte("aws4_request"))
+	signature := hex.EncodeToString(mac(signingKey, []byte(toSign)))
+
+	u := s.endpoint.Scheme + "://" + s.endpoint.Host + escapePath(path)
+	if len(query) > 0 {
+		u += "?" + canonicalQuery(query)
+	}
+
+	req, err := http.NewRequest(method, u, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	req.ContentLength = int64(len(body))
+	for _, name := range names {
+		if name != "host" {
+			req.Header.Set(name, headers[name])
+		}
+	}
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+s.AccessKey+"/"+scope+", SignedHeaders="+signed+", Signature="+signature)
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.StatusCode == http.StatusNotFound && method != http.MethodPost {
+		res.Body.Close()
+		return nil, errNotFound
+	}
+
+	if res.StatusCode >= 300 {
+		defer res.Body.Close()
+
+		e := s3Error{}
+		data, _ := io.ReadAll(io.LimitReader(res.Body, 64<<10))
+		if xml.Unmarshal(data, &e) != nil || e.Code == "" {
+			return nil, errors.New("s3 responded " + res.Status)
+		}
+
+		return nil, errors.New("s3 responded " + e.Code + ": " + e.Message)
+	}
+
+	return res, nil
+}
+
+// sends a request and decodes its xml response
+func (s *s3Remote) call(method string, key string, query url.Values, body []byte, v interface{}) error {
+	res, err := s.do(method, key, query, body)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if v == nil {
+		io.Copy(io.Discard, res.Body)
+		return nil
+	}
+
+	return xml.NewDecoder(res.Body).Decode(v)
+}
+
+func (s *s3Remote) put(key string, r io.Reader) error {
+	buf := make([]byte, partSize)
+
+	n, err := io.ReadFull(r, buf)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return s.call(http.MethodPut, key, nil, buf[:n], nil)
+	} else if err != nil {
+		return err
+	}
+
+	id, parts, err := s.resumable(key)
+	if err != nil {
+		return err
+	}
+
+	done := []completePart{}
+
+	for number := 1; n > 0; number++ {
+		part := buf[:n]
+		sum := md5.Sum(part)
+		etag := "\"" + hex.EncodeToString(sum[:]) + "\""
+
+		// parts uploaded before are only kept if they hold the same bytes
+		if parts[number] != etag {
+			res, err := s.do(http.MethodPut, key, url.Values{"partNumber": {strconv.Itoa(number)}, "uploadId": {id}}, part)
+			if err != nil {
+				return err
+			}
+			res.Body.Close()
+
+			if tag := res.Header.Get("ETag"); tag != "" {
+				etag = tag
+			}
+		}
+
+		done = append(done, completePart{Number: number, ETag: etag})
+
+		n, err = io.ReadFull(r, buf)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return err
+		}
+	}
+
+	body, _ := xml.Marshal(completeUpload{Parts: done})
+
+	return s.call(http.MethodPost, key, url.Values{"uploadId": {id}}, body, nil)
+}
+
+// an unfinished upload of a key and the etags of its parts, or a new upload
+func (s *s3Remote) resumable(key string) (string, map[int]string, error) {
+	// some servers answer that there's no such upload when there are none at all
+	uploads := uploadsResult{}
+	if err := s.call(http.MethodGet, "", url.Values{"uploads": {""}, "prefix": {s.key(key)}}, nil, &uploads); err != nil && err != errNotFound {
+		return "", nil, err
+	}
+
+	id := ""
+	var started time.Time
+	for _, u := range uploads.Uploads {
+		if u.Key == s.key(key) && (id == "" || u.Started.After(started)) {
+			id, started = u.UploadId, u.Started
+		}
+	}
+
+	if id == "" {
+		created := struct {
+			UploadId string `xml:"UploadId"`
+		}{}
+
+		if err := s.call(http.MethodPost, key, url.Values{"uploads": {""}}, nil, &created); err != nil {
+			return "", nil, err
+		}
+
+		return created.UploadId, map[int]string{}, nil
+	}
+
+	parts := make(map[int]string)
+	marker := 0
+
+	for {
+		query := url.Values{"uploadId": {id}}
+		if marker > 0 {
+			query.Set("part-number-marker", strconv.Itoa(marker))
+		}
+
+		result := partsResult{}
+		if err := s.call(http.MethodGet, key, query, nil, &result); err != nil {
+			return "", nil, err
+		}
+
+		for _, p := range result.Parts {
+			parts[p.Number] = p.ETag
+		}
+
+		if !result.IsTruncated {
+			return id, parts, nil
+		}
+		marker = result.NextPartNumberMarker
+	}
+}
+
+func (s *s3Remote) get(key string) (io.ReadCloser, error) {
+	res, err := s.do(http.MethodGet, key, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return res.Body, nil
+}
+
+func (s *s3Remote) list() ([]string, error) {
+	keys := []string{}
+	prefix := ""
+	if s.Path != "" {
+		prefix = strings.Trim(s.Path, "/") + "/"
+	}
+
+	token := ""
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+
+		result := listResult{}
+		if err := s.call(http.MethodGet, "", query, nil, &result); err != nil {
+			return nil, err
+		}
+
+		for _, c := range result.Contents {
+			keys = append(keys, strings.TrimPrefix(c.Key, prefix))
+		}
+
+		if !result.IsTruncated {
+			return keys, nil
+		}
+		token = result.NextContinuationToken
+	}
+}
+
+func (s *s3Remote) remove(key string) error {
+	err := s.call(http.MethodDelete, key, nil, nil, nil)
+	if err == errNotFound {
+		return nil
+	}
+
+	return err
+}
+
+func (s *s3Remote) close() error {
+	return nil
+}
+
+// escapes each segment of a path as aws expects
+func escapePath(path string) string {
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		segments[i] = escape(segment)
+	}
+
+	return strings.Join(segments, "/")
+}
+
+func canonicalQuery(query url.Values) string {
+	pairs := []string{}
+	for name, values := range query {
+		for _, value := range values {
+			pairs = append(pairs, escape(name)+"="+escape(value))
+		}
+	}
+	sort.Strings(pairs)
+
+	return strings.Join(pairs, "&")
+}
+
+func escape(s string) string {
+	return strings.ReplaceAll(url.QueryEscape(s), "+", "%20")
+}
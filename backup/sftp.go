@@ -0,0 +1,206 @@
+package backup
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+// a directory on an sftp server
+type sftpRemote struct {
+	root   string
+	conn   *ssh.Client
+	client *sftp.Client
+}
+
+func openSFTP(d Destination) (remote, error) {
+	if d.Host == "" || d.User == "" {
+		return nil, errors.New("host and user need to be set")
+	}
+
+	auth := []ssh.AuthMethod{}
+
+	if d.KeyFile != "" {
+		data, err := os.ReadFile(d.KeyFile)
+		if err != nil {
+			return nil, errors.New("reading key file: " + err.Error())
+		}
+
+		signer, err := ssh.ParsePrivateKey(data)
+		if err != nil {
+			return nil, errors.New("parsing key file: " + err.Error())
+		}
+
+		auth = append(auth, ssh.PublicKeys(signer))
+	}
+
+	if d.Password != "" {
+		auth = append(auth, ssh.Password(d.Password))
+	}
+
+	// the server is only trusted with the key it's been given, so backups never go somewhere they shouldn't
+	hostKey := func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		presented := strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key)))
+
+		if d.HostKey == "" {
+			return errors.New("hostkey is not set, the server presented '" + presented + "'")
+		}
+
+		expected, _, _, _, err := ssh.ParseAuthorizedKey([]byte(d.HostKey))
+		if err != nil {
+			return errors.New("invalid hostkey")
+		}
+
+		if string(expected.Marshal()) != string(key.Marshal()) {
+			return errors.New("server presented '" + presented + "', which is not the hostkey set")
+		}
+
+		return nil
+	}
+
+	conn, err := ssh.Dial("tcp", d.Host, &ssh.ClientConfig{User: d.User, Auth: auth, HostKeyCallback: hostKey, Timeout: 30 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := sftp.NewClient(conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	root := path.Clean(d.Path)
+
+	if err := client.MkdirAll(root); err != nil {
+		client.Close()
+		conn.Close()
+		return nil, err
+	}
+
+	return &sftpRemote{root: root, conn: conn, client: client}, nil
+}
+
+func (r *sftpRemote) put(key string, src io.Reader) error {
+	target := path.Join(r.root, key)
+	partial := target + ".partial"
+
+	if err := r.client.MkdirAll(path.Dir(target)); err != nil {
+		return err
+	}
+
+	f, err := r.client.OpenFile(partial, os.O_CREATE|os.O_WRONLY|os.O_APPEND)
+	if err != nil {
+		return err
+	}
+
+	sum, err := resume(f, src)
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	if err := r.check(partial, sum); err != nil {
+		r.client.Remove(partial)
+		return err
+	}
+
+	if err := r.client.PosixRename(partial, target); err != nil {
+		// not every server has posix renames, which replace what's there
+		r.client.Remove(target)
+		return r.client.Rename(partial, target)
+	}
+
+	return nil
+}
+
+// has the server hash what it wrote, or reads it back when it can't run commands
+func (r *sftpRemote) check(file string, sum string) error {
+	if session, err := r.conn.NewSession(); err == nil {
+		out, err := session.Output("sha256sum -- '" + strings.ReplaceAll(file, "'", "'\\''") + "'")
+		session.Close()
+
+		if fields := strings.Fields(string(out)); err == nil && len(fields) > 0 {
+			if fields[0] != sum {
+				return errors.New("server's hash of what was written does not match what was uploaded")
+			}
+			return nil
+		}
+	}
+
+	f, err := r.client.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return err
+	}
+
+	if hex.EncodeToString(hash.Sum(nil)) != sum {
+		return errors.New("written file does not match what was uploaded")
+	}
+
+	return nil
+}
+
+func (r *sftpRemote) get(key string) (io.ReadCloser, error) {
+	f, err := r.client.Open(path.Join(r.root, key))
+	if os.IsNotExist(err) {
+		return nil, errNotFound
+	}
+
+	return f, err
+}
+
+func (r *sftpRemote) list() ([]string, error) {
+	keys := []string{}
+
+	walker := r.client.Walk(r.root)
+	for walker.Step() {
+		if err := walker.Err(); err != nil {
+			return nil, err
+		}
+
+		if walker.Stat().IsDir() || strings.HasSuffix(walker.Path(), ".partial") {
+			continue
+		}
+
+		key := walker.Path()
+		if r.root != "." {
+			key = strings.TrimPrefix(key, strings.TrimSuffix(r.root, "/")+"/")
+		}
+
+		keys = append(keys, key)
+	}
+
+	return keys, nil
+}
+
+func (r *sftpRemote) remove(key string) error {
+	err := r.client.Remove(path.Join(r.root, key))
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	return err
+}
+
+func (r *sftpRemote) close() error {
+	r.client.Close()
+	return r.conn.Close()
+}
@@ -0,0 +1,158 @@
+package event
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	NodeStart         = "node.start"
+	NodeStop          = "node.stop"
+	NodeCrash         = "node.crash"
+	NodeRestart       = "node.restart"
+	NodeConfig        = "node.config"
+	NodeHang          = "node.hang"
+	PlayerJoin        = "player.join"
+	PlayerLeave       = "player.leave"
+	PlayerChat        = "player.chat"
+	PlayerDeath       = "player.death"
+	PlayerAdvancement = "player.advancement"
+	ServerReady       = "server.ready"
+	ServerLag         = "server.lag"
+	ServerTPS         = "server.tps"
+	ServerException   = "server.exception"
+	ServerSave        = "server.save"
+	FetchStart        = "fetch.start"
+	FetchProgress     = "fetch.progress"
+	FetchDone         = "fetch.done"
+	FetchFail         = "fetch.fail"
+	BackupStart       = "backup.start"
+	BackupDone        = "backup.done"
+	BackupFail        = "backup.fail"
+	BackupRestore     = "backup.restore"
+)
+
+type Event struct {
+	Id   uint64                 `json:"id"`
+	Type string                 `json:"type"`
+	Node string                 `json:"node,omitempty"`
+	Time time.Time              `json:"time"`
+	Data map[string]interface{} `json:"data,omitempty"`
+}
+
+type Subscription struct {
+	C      chan Event
+	closed bool
+}
+
+const historySize = 1000
+const subscriptionBuffer = 256
+
+var history = make([]Event, 0, historySize)
+var subscriptions = make(map[*Subscription]bool)
+var lock = new(sync.Mutex)
+
+// ids carry on from the last run so a reconnecting client doesn't replay the wrong events
+var lastId = uint64(time.Now().UnixMilli()) * 1000
+
+func Publish(eventType string, node string, data map[string]interface{}) {
+	lock.Lock()
+	defer lock.Unlock()
+
+	lastId++
+	e := Event{Id: lastId, Type: eventType, Node: node, Time: time.Now().UTC(), Data: data}
+
+	if len(history) == historySize {
+		copy(history, history[1:])
+		history = history[:historySize-1]
+	}
+	history = append(history, e)
+
+	for s := range subscriptions {
+		select {
+		case s.C <- e:
+		default:
+			// too slow to keep up, the client reconnects and replays from history
+			s.close()
+		}
+	}
+}
+
+// events after the given id still held in history, or all of history if it's no longer held
+func Since(id uint64) []Event {
+	lock.Lock()
+	defer lock.Unlock()
+
+	for i, e := range history {
+		if e.Id > id {
+			return append([]Event{}, history[i:]...)
+		}
+	}
+
+	return []Event{}
+}
+
+func Subscribe() *Subscription {
+	lock.Lock()
+	defer lock.Unlock()
+
+	s := &Subscription{C: make(chan Event, subscriptionBuffer)}
+	subscriptions[s] = true
+
+	return s
+}
+
+func (s *Subscription) Unsubscribe() {
+	lock.Lock()
+	defer lock.Unlock()
+
+	s.close()
+}
+
+func (s *Subscription) close() {
+	if s.closed {
+		return
+	}
+
+	s.closed = true
+	delete(subscriptions, s)
+	close(s.C)
+}
+
+// matches an event type against a filter, filters ending in '*' match by prefix
+func Matches(filter string, eventType string) bool {
+	if strings.HasSuffix(filter, "*") {
+		return strings.HasPrefix(eventType, strings.TrimSuffix(filter, "*"))
+	}
+
+	return filter == eventType
+}
+
+// calls handler with every event from now on, in its own goroutine; events missed while handler was too slow are replayed from history
+func Listen(handler func(Event)) {
+	s := Subscribe()
+
+	go func() {
+		var last uint64
+
+		for {
+			for e := range s.C {
+				if e.Id <= last {
+					continue
+				}
+
+				last = e.Id
+				handler(e)
+			}
+
+			s = Subscribe()
+			for _, e := range Since(last) {
+				if e.Id > last {
+					last = e.Id
+					handler(e)
+				}
+			}
+		}
+	}()
+}
@@ -8,11 +8,23 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
+	"lolarobins.ca/overload/audit"
+	"lolarobins.ca/overload/event"
 	"lolarobins.ca/overload/input"
 	"lolarobins.ca/overload/log"
 )
 
+// fetches since startup
+var jobs struct {
+	lock   sync.Mutex
+	active uint64
+	done   uint64
+	failed uint64
+}
+
 func Init() {
 	input.Command{
 		Function: func(s []string) {
@@ -21,24 +33,18 @@ func Init() {
 				return
 			}
 
-			switch strings.ToLower(s[1]) {
-			case "paper":
-				log.Info("Starting a goroutine to fetch server jar for PaperMC version '" + s[2] + "'")
-				go func() {
-					if err := FetchPaper(s[2]); err != nil {
-						log.Error("Fetching paper: " + err.Error())
-					}
-				}()
-			case "waterfall":
-				log.Info("Starting a goroutine to fetch server jar for Waterfall version '" + s[2] + "'")
-				go func() {
-					if err := FetchWaterfall(s[2]); err != nil {
-						log.Error("Fetching waterfall: " + err.Error())
-					}
-				}()
-			default:
+			if !Supported(s[1]) {
 				log.Error("Fetching server jar: implementation not found")
+				return
 			}
+
+			audit.Record(audit.Entry{Actor: audit.Console, Source: audit.CLI, Action: "fetch", Target: strings.ToLower(s[1]), New: s[2]})
+			log.Info("Starting a goroutine to fetch server jar for " + s[1] + " version '" + s[2] + "'")
+			go func() {
+				if err := Fetch(s[1], s[2]); err != nil {
+					log.Error("Fetching " + strings.ToLower(s[1]) + ": " + err.Error())
+				}
+			}()
 		},
 		Command:     "fetch",
 		Args:        " <implementation> <version/latest>",
@@ -46,6 +52,77 @@ func Init() {
 	}.Register()
 }
 
+func Supported(implementation string) bool {
+	switch strings.ToLower(implementation) {
+	case "paper", "waterfall":
+		return true
+	}
+
+	return false
+}
+
+func Fetch(implementation string, version string) error {
+	implementation = strings.ToLower(implementation)
+	event.Publish(event.FetchStart, "", map[string]interface{}{"implementation": implementation, "version": version})
+
+	jobs.lock.Lock()
+	jobs.active++
+	jobs.lock.Unlock()
+
+	err := errors.New("implementation not found")
+	switch implementation {
+	case "paper":
+		err = FetchPaper(version)
+	case "waterfall":
+		err = FetchWaterfall(version)
+	}
+
+	jobs.lock.Lock()
+	jobs.active--
+	if err != nil {
+		jobs.failed++
+	} else {
+		jobs.done++
+	}
+	jobs.lock.Unlock()
+
+	if err != nil {
+		event.Publish(event.FetchFail, "", map[string]interface{}{"implementation": implementation, "version": version, "error": err.Error()})
+	}
+
+	return err
+}
+
+// fetches currently running, and those finished or failed since startup
+func Jobs() (active uint64, done uint64, failed uint64) {
+	jobs.lock.Lock()
+	defer jobs.lock.Unlock()
+
+	return jobs.active, jobs.done, jobs.failed
+}
+
+// publishes download progress at most once a second
+type progressReader struct {
+	reader         io.Reader
+	implementation string
+	version        string
+	total          int64
+	read           int64
+	published      time.Time
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.reader.Read(b)
+	p.read += int64(n)
+
+	if time.Since(p.published) >= time.Second {
+		p.published = time.Now()
+		event.Publish(event.FetchProgress, "", map[string]interface{}{"implementation": p.implementation, "version": p.version, "bytes": p.read, "total": p.total})
+	}
+
+	return n, err
+}
+
 type paperErr struct {
 	Err string `json:"error"`
 }
@@ -121,11 +198,12 @@ func FetchPaper(version string) error {
 		return errors.New("could not fetch download file")
 	}
 
-	_, err = io.Copy(out, resp.Body)
+	_, err = io.Copy(out, &progressReader{reader: resp.Body, implementation: "paper", version: version, total: resp.ContentLength})
 	if err != nil {
 		return err
 	}
 
+	event.Publish(event.FetchDone, "", map[string]interface{}{"implementation": "paper", "version": version, "build": build})
 	log.Info("Done fetching PaperMC version '" + version + "' (Build: " + strconv.Itoa(build) + ")")
 
 	return nil
@@ -194,11 +272,12 @@ func FetchWaterfall(version string) error {
 		return errors.New("could not fetch download file")
 	}
 
-	_, err = io.Copy(out, resp.Body)
+	_, err = io.Copy(out, &progressReader{reader: resp.Body, implementation: "waterfall", version: version, total: resp.ContentLength})
 	if err != nil {
 		return err
 	}
 
+	event.Publish(event.FetchDone, "", map[string]interface{}{"implementation": "waterfall", "version": version, "build": build})
 	log.Info("Done fetching Waterfall version '" + version + "' (Build: " + strconv.Itoa(build) + ")")
 
 	return nil
@@ -0,0 +1,292 @@
+package files
+
+import (
+	"archive/zip"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+type Entry struct {
+	Name     string    `json:"name"`
+	Dir      bool      `json:"dir"`
+	Symlink  bool      `json:"symlink"`
+	Size     int64     `json:"size"`
+	Modified time.Time `json:"modified"`
+}
+
+var ErrOutside = errors.New("path is outside of the node directory")
+var ErrProtected = errors.New("path is protected")
+
+func realPath(root string) (string, error) {
+	real, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Abs(real)
+}
+
+// resolves a path relative to root, following symlinks, and refuses anything that ends up outside of root
+func Resolve(root string, rel string) (string, error) {
+	realRoot, err := realPath(root)
+	if err != nil {
+		return "", err
+	}
+
+	// cleaning as an absolute path drops any leading '..'
+	path := filepath.Join(realRoot, filepath.Clean("/"+filepath.ToSlash(rel)))
+
+	// resolve the deepest part that exists, what's left can't contain symlinks
+	existing := path
+	rest := ""
+	for {
+		if _, err := os.Lstat(existing); err == nil {
+			break
+		}
+
+		parent := filepath.Dir(existing)
+		if parent == existing {
+			return "", ErrOutside
+		}
+
+		rest = filepath.Join(filepath.Base(existing), rest)
+		existing = parent
+	}
+
+	real, err := filepath.EvalSymlinks(existing)
+	if err != nil {
+		return "", err
+	}
+
+	if !within(realRoot, real) {
+		return "", ErrOutside
+	}
+
+	return filepath.Join(real, rest), nil
+}
+
+func within(root string, path string) bool {
+	rel, err := filepath.Rel(root, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// whether a resolved path is the root itself, which can't be removed or renamed
+func IsRoot(root string, path string) bool {
+	realRoot, err := realPath(root)
+	return err == nil && filepath.Clean(path) == realRoot
+}
+
+func List(path string) ([]Entry, error) {
+	dir, err := os.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := []Entry{}
+	for _, d := range dir {
+		info, err := d.Info()
+		if err != nil {
+			continue
+		}
+
+		e := Entry{Name: d.Name(), Dir: d.IsDir(), Size: info.Size(), Modified: info.ModTime().UTC()}
+
+		if d.Type()&fs.ModeSymlink != 0 {
+			e.Symlink = true
+			if target, err := os.Stat(filepath.Join(path, d.Name())); err == nil {
+				e.Dir = target.IsDir()
+			}
+		}
+
+		entries = append(entries, e)
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Dir != entries[j].Dir {
+			return entries[i].Dir
+		}
+
+		return strings.ToLower(entries[i].Name) < strings.ToLower(entries[j].Name)
+	})
+
+	return entries, nil
+}
+
+// writes a file through a temporary file so a failed upload doesn't leave half a file
+func Write(path string, r io.Reader) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".upload-*")
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	mode := os.FileMode(0644)
+	if info, err := os.Stat(path); err == nil {
+		if info.IsDir() {
+			os.Remove(tmp.Name())
+			return errors.New("path is a directory")
+		}
+
+		mode = info.Mode().Perm()
+	}
+
+	os.Chmod(tmp.Name(), mode)
+
+	return os.Rename(tmp.Name(), path)
+}
+
+// zips the given paths into dest, paths within the archive are relative to base; symlinks are skipped
+func Zip(base string, paths []string, dest string) error {
+	out, err := os.Create(dest)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	w := zip.NewWriter(out)
+
+	for _, p := range paths {
+		err := filepath.WalkDir(p, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+
+			if d.Type()&fs.ModeSymlink != 0 || path == dest {
+				return nil
+			}
+
+			rel, err := filepath.Rel(base, path)
+			if err != nil {
+				return err
+			}
+			rel = filepath.ToSlash(rel)
+
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+
+			header, err := zip.FileInfoHeader(info)
+			if err != nil {
+				return err
+			}
+			header.Name = rel
+
+			if d.IsDir() {
+				header.Name += "/"
+				_, err := w.CreateHeader(header)
+				return err
+			}
+
+			header.Method = zip.Deflate
+
+			writer, err := w.CreateHeader(header)
+			if err != nil {
+				return err
+			}
+
+			f, err := os.Open(path)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+
+			_, err = io.Copy(writer, f)
+			return err
+		})
+
+		if err != nil {
+			w.Close()
+			os.Remove(dest)
+			return err
+		}
+	}
+
+	return w.Close()
+}
+
+// extracts an archive into dest, every entry is resolved against root so nothing can escape it,
+// and nothing is extracted when an entry would replace one of the protected paths
+func Unzip(root string, src string, dest string, protected ...string) error {
+	realRoot, err := realPath(root)
+	if err != nil {
+		return err
+	}
+
+	r, err := zip.OpenReader(src)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	paths := make([]string, len(r.File))
+	for i, f := range r.File {
+		rel, err := filepath.Rel(realRoot, filepath.Join(dest, filepath.Clean("/"+f.Name)))
+		if err != nil {
+			return err
+		}
+
+		path, err := Resolve(root, rel)
+		if err != nil {
+			return errors.New("'" + f.Name + "': " + err.Error())
+		}
+
+		for _, p := range protected {
+			if path == p {
+				return ErrProtected
+			}
+		}
+
+		paths[i] = path
+	}
+
+	for i, f := range r.File {
+		path := paths[i]
+
+		mode := f.Mode()
+		if mode&fs.ModeSymlink != 0 {
+			continue
+		}
+
+		if f.FileInfo().IsDir() {
+			if err := os.MkdirAll(path, 0755); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return err
+		}
+
+		in, err := f.Open()
+		if err != nil {
+			return err
+		}
+
+		err = Write(path, in)
+		in.Close()
+
+		if err != nil {
+			return errors.New("'" + f.Name + "': " + err.Error())
+		}
+	}
+
+	return nil
+}
@@ -2,11 +2,16 @@ module lolarobins.ca/overload
 
 go 1.19
 
-require gitlab.com/NebulousLabs/go-upnp v0.0.0-20211002182029-11da932010b6
+require (
+	github.com/pkg/sftp v1.13.0
+	gitlab.com/NebulousLabs/go-upnp v0.0.0-20211002182029-11da932010b6
+	golang.org/x/crypto v0.0.0-20210322153248-0c34fe9e7dc2
+)
 
 require (
+	github.com/kr/fs v0.1.0 // indirect
+	github.com/pkg/errors v0.9.1 // indirect
 	gitlab.com/NebulousLabs/fastrand v0.0.0-20181126182046-603482d69e40 // indirect
-	golang.org/x/crypto v0.0.0-20210322153248-0c34fe9e7dc2 // indirect
 	golang.org/x/net v0.0.0-20210410081132-afb366fc7cd1 // indirect
 	golang.org/x/sys v0.0.0-20210330210617-4fbd30eecc44 // indirect
 	golang.org/x/text v0.3.6 // indirect
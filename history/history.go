@@ -0,0 +1,369 @@
+package history
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"os"
+	"sync"
+	"time"
+
+	"lolarobins.ca/overload/log"
+	"lolarobins.ca/overload/node"
+	"lolarobins.ca/overload/settings"
+)
+
+// the average of the samples taken over a point's interval, starting at its time
+type Point struct {
+	Time    time.Time `json:"time"`
+	CPU     float64   `json:"cpu"` // percent of one core
+	RSS     float64   `json:"rss"` // bytes
+	Players float64   `json:"players"`
+	TPS     *float64  `json:"tps,omitempty"` // only while the tps command is being run
+	Samples int       `json:"samples"`       // raw samples making up the point
+}
+
+// samples at one resolution, appended to a file and rewritten when old points are dropped
+type tier struct {
+	name       string
+	resolution time.Duration
+	retention  func() time.Duration
+	points     []Point
+	pending    *bucket // the point being built from the finer tier, written once its interval is over
+}
+
+// the history of one node, raw samples are downsampled into minutes and minutes into hours
+type series struct {
+	lock  sync.Mutex
+	node  string
+	tiers []*tier
+}
+
+// sums of the points within one interval
+type bucket struct {
+	start   time.Time
+	cpu     float64
+	rss     float64
+	players float64
+	tps     float64
+	tpsN    int
+	samples int
+}
+
+const dir = "data/history"
+
+const sampleInterval = 10 * time.Second
+const pruneInterval = time.Hour
+
+var lock = new(sync.Mutex)
+var nodes = make(map[string]*series)
+
+func Init() error {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return errors.New("fatal: '" + dir + "' could not be created")
+	}
+
+	go record()
+
+	return nil
+}
+
+// samples every running node, pruning each node's history once an hour
+func record() {
+	ticker := time.NewTicker(sampleInterval)
+	defer ticker.Stop()
+
+	pruned := time.Now()
+
+	for now := range ticker.C {
+		for _, n := range node.All() {
+			stats, ok := n.Stats()
+			if !ok {
+				continue
+			}
+
+			p := Point{Time: now.UTC().Truncate(time.Second), CPU: stats.CPU, RSS: float64(stats.RSS), Samples: 1}
+
+			if status, ok := n.Ping(); ok {
+				p.Players = float64(status.Online)
+			} else {
+				p.Players = float64(len(n.Players()))
+			}
+
+			if tps, ok := n.TPS(); ok {
+				p.TPS = &tps
+			}
+
+			get(n.Id).add(p)
+		}
+
+		if time.Since(pruned) >= pruneInterval {
+			pruned = time.Now()
+
+			lock.Lock()
+			all := []*series{}
+			for _, s := range nodes {
+				all = append(all, s)
+			}
+			lock.Unlock()
+
+			for _, s := range all {
+				s.prune()
+			}
+		}
+	}
+}
+
+// the node's history, loaded from disk the first time it's used
+func get(id string) *series {
+	lock.Lock()
+	defer lock.Unlock()
+
+	if s, ok := nodes[id]; ok {
+		return s
+	}
+
+	s := &series{node: id, tiers: []*tier{
+		{name: "raw", resolution: sampleInterval, retention: func() time.Duration { return time.Duration(settings.Settings.HistoryRawHours) * time.Hour }},
+		{name: "minute", resolution: time.Minute, retention: func() time.Duration { return time.Duration(settings.Settings.HistoryMinuteDays) * 24 * time.Hour }},
+		{name: "hour", resolution: time.Hour, retention: func() time.Duration { return time.Duration(settings.Settings.HistoryHourDays) * 24 * time.Hour }},
+	}}
+
+	for _, t := range s.tiers {
+		if err := s.load(t); err != nil {
+			log.Error("Reading " + t.name + " history of " + id + ": " + err.Error())
+		}
+	}
+
+	nodes[id] = s
+	return s
+}
+
+func (s *series) path(t *tier) string {
+	return dir + "/" + s.node + "/" + t.name + ".jsonl"
+}
+
+func (s *series) load(t *tier) error {
+	f, err := os.Open(s.path(t))
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	cutoff := time.Now().Add(-t.retention())
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		p := Point{}
+		if err := json.Unmarshal(scanner.Bytes(), &p); err != nil || p.Time.Before(cutoff) {
+			continue
+		}
+
+		t.points = append(t.points, p)
+	}
+
+	return scanner.Err()
+}
+
+// adds a raw sample, finishing the minute and hour it follows once they're over
+func (s *series) add(p Point) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.append(s.tiers[0], p)
+
+	// each tier is built from the points of the one before it
+	for _, t := range s.tiers[1:] {
+		start := p.Time.Truncate(t.resolution)
+
+		if t.pending != nil && t.pending.start.Equal(start) {
+			t.pending.add(p)
+			return
+		}
+
+		finished := t.pending
+		t.pending = &bucket{start: start}
+		t.pending.add(p)
+
+		if finished == nil {
+			return
+		}
+
+		p = finished.point()
+		s.append(t, p)
+	}
+}
+
+func (s *series) append(t *tier, p Point) {
+	t.points = append(t.points, p)
+
+	data, err := json.Marshal(p)
+	if err != nil {
+		return
+	}
+
+	if err := os.MkdirAll(dir+"/"+s.node, 0700); err != nil {
+		log.Error("Writing history of " + s.node + ": " + err.Error())
+		return
+	}
+
+	f, err := os.OpenFile(s.path(t), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		log.Error("Writing history of " + s.node + ": " + err.Error())
+		return
+	}
+	defer f.Close()
+
+	f.Write(append(data, '\n'))
+}
+
+// drops points past their retention, rewriting the files they were in
+func (s *series) prune() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for _, t := range s.tiers {
+		cutoff := time.Now().Add(-t.retention())
+
+		keep := 0
+		for keep < len(t.points) && t.points[keep].Time.Before(cutoff) {
+			keep++
+		}
+
+		if keep == 0 {
+			continue
+		}
+
+		t.points = append([]Point{}, t.points[keep:]...)
+
+		if err := s.rewrite(t); err != nil {
+			log.Error("Pruning " + t.name + " history of " + s.node + ": " + err.Error())
+		}
+	}
+}
+
+func (s *series) rewrite(t *tier) error {
+	tmp := s.path(t) + ".tmp"
+
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(f)
+	for _, p := range t.points {
+		data, _ := json.Marshal(p)
+		w.Write(append(data, '\n'))
+	}
+
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, s.path(t))
+}
+
+func (b *bucket) add(p Point) {
+	n := float64(p.Samples)
+
+	b.cpu += p.CPU * n
+	b.rss += p.RSS * n
+	b.players += p.Players * n
+	b.samples += p.Samples
+
+	if p.TPS != nil {
+		b.tps += *p.TPS * n
+		b.tpsN += p.Samples
+	}
+}
+
+func (b *bucket) point() Point {
+	n := float64(b.samples)
+	p := Point{Time: b.start, CPU: b.cpu / n, RSS: b.rss / n, Players: b.players / n, Samples: b.samples}
+
+	if b.tpsN > 0 {
+		tps := b.tps / float64(b.tpsN)
+		p.TPS = &tps
+	}
+
+	return p
+}
+
+// a node's history between two times in steps of at least the given size, from the coarsest resolution that's fine enough and still held
+func Query(id string, from time.Time, to time.Time, step time.Duration) ([]Point, time.Duration) {
+	s := get(id)
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if step < s.tiers[0].resolution {
+		step = s.tiers[0].resolution
+	}
+
+	held := func(t *tier) bool {
+		return !from.Before(time.Now().Add(-t.retention()))
+	}
+
+	var use *tier
+	for _, t := range s.tiers {
+		if held(t) && t.resolution <= step {
+			use = t
+		}
+	}
+
+	// nothing fine enough goes back that far, so the finest that does is used, or whichever goes back furthest
+	if use == nil {
+		for _, t := range s.tiers {
+			if held(t) {
+				use = t
+				break
+			}
+		}
+	}
+
+	if use == nil {
+		for _, t := range s.tiers {
+			if use == nil || t.retention() > use.retention() {
+				use = t
+			}
+		}
+	}
+
+	if step < use.resolution {
+		step = use.resolution
+	}
+
+	points := []Point{}
+	var b *bucket
+
+	for _, p := range use.points {
+		if p.Time.Before(from) || p.Time.After(to) {
+			continue
+		}
+
+		start := p.Time.Truncate(step)
+		if b != nil && !b.start.Equal(start) {
+			points = append(points, b.point())
+			b = nil
+		}
+
+		if b == nil {
+			b = &bucket{start: start}
+		}
+
+		b.add(p)
+	}
+
+	if b != nil {
+		points = append(points, b.point())
+	}
+
+	return points, step
+}
@@ -5,11 +5,18 @@ import (
 	"os"
 	"time"
 
+	"lolarobins.ca/overload/audit"
+	"lolarobins.ca/overload/backup"
 	"lolarobins.ca/overload/fetch"
+	"lolarobins.ca/overload/history"
 	"lolarobins.ca/overload/input"
 	"lolarobins.ca/overload/log"
 	"lolarobins.ca/overload/node"
+	"lolarobins.ca/overload/players"
+	"lolarobins.ca/overload/schedule"
 	"lolarobins.ca/overload/settings"
+	"lolarobins.ca/overload/user"
+	"lolarobins.ca/overload/webhook"
 	"lolarobins.ca/overload/webserver"
 )
 
@@ -47,20 +54,45 @@ func main() {
 	// uwu
 	rand.Seed(time.Now().UTC().UnixNano())
 
-	if !mkdirReq("config", "jar", "nodes") {
+	if !mkdirReq("config", "jar", "nodes", "data") {
 		return
 	}
 
 	input.Init() // input
 
+	audit.Init() // audit log
+
+	players.Init() // player sessions
+
 	if err := settings.Init(); err != nil { // settings
 		log.Error("Intializing settings: " + err.Error())
 	}
 
+	// subscribed before nodes load, so the start events of autostarted nodes are sent
+	if err := webhook.Init(); err != nil { // webhooks
+		log.Error("Intializing webhooks: " + err.Error())
+	}
+
 	if err := node.Init(); err != nil { // nodes
 		log.Error("Intializing nodes: " + err.Error())
 	}
 
+	if err := backup.Init(); err != nil { // backups
+		log.Error("Intializing backups: " + err.Error())
+	}
+
+	if err := schedule.Init(); err != nil { // scheduled tasks
+		log.Error("Intializing scheduler: " + err.Error())
+	}
+
+	if err := history.Init(); err != nil { // node metrics history
+		log.Error("Intializing metrics history: " + err.Error())
+	}
+
+	if err := user.Init(); err != nil { // users
+		log.Error("Intializing users: " + err.Error())
+	}
+
 	if err := webserver.Init(); err != nil { // webserver
 		log.Error("Intializing web server: " + err.Error())
 	}
@@ -0,0 +1,155 @@
+package metrics
+
+import (
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// writes samples in the prometheus text exposition format
+type Writer struct {
+	w io.Writer
+}
+
+// histogram of observations, one set of buckets per label combination
+type Histogram struct {
+	Name    string
+	Help    string
+	Labels  []string
+	Buckets []float64
+
+	lock   sync.Mutex
+	series map[string]*series
+}
+
+type series struct {
+	labels []string
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: w}
+}
+
+// writes the help and type lines that come before the samples of a metric
+func (w *Writer) Header(name string, kind string, help string) {
+	io.WriteString(w.w, "# HELP "+name+" "+escape(help, false)+"\n# TYPE "+name+" "+kind+"\n")
+}
+
+// writes a sample, labels are given as name and value pairs
+func (w *Writer) Sample(name string, value float64, labels ...string) {
+	line := name
+
+	if len(labels) > 0 {
+		line += "{"
+		for i := 0; i+1 < len(labels); i += 2 {
+			if i > 0 {
+				line += ","
+			}
+			line += labels[i] + "=\"" + escape(labels[i+1], true) + "\""
+		}
+		line += "}"
+	}
+
+	io.WriteString(w.w, line+" "+formatFloat(value)+"\n")
+}
+
+func (w *Writer) Gauge(name string, help string, value float64, labels ...string) {
+	w.Header(name, "gauge", help)
+	w.Sample(name, value, labels...)
+}
+
+func (w *Writer) Counter(name string, help string, value float64, labels ...string) {
+	w.Header(name, "counter", help)
+	w.Sample(name, value, labels...)
+}
+
+// records an observation, label values are given in the order of Labels
+func (h *Histogram) Observe(value float64, labels ...string) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	if h.series == nil {
+		h.series = make(map[string]*series)
+	}
+
+	key := strings.Join(labels, "\xff")
+	s, ok := h.series[key]
+	if !ok {
+		s = &series{labels: append([]string{}, labels...), counts: make([]uint64, len(h.Buckets))}
+		h.series[key] = s
+	}
+
+	for i, bound := range h.Buckets {
+		if value <= bound {
+			s.counts[i]++
+		}
+	}
+
+	s.count++
+	s.sum += value
+}
+
+func (h *Histogram) Write(w *Writer) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	w.Header(h.Name, "histogram", h.Help)
+
+	keys := []string{}
+	for key := range h.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		s := h.series[key]
+
+		labels := []string{}
+		for i, name := range h.Labels {
+			if i < len(s.labels) {
+				labels = append(labels, name, s.labels[i])
+			}
+		}
+
+		for i, bound := range h.Buckets {
+			w.Sample(h.Name+"_bucket", float64(s.counts[i]), append(labels, "le", formatFloat(bound))...)
+		}
+
+		w.Sample(h.Name+"_bucket", float64(s.count), append(labels, "le", "+Inf")...)
+		w.Sample(h.Name+"_sum", s.sum, labels...)
+		w.Sample(h.Name+"_count", float64(s.count), labels...)
+	}
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// escapes backslashes and newlines, and quotes in label values
+func escape(s string, quotes bool) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, "\n", `\n`)
+
+	if quotes {
+		s = strings.ReplaceAll(s, `"`, `\"`)
+	}
+
+	return s
+}
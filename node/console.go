@@ -0,0 +1,66 @@
+package node
+
+import "sync"
+
+const consoleHistory = 500
+
+// recent console output of a node, and the channels following it
+type console struct {
+	lock  sync.Mutex
+	lines []string
+	subs  map[chan string]bool
+}
+
+func (n *Node) writeConsole(line string) {
+	c := &n.console
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if len(c.lines) == consoleHistory {
+		copy(c.lines, c.lines[1:])
+		c.lines = c.lines[:consoleHistory-1]
+	}
+	c.lines = append(c.lines, line)
+
+	for sub := range c.subs {
+		select {
+		case sub <- line:
+		default:
+			// drop lines for followers that can't keep up rather than stall the server
+		}
+	}
+}
+
+// recent console output, oldest first
+func (n *Node) ConsoleHistory() []string {
+	c := &n.console
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return append([]string{}, c.lines...)
+}
+
+// follows console output, along with the history at the moment of subscribing
+func (n *Node) FollowConsole() ([]string, <-chan string, func()) {
+	c := &n.console
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.subs == nil {
+		c.subs = make(map[chan string]bool)
+	}
+
+	sub := make(chan string, 256)
+	c.subs[sub] = true
+
+	cancel := func() {
+		c.lock.Lock()
+		delete(c.subs, sub)
+		c.lock.Unlock()
+	}
+
+	return append([]string{}, c.lines...), sub, cancel
+}
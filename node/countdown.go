@@ -0,0 +1,383 @@
+package node
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"lolarobins.ca/overload/audit"
+	"lolarobins.ca/overload/log"
+)
+
+// how players are warned of a restart, and whether it goes ahead depending on whether any are online
+type RestartConfig struct {
+	Warnings  []string                   `json:"warnings"`  // how long before the restart each warning is sent, such as '10m' or '30s'
+	Broadcast string                     `json:"broadcast"` // 'say' or 'tellraw'
+	Message   string                     `json:"message"`   // {time} is replaced with the time left
+	Color     string                     `json:"color"`     // of tellraw messages
+	Empty     string                     `json:"empty"`     // 'restart', 'skip' or 'defer' when no players are online
+	Occupied  string                     `json:"occupied"`  // and when players are online
+	Defer     int                        `json:"defer"`     // minutes between checks of a deferred restart
+	MaxDefer  int                        `json:"maxdefer"`  // minutes a restart can be deferred before it goes ahead anyway
+	Nodes     map[string]RestartOverride `json:"nodes"`
+}
+
+// settings of one node, in place of the defaults
+type RestartOverride struct {
+	Warnings  []string `json:"warnings,omitempty"`
+	Broadcast string   `json:"broadcast,omitempty"`
+	Message   string   `json:"message,omitempty"`
+	Empty     string   `json:"empty,omitempty"`
+	Occupied  string   `json:"occupied,omitempty"`
+}
+
+const (
+	PolicyRestart = "restart"
+	PolicySkip    = "skip"
+	PolicyDefer   = "defer"
+)
+
+const restartsFile = "config/restarts.json"
+
+var Restarts = RestartConfig{
+	Warnings:  []string{"10m", "5m", "1m", "30s", "10s"},
+	Broadcast: "say",
+	Message:   "Server restarting in {time}",
+	Color:     "yellow",
+	Empty:     PolicyRestart,
+	Occupied:  PolicyRestart,
+	Defer:     5,
+	MaxDefer:  120,
+	Nodes:     map[string]RestartOverride{},
+}
+
+var ErrRestartSkipped = errors.New("restart skipped as players are or aren't online")
+var ErrRestartCancelled = errors.New("restart cancelled")
+
+// closed to cancel the countdown of a node
+var countdowns = make(map[string]chan struct{})
+var countdownLock = new(sync.Mutex)
+
+// an unreadable or invalid file is logged and the defaults used instead, so it never stops nodes from loading
+func loadRestarts() {
+	data, err := os.ReadFile(restartsFile)
+	if err != nil {
+		data, _ := json.MarshalIndent(Restarts, "", "    ")
+
+		if err := os.WriteFile(restartsFile, data, 0600); err != nil {
+			log.Error("Could not write '" + restartsFile + "', using default restart warnings")
+		}
+		return
+	}
+
+	config := Restarts
+	config.Nodes = map[string]RestartOverride{}
+
+	if err := json.Unmarshal(data, &config); err != nil {
+		log.Error("'" + restartsFile + "' cannot be parsed, using default restart warnings")
+		return
+	}
+
+	if err := config.validate(); err != nil {
+		log.Error("'" + restartsFile + "': " + err.Error() + ", using default restart warnings")
+		return
+	}
+
+	for id := range config.Nodes {
+		if err := config.of(id).validate(); err != nil {
+			log.Error("'" + restartsFile + "' node " + id + ": " + err.Error() + ", using the defaults for it")
+			delete(config.Nodes, id)
+		}
+	}
+
+	Restarts = config
+}
+
+// the settings of a node with its overrides applied
+func (c RestartConfig) of(id string) RestartConfig {
+	o := c.Nodes[id]
+
+	if len(o.Warnings) > 0 {
+		c.Warnings = o.Warnings
+	}
+	if o.Broadcast != "" {
+		c.Broadcast = o.Broadcast
+	}
+	if o.Message != "" {
+		c.Message = o.Message
+	}
+	if o.Empty != "" {
+		c.Empty = o.Empty
+	}
+	if o.Occupied != "" {
+		c.Occupied = o.Occupied
+	}
+
+	return c
+}
+
+func (c RestartConfig) validate() error {
+	if _, err := c.warnings(); err != nil {
+		return err
+	}
+
+	if c.Broadcast != "say" && c.Broadcast != "tellraw" {
+		return errors.New("broadcast must be 'say' or 'tellraw'")
+	}
+
+	for _, policy := range []string{c.Empty, c.Occupied} {
+		if policy != PolicyRestart && policy != PolicySkip && policy != PolicyDefer {
+			return errors.New("'" + policy + "' is not a policy, expected restart, skip or defer")
+		}
+	}
+
+	if c.Defer < 1 || c.MaxDefer < 0 {
+		return errors.New("defer must be at least 1 minute and maxdefer can't be negative")
+	}
+
+	return nil
+}
+
+// the warnings as durations, longest first
+func (c RestartConfig) warnings() ([]time.Duration, error) {
+	warnings := []time.Duration{}
+
+	for _, w := range c.Warnings {
+		d, err := time.ParseDuration(w)
+		if err != nil || d <= 0 {
+			return nil, errors.New("invalid warning '" + w + "', expected a time such as '10m' or '30s'")
+		}
+
+		warnings = append(warnings, d)
+	}
+
+	sort.Slice(warnings, func(i, j int) bool { return warnings[i] > warnings[j] })
+
+	return warnings, nil
+}
+
+// players online according to the console or the server list ping, whichever knows of more
+func (n *Node) Online() int {
+	online := len(n.Players())
+
+	if status, ok := n.Ping(); ok && status.Online > online {
+		online = status.Online
+	}
+
+	return online
+}
+
+// whether a countdown to restart the node is running
+func (n *Node) CountingDown() bool {
+	countdownLock.Lock()
+	defer countdownLock.Unlock()
+
+	_, ok := countdowns[n.Id]
+	return ok
+}
+
+func (n *Node) CancelCountdown() error {
+	countdownLock.Lock()
+	defer countdownLock.Unlock()
+
+	cancel, ok := countdowns[n.Id]
+	if !ok {
+		return errors.New("node is not counting down to a restart")
+	}
+
+	close(cancel)
+	delete(countdowns, n.Id)
+
+	return nil
+}
+
+// restarts the node once the countdown's warnings have been sent to the players online, restarting straight
+// away when there are none, unless the policy skips or defers it
+func (n *Node) CountdownRestart() error {
+	if !n.active {
+		return errors.New("node is not currently active")
+	}
+
+	c := Restarts.of(n.Id)
+	warnings, err := c.warnings()
+	if err != nil {
+		return err
+	}
+
+	cancel := make(chan struct{})
+
+	countdownLock.Lock()
+	if _, ok := countdowns[n.Id]; ok {
+		countdownLock.Unlock()
+		return errors.New("node is already counting down to a restart")
+	}
+	countdowns[n.Id] = cancel
+	countdownLock.Unlock()
+
+	defer func() {
+		countdownLock.Lock()
+		if countdowns[n.Id] == cancel {
+			delete(countdowns, n.Id)
+		}
+		countdownLock.Unlock()
+	}()
+
+	deadline := time.Now().Add(time.Duration(c.MaxDefer) * time.Minute)
+
+	for deferred := false; ; deferred = true {
+		policy := c.Occupied
+		if n.Online() == 0 {
+			policy = c.Empty
+		}
+
+		if policy == PolicySkip {
+			log.Info("Skipping restart of " + n.Config.Name + " (" + n.Id + ") with " + strconv.Itoa(n.Online()) + " players online")
+			return ErrRestartSkipped
+		}
+
+		if policy == PolicyRestart {
+			break
+		}
+
+		if !time.Now().Before(deadline) {
+			log.Info("Restart of " + n.Config.Name + " (" + n.Id + ") deferred for " + strconv.Itoa(c.MaxDefer) + " minutes, restarting anyway")
+			break
+		}
+
+		if !deferred {
+			log.Info("Deferring restart of " + n.Config.Name + " (" + n.Id + ") with " + strconv.Itoa(n.Online()) + " players online")
+		}
+
+		select {
+		case <-cancel:
+			return ErrRestartCancelled
+		case <-time.After(time.Duration(c.Defer) * time.Minute):
+		}
+
+		if !n.active {
+			return errors.New("node stopped before it was restarted")
+		}
+	}
+
+	if n.Online() > 0 && len(warnings) > 0 {
+		log.Info("Restarting " + n.Config.Name + " (" + n.Id + ") in " + left(warnings[0]))
+
+		restart := time.Now().Add(warnings[0])
+
+		for i := 0; i <= len(warnings); i++ {
+			until := restart
+			if i < len(warnings) {
+				until = restart.Add(-warnings[i])
+			}
+
+			select {
+			case <-cancel:
+				return ErrRestartCancelled
+			case <-time.After(time.Until(until)):
+			}
+
+			if !n.active {
+				return errors.New("node stopped before it was restarted")
+			}
+
+			if i < len(warnings) {
+				if err := n.warn(c, strings.ReplaceAll(c.Message, "{time}", left(warnings[i]))); err != nil {
+					log.Error("Error warning players of " + n.Config.Name + " (" + n.Id + "): " + err.Error())
+				}
+			}
+		}
+	}
+
+	log.Info("Restarting " + n.Config.Name + " (" + n.Id + ")")
+
+	return n.Restart()
+}
+
+// the restart command's countdown and cancel, for a node or all of them
+func countdown(id string, action string) {
+	nodes := []*Node{}
+
+	if id == "*" {
+		for _, n := range All() {
+			if n.IsRunning() || action == "cancel" {
+				nodes = append(nodes, n)
+			}
+		}
+	} else {
+		n, err := Get(id)
+		if err != nil {
+			log.Error("Error restarting node: " + err.Error())
+			return
+		}
+
+		nodes = append(nodes, n)
+	}
+
+	switch action {
+	case "countdown":
+		audit.Record(audit.Entry{Actor: audit.Console, Source: audit.CLI, Action: "node.countdown", Target: id})
+
+		for _, n := range nodes {
+			go func(n *Node) {
+				if err := n.CountdownRestart(); err != nil && err != ErrRestartSkipped && err != ErrRestartCancelled {
+					log.Error("Error restarting " + n.Config.Name + " (" + n.Id + "): " + err.Error())
+				}
+			}(n)
+		}
+	case "cancel":
+		cancelled := false
+
+		for _, n := range nodes {
+			if err := n.CancelCountdown(); err == nil {
+				log.Info("Cancelled restart of " + n.Config.Name + " (" + n.Id + ")")
+				cancelled = true
+			} else if id != "*" {
+				log.Error("Error cancelling restart: " + err.Error())
+				return
+			}
+		}
+
+		if cancelled {
+			audit.Record(audit.Entry{Actor: audit.Console, Source: audit.CLI, Action: "node.countdown.cancel", Target: id})
+		}
+	default:
+		log.Error("Invalid arguments")
+	}
+}
+
+// broadcasts a message to everyone on the server
+func (n *Node) warn(c RestartConfig, message string) error {
+	command := "say " + message
+
+	if c.Broadcast == "tellraw" {
+		text, _ := json.Marshal(map[string]string{"text": message, "color": c.Color})
+		command = "tellraw @a " + string(text)
+	}
+
+	_, _, err := n.Command(command)
+	return err
+}
+
+// a duration as players would read it, such as '10 minutes' or '30 seconds'
+func left(d time.Duration) string {
+	unit, size := "second", time.Second
+
+	switch {
+	case d >= time.Hour && d%time.Hour == 0:
+		unit, size = "hour", time.Hour
+	case d >= time.Minute && d%time.Minute == 0:
+		unit, size = "minute", time.Minute
+	}
+
+	count := int((d + size - 1) / size)
+	if count != 1 {
+		unit += "s"
+	}
+
+	return strconv.Itoa(count) + " " + unit
+}
@@ -0,0 +1,247 @@
+//go:build linux
+
+package node
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"unsafe"
+
+	"lolarobins.ca/overload/log"
+)
+
+const cgroupRoot = "/sys/fs/cgroup"
+
+// period cpu.max quotas are measured against, in microseconds
+const cpuPeriod = 100000
+
+// niceness given to nodes with a cpu limit when cgroups can't be used
+const fallbackNice = 10
+
+var cgroupLock sync.Mutex
+
+// directory the cgroups of nodes are created in, empty until cgroups have been set up
+var cgroupBase string
+
+// whether the fallback has been explained, so it's only logged in full once
+var cgroupWarned bool
+
+// puts a node's process under its configured limits, in a cgroup of its own when cgroup v2 is delegated to overload
+func (n *Node) applyLimits(pid int) {
+	if n.Config.CPULimit == 0 && n.Config.MemoryLimit == 0 && n.Config.PidLimit == 0 {
+		return
+	}
+
+	// set up on the first limited node, so overload is left where it is when no limits are used
+	// and tried again by later nodes until it works, as a failure may only be temporary
+	cgroupLock.Lock()
+	if cgroupBase == "" {
+		if base, err := setupCgroups(); err != nil {
+			log.Error("Resource limits cannot use cgroups: " + err.Error())
+
+			if !cgroupWarned {
+				log.Error("Falling back to setrlimit and nice: memory limits cap address space rather than memory used, CPU limits only lower priority and process limits are not applied")
+				cgroupWarned = true
+			}
+		} else {
+			cgroupBase = base
+			log.Info("Applying resource limits with cgroups in " + base)
+		}
+	}
+	cgroupLock.Unlock()
+
+	if cgroupBase != "" {
+		err := n.joinCgroup(pid)
+		if err == nil {
+			return
+		}
+
+		log.Error("Placing " + n.Config.Name + " (" + n.Id + ") in a cgroup: " + err.Error() + ", falling back to setrlimit and nice")
+	}
+
+	if n.Config.MemoryLimit > 0 {
+		limit := uint64(n.Config.MemoryLimit) * 1024 * 1024
+		if err := prlimit(pid, syscall.RLIMIT_AS, syscall.Rlimit{Cur: limit, Max: limit}); err != nil {
+			log.Error("Limiting address space of " + n.Config.Name + " (" + n.Id + "): " + err.Error())
+		}
+	}
+
+	if n.Config.CPULimit > 0 {
+		if err := syscall.Setpriority(syscall.PRIO_PROCESS, pid, fallbackNice); err != nil {
+			log.Error("Lowering priority of " + n.Config.Name + " (" + n.Id + "): " + err.Error())
+		}
+	}
+
+	if n.Config.PidLimit > 0 {
+		log.Error("Process limit of " + n.Config.Name + " (" + n.Id + ") is not applied without cgroups")
+	}
+}
+
+// setrlimit for another process, which the syscall package has no wrapper for
+func prlimit(pid int, resource int, limit syscall.Rlimit) error {
+	if _, _, errno := syscall.RawSyscall6(syscall.SYS_PRLIMIT64, uintptr(pid), uintptr(resource), uintptr(unsafe.Pointer(&limit)), 0, 0, 0); errno != 0 {
+		return errno
+	}
+
+	return nil
+}
+
+// finds the cgroup overload runs in and hands the memory, cpu and pids controllers down to its children
+func setupCgroups() (string, error) {
+	if _, err := os.Stat(cgroupRoot + "/cgroup.controllers"); err != nil {
+		return "", errors.New("cgroup v2 is not mounted at " + cgroupRoot)
+	}
+
+	data, err := os.ReadFile("/proc/self/cgroup")
+	if err != nil {
+		return "", err
+	}
+
+	path := ""
+	for _, line := range strings.Split(string(data), "\n") {
+		if strings.HasPrefix(line, "0::") {
+			path = strings.TrimPrefix(line, "0::")
+		}
+	}
+
+	if path == "" {
+		return "", errors.New("overload is not in a cgroup v2 hierarchy")
+	}
+
+	// already moved by an earlier attempt
+	path = strings.TrimSuffix(path, "/overload")
+
+	base := filepath.Join(cgroupRoot, path)
+
+	// a cgroup with processes in it can't give controllers to its children, so overload moves to a child of its own,
+	// along with any nodes started before without limits
+	if err := emptyCgroup(base); err != nil {
+		return "", err
+	}
+
+	available, err := os.ReadFile(base + "/cgroup.controllers")
+	if err != nil {
+		return "", err
+	}
+
+	enable := []string{}
+	for _, controller := range strings.Fields(string(available)) {
+		switch controller {
+		case "memory", "cpu", "pids":
+			enable = append(enable, "+"+controller)
+		}
+	}
+
+	if len(enable) == 0 {
+		return "", errors.New("no memory, cpu or pids controller is available in " + base)
+	}
+
+	if err := os.WriteFile(base+"/cgroup.subtree_control", []byte(strings.Join(enable, " ")), 0644); err != nil {
+		return "", errors.New("enabling controllers in " + base + ": " + err.Error())
+	}
+
+	return base, nil
+}
+
+// moves every process in the cgroup into its 'overload' child
+func emptyCgroup(base string) error {
+	// processes started while moving the others are picked up on the next pass
+	for pass := 0; pass < 5; pass++ {
+		procs, err := os.ReadFile(base + "/cgroup.procs")
+		if err != nil {
+			return err
+		}
+
+		pids := strings.Fields(string(procs))
+		if len(pids) == 0 {
+			return nil
+		}
+
+		if err := os.Mkdir(base+"/overload", 0755); err != nil && !os.IsExist(err) {
+			return errors.New("cgroup " + base + " is not delegated to overload: " + err.Error())
+		}
+
+		// each write moves a single process
+		for _, pid := range pids {
+			if err := os.WriteFile(base+"/overload/cgroup.procs", []byte(pid), 0644); err != nil && !errors.Is(err, syscall.ESRCH) {
+				return errors.New("moving process " + pid + " into " + base + "/overload: " + err.Error())
+			}
+		}
+	}
+
+	return errors.New("processes kept being started in " + base + " while moving them into " + base + "/overload")
+}
+
+// creates the node's cgroup with its limits, and moves the process into it
+func (n *Node) joinCgroup(pid int) error {
+	dir := cgroupBase + "/node-" + n.Id
+
+	if err := os.Mkdir(dir, 0755); err != nil && !os.IsExist(err) {
+		return err
+	}
+
+	limits := map[string]string{"memory.max": "max", "cpu.max": "max " + strconv.Itoa(cpuPeriod), "pids.max": "max"}
+
+	if n.Config.MemoryLimit > 0 {
+		limits["memory.max"] = strconv.FormatUint(uint64(n.Config.MemoryLimit)*1024*1024, 10)
+
+		// the limit would otherwise be met by swapping
+		if _, err := os.Stat(dir + "/memory.swap.max"); err == nil {
+			limits["memory.swap.max"] = "0"
+		}
+	}
+
+	if n.Config.CPULimit > 0 {
+		limits["cpu.max"] = strconv.Itoa(n.Config.CPULimit*cpuPeriod/100) + " " + strconv.Itoa(cpuPeriod)
+	}
+
+	if n.Config.PidLimit > 0 {
+		limits["pids.max"] = strconv.Itoa(n.Config.PidLimit)
+	}
+
+	for file, value := range limits {
+		if err := os.WriteFile(dir+"/"+file, []byte(value), 0644); err != nil {
+			// a controller that isn't available only matters when its limit is set
+			if value == "max" || strings.HasPrefix(value, "max ") {
+				continue
+			}
+
+			os.Remove(dir)
+			return errors.New("setting " + file + ": " + err.Error())
+		}
+	}
+
+	if err := os.WriteFile(dir+"/cgroup.procs", []byte(strconv.Itoa(pid)), 0644); err != nil {
+		os.Remove(dir)
+		return err
+	}
+
+	n.cgroup = dir
+	return nil
+}
+
+// removes the node's cgroup once its process has exited, reporting if the memory limit killed it
+func (n *Node) releaseLimits() {
+	if n.cgroup == "" {
+		return
+	}
+
+	if events, err := os.ReadFile(n.cgroup + "/memory.events"); err == nil {
+		for _, line := range strings.Split(string(events), "\n") {
+			if strings.HasPrefix(line, "oom_kill ") && line != "oom_kill 0" {
+				log.Error(n.Config.Name + " (" + n.Id + ") was killed for going over its memory limit of " + strconv.Itoa(n.Config.MemoryLimit) + "MB")
+			}
+		}
+	}
+
+	if err := os.Remove(n.cgroup); err != nil {
+		log.Error("Removing cgroup of " + n.Config.Name + " (" + n.Id + "): " + err.Error())
+	}
+
+	n.cgroup = ""
+}
@@ -0,0 +1,16 @@
+//go:build !linux
+
+package node
+
+import "lolarobins.ca/overload/log"
+
+// cgroups, prlimit and setting the priority of another process are only done on linux
+func (n *Node) applyLimits(pid int) {
+	if n.Config.CPULimit == 0 && n.Config.MemoryLimit == 0 && n.Config.PidLimit == 0 {
+		return
+	}
+
+	log.Error("Resource limits are only supported on Linux, " + n.Config.Name + " (" + n.Id + ") is running without them")
+}
+
+func (n *Node) releaseLimits() {}
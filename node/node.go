@@ -9,40 +9,72 @@ import (
 	"math/rand"
 	"os"
 	"os/exec"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"gitlab.com/NebulousLabs/go-upnp"
+	"lolarobins.ca/overload/audit"
+	"lolarobins.ca/overload/event"
 	"lolarobins.ca/overload/input"
 	"lolarobins.ca/overload/log"
+	"lolarobins.ca/overload/parser"
+	"lolarobins.ca/overload/rcon"
 	"lolarobins.ca/overload/settings"
 )
 
 type NodeConfig struct {
-	Name        string `json:"name"`
-	Jar         string `json:"jar"`
-	JVM         string `json:"jvm"`
-	Port        string `json:"port"`
-	Memory      uint16 `json:"memory"`
-	Autostart   bool   `json:"autostart"`
-	PortForward bool   `json:"portforward"`
+	Name            string   `json:"name"`
+	Jar             string   `json:"jar"`
+	JVM             string   `json:"jvm"`
+	Port            string   `json:"port"`
+	Memory          uint16   `json:"memory"`
+	Autostart       bool     `json:"autostart"`
+	PortForward     bool     `json:"portforward"`
+	Tags            []string `json:"tags"`
+	Watchdog        int      `json:"watchdog"`        // failed checks in a row before a hung server is restarted, 0 disables
+	WatchdogSilence int      `json:"watchdogsilence"` // seconds without console output before a server is treated as hung, 0 disables
+	CPULimit        int      `json:"cpulimit"`        // percent of one core, 0 is unlimited
+	MemoryLimit     int      `json:"memorylimit"`     // megabytes of memory for the whole process rather than the heap, 0 is unlimited
+	PidLimit        int      `json:"pidlimit"`        // processes and threads, 0 is unlimited
 }
 
 type Node struct {
-	Id      string
-	Config  NodeConfig
-	active  bool
-	Monitor bool
-	cmd     *exec.Cmd
-	writer  *io.WriteCloser
+	Id       string
+	Config   NodeConfig
+	active   bool
+	Monitor  bool
+	cmd      *exec.Cmd
+	writer   *io.WriteCloser
+	killed   bool
+	done     chan struct{}
+	state    string
+	console  console
+	status   status
+	rconLock sync.Mutex
+	rcon     *rcon.Client
+	cgroup   string
 }
 
-var Nodes = make(map[string]*Node)
+const (
+	StateStopped  = "stopped"
+	StateRunning  = "running"
+	StateStopping = "stopping"
+	StateCrashed  = "crashed"
+)
+
+// loaded nodes by id, only used through nodesLock as nodes are added from other goroutines
+var nodes = make(map[string]*Node)
+var nodesLock = new(sync.RWMutex)
 var Router *upnp.IGD
 var WaitGroup = new(sync.WaitGroup)
 var extIp string
 
+// how long a node is given to stop before it's killed
+const StopTimeout = time.Minute
+
 var DefaultNode = NodeConfig{
 	Name:        "Minecraft Server",
 	JVM:         "java",
@@ -50,6 +82,7 @@ var DefaultNode = NodeConfig{
 	Memory:      1024,
 	Autostart:   false,
 	PortForward: true,
+	Tags:        []string{},
 }
 
 func Init() error {
@@ -91,6 +124,8 @@ func Init() error {
 		return errors.New("fatal: 'config/default-node.json' cannot be parsed")
 	}
 
+	loadRestarts()
+
 	// loads the nodes
 	if info, err := os.Stat("nodes"); !os.IsNotExist(err) && info.IsDir() {
 		files, err := os.ReadDir("nodes")
@@ -117,14 +152,47 @@ func Init() error {
 	// node commands !
 	input.Command{
 		Function: func(s []string) {
+			if len(s) > 2 {
+				log.Error("Invalid arguments")
+				return
+			}
+
+			if len(s) == 2 {
+				node, err := Get(s[1])
+
+				if err != nil {
+					log.Error("Error getting node: " + err.Error())
+					return
+				}
+
+				node.logDetails()
+				return
+			}
+
 			log.Info("Showing nodes:")
 
-			for _, node := range Nodes {
-				log.Info(node.Config.Name + " (" + node.Id + ") > Port: " + node.Config.Port + ", Memory: " + strconv.Itoa(int(node.Config.Memory)) + " Nodes: " + strconv.FormatBool(node.active))
+			for _, node := range All() {
+				line := node.Config.Name + " (" + node.Id + ") > Port: " + node.Config.Port + ", Memory: " + strconv.Itoa(int(node.Config.Memory)) + " Nodes: " + strconv.FormatBool(node.active)
+
+				if status, ok := node.Ping(); ok {
+					line += ", Players: " + strconv.Itoa(status.Online) + "/" + strconv.Itoa(status.Max) + ", Version: " + status.Version + ", MOTD: " + status.MOTD
+
+					if len(status.Sample) > 0 {
+						names := []string{}
+						for _, p := range status.Sample {
+							names = append(names, p.Name)
+						}
+
+						line += " (" + strings.Join(names, ", ") + ")"
+					}
+				}
+
+				log.Info(line)
 			}
 		},
 		Command:     "nodes",
-		Description: "View a list of nodes loaded in memory",
+		Args:        " [id]",
+		Description: "View a list of nodes loaded in memory, or the details of one",
 	}.Register()
 
 	input.Command{
@@ -141,6 +209,7 @@ func Init() error {
 				return
 			}
 
+			audit.Record(audit.Entry{Actor: audit.Console, Source: audit.CLI, Action: "node.create", Target: node.Id})
 			log.Info("Created node " + node.Id + " (Port: " + node.Config.Port + ")")
 		},
 		Command:     "create",
@@ -157,7 +226,8 @@ func Init() error {
 
 			if s[1] == "*" {
 				log.Info("Starting all nodes")
-				for _, n := range Nodes {
+				audit.Record(audit.Entry{Actor: audit.Console, Source: audit.CLI, Action: "node.start", Target: "*"})
+				for _, n := range All() {
 					n.Start()
 				}
 				return
@@ -172,7 +242,10 @@ func Init() error {
 
 			if err := node.Start(); err != nil {
 				log.Error("Error starting node: " + err.Error())
+				return
 			}
+
+			audit.Record(audit.Entry{Actor: audit.Console, Source: audit.CLI, Action: "node.start", Target: node.Id})
 		},
 		Command:     "start",
 		Args:        " <id/*>",
@@ -199,8 +272,18 @@ func Init() error {
 			}
 			msg = strings.TrimSpace(msg)
 
-			if err := node.SendCommand(msg); err != nil {
+			reply, replied, err := node.Command(msg)
+			if err != nil {
 				log.Error("Error sending command: " + err.Error())
+				return
+			}
+
+			audit.Record(audit.Entry{Actor: audit.Console, Source: audit.CLI, Action: "node.command", Target: node.Id, New: msg})
+
+			if replied && reply != "" {
+				for _, line := range strings.Split(strings.TrimRight(reply, "\n"), "\n") {
+					log.Info(node.Id + " > " + line)
+				}
 			}
 		},
 		Command:     "send",
@@ -217,6 +300,7 @@ func Init() error {
 
 			if s[1] == "*" {
 				log.Info("Sending stop command to all nodes")
+				audit.Record(audit.Entry{Actor: audit.Console, Source: audit.CLI, Action: "node.stop", Target: "*"})
 				StopAll()
 				return
 			}
@@ -233,6 +317,7 @@ func Init() error {
 				return
 			}
 
+			audit.Record(audit.Entry{Actor: audit.Console, Source: audit.CLI, Action: "node.stop", Target: node.Id})
 			log.Info("Sending stop command to " + node.Config.Name + " (" + node.Id + ")")
 		},
 		Command:     "stop",
@@ -240,6 +325,48 @@ func Init() error {
 		Description: "Send stop command to a node",
 	}.Register()
 
+	input.Command{
+		Function: func(s []string) {
+			if len(s) == 3 {
+				countdown(s[1], strings.ToLower(s[2]))
+				return
+			}
+
+			if len(s) != 2 {
+				log.Error("Invalid arguments")
+				return
+			}
+
+			if s[1] == "*" {
+				log.Info("Restarting all nodes")
+				audit.Record(audit.Entry{Actor: audit.Console, Source: audit.CLI, Action: "node.restart", Target: "*"})
+				for _, n := range All() {
+					go n.Restart()
+				}
+				return
+			}
+
+			node, err := Get(s[1])
+
+			if err != nil {
+				log.Error("Error restarting node: " + err.Error())
+				return
+			}
+
+			audit.Record(audit.Entry{Actor: audit.Console, Source: audit.CLI, Action: "node.restart", Target: node.Id})
+			log.Info("Restarting " + node.Config.Name + " (" + node.Id + ")")
+
+			go func() {
+				if err := node.Restart(); err != nil {
+					log.Error("Error restarting node: " + err.Error())
+				}
+			}()
+		},
+		Command:     "restart",
+		Args:        " <id/*> [countdown/cancel]",
+		Description: "Stop a node, waiting for it to exit, then start it again, optionally warning players first",
+	}.Register()
+
 	input.Command{
 		Function: func(s []string) {
 			if len(s) != 2 {
@@ -249,6 +376,7 @@ func Init() error {
 
 			if s[1] == "*" {
 				log.Info("Killing all nodes")
+				audit.Record(audit.Entry{Actor: audit.Console, Source: audit.CLI, Action: "node.kill", Target: "*"})
 				KillAll()
 				return
 			}
@@ -265,6 +393,7 @@ func Init() error {
 				return
 			}
 
+			audit.Record(audit.Entry{Actor: audit.Console, Source: audit.CLI, Action: "node.kill", Target: node.Id})
 			log.Info("Sending kill command to " + node.Config.Name + " (" + node.Id + ")")
 		},
 		Command:     "kill",
@@ -272,6 +401,51 @@ func Init() error {
 		Description: "Send kill command to node",
 	}.Register()
 
+	input.Command{
+		Function: func(s []string) {
+			if len(s) > 2 {
+				log.Error("Invalid arguments")
+				return
+			}
+
+			if len(s) == 1 {
+				log.Info("Showing resource usage of running nodes:")
+
+				for _, n := range All() {
+					if stats, ok := n.Stats(); ok {
+						log.Info(n.Config.Name + " (" + n.Id + ") > CPU: " + strconv.FormatFloat(stats.CPU, 'f', 1, 64) + "%, Memory: " + formatBytes(float64(stats.RSS)) + " / " + strconv.Itoa(int(n.Config.Memory)) + " MB, Threads: " + strconv.Itoa(stats.Threads))
+					}
+				}
+				return
+			}
+
+			node, err := Get(s[1])
+
+			if err != nil {
+				log.Error("Error getting node stats: " + err.Error())
+				return
+			}
+
+			stats, ok := node.Stats()
+			if !ok {
+				log.Error("Error getting node stats: node is not currently active")
+				return
+			}
+
+			log.Info("Showing resource usage of " + node.Config.Name + " (" + node.Id + "), sampled " + stats.Time.Format("15:04:05") + ":")
+			log.Info("processes: " + strconv.Itoa(stats.Processes))
+			log.Info("cpu: " + strconv.FormatFloat(stats.CPU, 'f', 1, 64) + "% (" + strconv.FormatFloat(stats.CPUSeconds, 'f', 1, 64) + "s total)")
+			log.Info("memory: " + formatBytes(float64(stats.RSS)) + " resident, " + strconv.Itoa(int(node.Config.Memory)) + " MB configured heap")
+			log.Info("threads: " + strconv.Itoa(stats.Threads))
+			log.Info("open files: " + strconv.Itoa(stats.FDs))
+			log.Info("disk read: " + formatBytes(float64(stats.ReadBytes)) + " (" + formatBytes(stats.ReadRate) + "/s)")
+			log.Info("disk write: " + formatBytes(float64(stats.WriteBytes)) + " (" + formatBytes(stats.WriteRate) + "/s)")
+		},
+		Command:     "stats",
+		Args:        " [id]",
+		Description: "View the CPU, memory, thread, file and disk usage of running nodes",
+	}.Register()
+
 	input.Command{
 		Function: func(s []string) {
 			if len(s) != 2 {
@@ -281,7 +455,7 @@ func Init() error {
 
 			if s[1] == "*" {
 				log.Info("Monitoring all nodes")
-				for _, n := range Nodes {
+				for _, n := range All() {
 					n.Monitor = true
 				}
 				return
@@ -316,7 +490,8 @@ func Init() error {
 
 			if s[1] == "*" {
 				log.Info("Accepted EULA for all nodes")
-				for _, n := range Nodes {
+				audit.Record(audit.Entry{Actor: audit.Console, Source: audit.CLI, Action: "node.eula", Target: "*"})
+				for _, n := range All() {
 					n.AcceptEULA()
 				}
 				return
@@ -331,8 +506,11 @@ func Init() error {
 
 			if err := node.AcceptEULA(); err != nil {
 				log.Error("Error accepting EULA: " + err.Error())
+				return
 			}
 
+			audit.Record(audit.Entry{Actor: audit.Console, Source: audit.CLI, Action: "node.eula", Target: node.Id})
+
 			log.Info("Accepted EULA for " + node.Config.Name + " (" + node.Id + ")")
 		},
 		Command:     "eula",
@@ -358,6 +536,12 @@ func Init() error {
 				log.Info("memory (mb): " + strconv.Itoa(int(node.Config.Memory)))
 				log.Info("autostart: " + strconv.FormatBool(node.Config.Autostart))
 				log.Info("portforward: " + strconv.FormatBool(node.Config.PortForward))
+				log.Info("tags: " + strings.Join(node.Config.Tags, ","))
+				log.Info("watchdog: " + strconv.Itoa(node.Config.Watchdog))
+				log.Info("watchdogsilence (s): " + strconv.Itoa(node.Config.WatchdogSilence))
+				log.Info("cpulimit (%): " + strconv.Itoa(node.Config.CPULimit))
+				log.Info("memorylimit (mb): " + strconv.Itoa(node.Config.MemoryLimit))
+				log.Info("pidlimit: " + strconv.Itoa(node.Config.PidLimit))
 
 				return
 			}
@@ -383,17 +567,23 @@ func Init() error {
 
 			if s[1] == "*" {
 				log.Info("Set " + strings.ToLower(s[2]) + " to " + val + " for all nodes")
-				for _, n := range Nodes {
-					n.SetConfig(strings.ToLower(s[2]), val)
+				for _, n := range All() {
+					old := n.ConfigValue(strings.ToLower(s[2]))
+					if n.SetConfig(strings.ToLower(s[2]), val) == nil {
+						audit.Record(audit.Entry{Actor: audit.Console, Source: audit.CLI, Action: "node.config." + strings.ToLower(s[2]), Target: n.Id, Old: old, New: n.ConfigValue(strings.ToLower(s[2]))})
+					}
 				}
 				return
 			}
 
+			old := node.ConfigValue(strings.ToLower(s[2]))
 			if err := node.SetConfig(strings.ToLower(s[2]), val); err != nil {
 				log.Error("Error changing node config: " + err.Error())
 				return
 			}
 
+			audit.Record(audit.Entry{Actor: audit.Console, Source: audit.CLI, Action: "node.config." + strings.ToLower(s[2]), Target: node.Id, Old: old, New: node.ConfigValue(strings.ToLower(s[2]))})
+
 			log.Info("Set " + strings.ToLower(s[2]) + " to " + val + " for " + node.Config.Name + " (" + node.Id + ")")
 		},
 		Command:     "config",
@@ -406,7 +596,7 @@ func Init() error {
 }
 
 func Load(id string) (*Node, error) {
-	if n, ok := Nodes[id]; ok {
+	if n, err := Get(id); err == nil {
 		return n, nil
 	}
 
@@ -427,17 +617,22 @@ func Load(id string) (*Node, error) {
 
 	n.SaveConfig()
 
+	if err := add(n); err != nil {
+		return nil, err
+	}
+
 	if n.Config.Autostart {
 		n.Start()
 	}
 
-	Nodes[id] = n
-
 	return n, nil
 }
 
 func Get(id string) (*Node, error) {
-	n, ok := Nodes[id]
+	nodesLock.RLock()
+	n, ok := nodes[id]
+	nodesLock.RUnlock()
+
 	if !ok {
 		return nil, errors.New("node '" + id + "' does not exist or is not loaded into memory")
 	}
@@ -445,6 +640,65 @@ func Get(id string) (*Node, error) {
 	return n, nil
 }
 
+// reads the node's configuration from its directory again, after it was replaced
+func (n *Node) ReloadConfig() error {
+	config := DefaultNode
+
+	data, err := os.ReadFile("nodes/" + n.Id + "/node.json")
+	if err != nil {
+		return errors.New("'nodes/" + n.Id + "/node.json' does not exist")
+	}
+
+	if err := json.Unmarshal(data, &config); err != nil {
+		return errors.New("'nodes/" + n.Id + "/node.json' cannot be parsed")
+	}
+
+	n.Config = config
+	return nil
+}
+
+// loads a node directory copied from another node, giving it a port and rcon password of its own and leaving it stopped
+func Adopt(id string) (*Node, error) {
+	if _, err := Get(id); err == nil {
+		return nil, errors.New("node '" + id + "' already exists")
+	}
+
+	n := &Node{Id: id, Config: DefaultNode}
+
+	data, err := os.ReadFile("nodes/" + id + "/node.json")
+	if err != nil {
+		return nil, errors.New("'nodes/" + id + "/node.json' does not exist")
+	}
+
+	if err := json.Unmarshal(data, &n.Config); err != nil {
+		return nil, errors.New("'nodes/" + id + "/node.json' cannot be parsed")
+	}
+
+	n.Config.Port = strconv.Itoa(rand.Intn(45000-10000) + 10000)
+	n.Config.Autostart = false
+
+	if err := n.SaveConfig(); err != nil {
+		return nil, err
+	}
+
+	// cleared so a new rcon port and password are generated
+	if _, err := os.Stat("nodes/" + id + "/server.properties"); err == nil {
+		if err := setProperties("nodes/"+id+"/server.properties", map[string]string{"rcon.password": ""}); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := n.setupRCON(); err != nil {
+		log.Error("Enabling RCON for " + n.Config.Name + " (" + n.Id + "): " + err.Error())
+	}
+
+	if err := add(n); err != nil {
+		return nil, err
+	}
+
+	return n, nil
+}
+
 func Create(id string) (*Node, error) {
 	if node, _ := Get(id); node != nil {
 		return nil, errors.New("node '" + id + "' already exists")
@@ -462,19 +716,51 @@ func Create(id string) (*Node, error) {
 		return nil, node.SaveConfig()
 	}
 
-	Nodes[id] = &node
+	if err := node.setupRCON(); err != nil {
+		log.Error("Enabling RCON for " + node.Config.Name + " (" + node.Id + "): " + err.Error())
+	}
+
+	if err := add(&node); err != nil {
+		return nil, err
+	}
 
 	return &node, nil
 }
 
+func add(n *Node) error {
+	nodesLock.Lock()
+	defer nodesLock.Unlock()
+
+	if _, ok := nodes[n.Id]; ok {
+		return errors.New("node '" + n.Id + "' already exists")
+	}
+
+	nodes[n.Id] = n
+	return nil
+}
+
+// every loaded node sorted by id, safe to use while nodes are being added
+func All() []*Node {
+	nodesLock.RLock()
+	list := make([]*Node, 0, len(nodes))
+	for _, n := range nodes {
+		list = append(list, n)
+	}
+	nodesLock.RUnlock()
+
+	sort.Slice(list, func(i, j int) bool { return list[i].Id < list[j].Id })
+
+	return list
+}
+
 func StopAll() {
-	for _, n := range Nodes {
+	for _, n := range All() {
 		n.SendCommand("stop")
 	}
 }
 
 func KillAll() {
-	for _, n := range Nodes {
+	for _, n := range All() {
 		n.Kill()
 	}
 }
@@ -504,6 +790,7 @@ func (n *Node) Start() error {
 	n.active = true
 
 	ip := settings.Settings.Hostname
+	forwarded := false
 
 	port, _ := strconv.Atoi(n.Config.Port)
 	if n.Config.PortForward && settings.Settings.UPnP {
@@ -516,41 +803,104 @@ func (n *Node) Start() error {
 			log.Error("Failed to port forward " + n.Config.Name + ": " + err.Error())
 		} else {
 			ip = extIp
+			forwarded = true
 		}
 	}
 
 	log.Info("Starting " + n.Config.Name + " (" + n.Id + ") on " + ip + ":" + n.Config.Port)
 
-	n.cmd = exec.Command(n.Config.JVM, "-Xmx"+strconv.Itoa(int(n.Config.Memory))+"M", "-jar", "../../jar/"+n.Config.Jar, "--host", settings.Settings.Hostname, "--port", n.Config.Port, "--nogui")
-	n.cmd.Dir = "nodes/" + n.Id
+	if err := n.setupRCON(); err != nil {
+		log.Error("Enabling RCON for " + n.Config.Name + " (" + n.Id + "): " + err.Error())
+	}
+
+	if err := n.setupQuery(); err != nil {
+		log.Error("Setting query port for " + n.Config.Name + " (" + n.Id + "): " + err.Error())
+	}
+
+	cmd := exec.Command(n.Config.JVM, "-Xmx"+strconv.Itoa(int(n.Config.Memory))+"M", "-jar", "../../jar/"+n.Config.Jar, "--host", settings.Settings.Hostname, "--port", n.Config.Port, "--nogui")
+	cmd.Dir = "nodes/" + n.Id
+
+	reader, _ := cmd.StdoutPipe()
+	writer, _ := cmd.StdinPipe()
 
-	reader, _ := n.cmd.StdoutPipe()
-	writer, _ := n.cmd.StdinPipe()
+	if err := cmd.Start(); err != nil {
+		n.active = false
+		n.state = StateCrashed
+
+		if n.Config.PortForward && settings.Settings.UPnP {
+			Router.Clear(uint16(port))
+		}
+
+		event.Publish(event.NodeCrash, n.Id, map[string]interface{}{"error": err.Error()})
+		return err
+	}
+
+	n.cmd = cmd
 	n.writer = &writer
+	n.killed = false
+	n.state = StateRunning
+	n.done = make(chan struct{})
+	done := n.done
+
+	n.applyLimits(cmd.Process.Pid)
+	n.resetStatus(forwarded)
+	go n.sample(cmd.Process.Pid, done)
+	go n.poll(done)
+	go n.watch(done)
+
+	event.Publish(event.NodeStart, n.Id, map[string]interface{}{"port": n.Config.Port})
 
 	scanner := bufio.NewScanner(reader)
+	lines := parser.New()
 
 	WaitGroup.Add(1)
 
 	go func() {
 		for scanner.Scan() {
+			line := scanner.Text()
+
 			if n.Monitor {
-				println(n.Id + " > " + scanner.Text())
+				println(n.Id + " > " + line)
 			}
+
+			n.writeConsole(line)
+			n.countLine(line)
+
+			for _, m := range lines.Feed(line) {
+				n.handleMatch(m)
+			}
+		}
+
+		if m := lines.Flush(); m != nil {
+			n.handleMatch(*m)
 		}
 
+		err := cmd.Wait()
+
 		n.active = false
-		log.Info("Stopped " + n.Config.Name + " (" + n.Id + ")")
+		n.releaseLimits()
+
+		// a non-zero exit that wasn't asked for is a crash
+		if err != nil && !n.killed {
+			n.state = StateCrashed
+			log.Error(n.Config.Name + " (" + n.Id + ") exited unexpectedly: " + err.Error())
+			event.Publish(event.NodeCrash, n.Id, map[string]interface{}{"error": err.Error()})
+		} else {
+			n.state = StateStopped
+			log.Info("Stopped " + n.Config.Name + " (" + n.Id + ")")
+			event.Publish(event.NodeStop, n.Id, map[string]interface{}{"killed": n.killed})
+		}
 
 		if n.Config.PortForward && settings.Settings.UPnP {
 			Router.Clear(uint16(port))
 		}
 
+		n.clearStatus()
+		n.closeRCON()
+		close(done)
 		WaitGroup.Done()
 	}()
 
-	n.cmd.Start()
-
 	return nil
 }
 
@@ -560,27 +910,163 @@ func (n *Node) SendCommand(command string) error {
 	}
 
 	io.WriteString(*n.writer, command+"\n")
+	n.writeConsole("> " + command)
 
 	return nil
 }
 
+// kills the process and waits for it to exit, so the node is no longer active once it returns
 func (n *Node) Kill() error {
 	if !n.active {
 		return errors.New("node is not currently active")
 	}
 
-	n.active = false
-	return n.cmd.Process.Kill()
+	done := n.done
+	n.killed = true
+
+	if err := n.cmd.Process.Kill(); err != nil {
+		return err
+	}
+
+	<-done
+	return nil
+}
+
+// sends the stop command and waits for the process to exit, killing it if it takes too long
+func (n *Node) Stop(timeout time.Duration) error {
+	if !n.active {
+		return errors.New("node is not currently active")
+	}
+
+	done := n.done
+
+	if err := n.SendCommand("stop"); err != nil {
+		return err
+	}
+
+	n.state = StateStopping
+
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		log.Info(n.Config.Name + " (" + n.Id + ") did not stop in time, killing it")
+		n.Kill()
+		<-done
+	}
+
+	return nil
+}
+
+func (n *Node) Restart() error {
+	if n.active {
+		if err := n.Stop(StopTimeout); err != nil {
+			return err
+		}
+	}
+
+	return n.restarted()
+}
+
+// kills the process without waiting for it to stop, then starts it again
+func (n *Node) forceRestart() error {
+	if n.active {
+		if err := n.Kill(); err != nil {
+			return err
+		}
+	}
+
+	return n.restarted()
+}
+
+func (n *Node) restarted() error {
+	n.status.lock.Lock()
+	n.status.restarts++
+	n.status.lock.Unlock()
+
+	event.Publish(event.NodeRestart, n.Id, nil)
+
+	return n.Start()
+}
+
+// closed when the current process exits
+func (n *Node) Done() <-chan struct{} {
+	if n.done == nil {
+		done := make(chan struct{})
+		close(done)
+		return done
+	}
+
+	return n.done
 }
 
 func (n *Node) IsRunning() bool {
 	return n.active
 }
 
+func (n *Node) State() string {
+	if n.state == "" {
+		return StateStopped
+	}
+
+	return n.state
+}
+
+// string form of a configuration value, as accepted by SetConfig
+func (n *Node) ConfigValue(key string) string {
+	switch key {
+	case "name":
+		return n.Config.Name
+	case "port":
+		return n.Config.Port
+	case "jar":
+		return n.Config.Jar
+	case "jvm":
+		return n.Config.JVM
+	case "memory":
+		return strconv.Itoa(int(n.Config.Memory))
+	case "autostart":
+		return strconv.FormatBool(n.Config.Autostart)
+	case "portforward":
+		return strconv.FormatBool(n.Config.PortForward)
+	case "tags":
+		return strings.Join(n.Config.Tags, ",")
+	case "watchdog":
+		return strconv.Itoa(n.Config.Watchdog)
+	case "watchdogsilence":
+		return strconv.Itoa(n.Config.WatchdogSilence)
+	case "cpulimit":
+		return strconv.Itoa(n.Config.CPULimit)
+	case "memorylimit":
+		return strconv.Itoa(n.Config.MemoryLimit)
+	case "pidlimit":
+		return strconv.Itoa(n.Config.PidLimit)
+	}
+
+	return ""
+}
+
 func (n *Node) SetConfig(key string, val string) error {
+	old := n.ConfigValue(key)
+
+	if err := n.Config.set(key, val); err != nil {
+		return err
+	}
+
+	event.Publish(event.NodeConfig, n.Id, map[string]interface{}{"key": key, "old": old, "new": n.ConfigValue(key)})
+
+	return n.SaveConfig()
+}
+
+// checks that SetConfig would accept a value, without changing any node
+func ValidateConfig(key string, val string) error {
+	config := DefaultNode
+	return config.set(key, val)
+}
+
+func (c *NodeConfig) set(key string, val string) error {
 	switch key {
 	case "name":
-		n.Config.Name = val
+		c.Name = val
 	case "port":
 		_, err := strconv.Atoi(val)
 
@@ -588,11 +1074,16 @@ func (n *Node) SetConfig(key string, val string) error {
 			return errors.New("invalid integer value")
 		}
 
-		n.Config.Port = val
+		c.Port = val
 	case "jar":
-		n.Config.Jar = val
+		// run from the shared jar directory, so it can't point anywhere else
+		if strings.ContainsAny(val, "/\\") || val == ".." {
+			return errors.New("jar must be a file name in the 'jar' directory")
+		}
+
+		c.Jar = val
 	case "jvm":
-		n.Config.JVM = val
+		c.JVM = val
 	case "memory":
 		valint, err := strconv.Atoi(val)
 
@@ -600,7 +1091,7 @@ func (n *Node) SetConfig(key string, val string) error {
 			return errors.New("invalid integer value")
 		}
 
-		n.Config.Memory = uint16(valint)
+		c.Memory = uint16(valint)
 	case "autostart":
 		valbool := true
 
@@ -612,7 +1103,7 @@ func (n *Node) SetConfig(key string, val string) error {
 			return errors.New("invalid boolean value")
 		}
 
-		n.Config.Autostart = valbool
+		c.Autostart = valbool
 
 	case "portforward":
 		valbool := true
@@ -625,12 +1116,52 @@ func (n *Node) SetConfig(key string, val string) error {
 			return errors.New("invalid boolean value")
 		}
 
-		n.Config.PortForward = valbool
+		c.PortForward = valbool
+	case "tags":
+		tags := []string{}
+		for _, t := range strings.Split(val, ",") {
+			if t = strings.ToLower(strings.TrimSpace(t)); t != "" {
+				tags = append(tags, t)
+			}
+		}
+
+		c.Tags = tags
+	case "watchdog":
+		valint, err := strconv.Atoi(val)
+
+		if err != nil || valint < 0 {
+			return errors.New("invalid integer value")
+		}
+
+		c.Watchdog = valint
+	case "watchdogsilence":
+		valint, err := strconv.Atoi(val)
+
+		if err != nil || valint < 0 {
+			return errors.New("invalid integer value")
+		}
+
+		c.WatchdogSilence = valint
+	case "cpulimit", "memorylimit", "pidlimit":
+		valint, err := strconv.Atoi(val)
+
+		if err != nil || valint < 0 {
+			return errors.New("invalid integer value")
+		}
+
+		switch key {
+		case "cpulimit":
+			c.CPULimit = valint
+		case "memorylimit":
+			c.MemoryLimit = valint
+		case "pidlimit":
+			c.PidLimit = valint
+		}
 	default:
 		return errors.New("configuration key not found")
 	}
 
-	return n.SaveConfig()
+	return nil
 }
 
 func (n *Node) AcceptEULA() error {
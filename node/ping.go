@@ -0,0 +1,106 @@
+package node
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"lolarobins.ca/overload/log"
+	"lolarobins.ca/overload/ping"
+	"lolarobins.ca/overload/query"
+	"lolarobins.ca/overload/settings"
+)
+
+const pingInterval = 10 * time.Second
+const pingTimeout = 5 * time.Second
+
+// host the node's server is listening on, as seen from overload
+func (n *Node) host() string {
+	host := settings.Settings.Hostname
+	if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+		host = "127.0.0.1"
+	}
+
+	return host
+}
+
+// every node would otherwise query on the default port, so it's kept on the same number as the game port
+func (n *Node) setupQuery() error {
+	path := "nodes/" + n.Id + "/server.properties"
+
+	props, err := readProperties(path)
+	if err != nil {
+		return err
+	}
+
+	if props["enable-query"] != "true" || props["query.port"] == n.Config.Port {
+		return nil
+	}
+
+	if err := setProperties(path, map[string]string{"query.port": n.Config.Port}); err != nil {
+		return err
+	}
+
+	log.Info("Set query port of " + n.Config.Name + " (" + n.Id + ") to " + n.Config.Port)
+	return nil
+}
+
+// pings the server until the process exits, the status is cleared while it doesn't answer
+func (n *Node) poll(done chan struct{}) {
+	ticker := time.NewTicker(pingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+
+		status, err := ping.Ping(net.JoinHostPort(n.host(), n.Config.Port), pingTimeout)
+		if err != nil {
+			status = nil
+		}
+
+		n.setPing(status)
+
+		// the full stat, for servers with query enabled
+		var stat *query.Stat
+		if props, err := readProperties("nodes/" + n.Id + "/server.properties"); err == nil && props["enable-query"] == "true" {
+			if stat, err = query.FullStat(net.JoinHostPort(n.host(), props["query.port"]), pingTimeout); err != nil {
+				stat = nil
+			}
+		}
+
+		n.setQuery(stat)
+	}
+}
+
+// logs what's known about a node from its process, the server list ping and query
+func (n *Node) logDetails() {
+	log.Info("Showing details of " + n.Config.Name + " (" + n.Id + "):")
+	log.Info("state: " + n.State())
+	log.Info("port: " + n.Config.Port)
+
+	if uptime := n.Uptime(); uptime > 0 {
+		log.Info("uptime: " + uptime.Round(time.Second).String())
+	}
+
+	if status, ok := n.Ping(); ok {
+		log.Info("version: " + status.Version + " (protocol " + strconv.Itoa(status.Protocol) + ")")
+		log.Info("motd: " + status.MOTD)
+		log.Info("players: " + strconv.Itoa(status.Online) + "/" + strconv.Itoa(status.Max))
+		log.Info("latency: " + strconv.FormatInt(status.Latency, 10) + "ms")
+	}
+
+	if stat, ok := n.Query(); ok {
+		log.Info("software: " + stat.Software)
+		log.Info("game type: " + stat.GameType)
+		log.Info("map: " + stat.Map)
+		log.Info("online: " + strings.Join(stat.Players, ", "))
+		log.Info("plugins: " + strings.Join(stat.Plugins, ", "))
+	} else if players := n.Players(); len(players) > 0 {
+		log.Info("online: " + strings.Join(players, ", "))
+	}
+}
@@ -0,0 +1,201 @@
+package node
+
+import (
+	"bufio"
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// clock ticks per second used by /proc/<pid>/stat, fixed at 100 on linux
+const clockTicks = 100
+
+const sampleInterval = 5 * time.Second
+
+// resource usage of a node's process and everything it started, sampled from /proc
+type Stats struct {
+	Time       time.Time `json:"time"`
+	Processes  int       `json:"processes"`
+	CPU        float64   `json:"cpu"` // percent of one core since the last sample
+	CPUSeconds float64   `json:"cpuseconds"`
+	RSS        uint64    `json:"rss"`
+	Threads    int       `json:"threads"`
+	FDs        int       `json:"fds"`
+	ReadBytes  uint64    `json:"readbytes"`
+	WriteBytes uint64    `json:"writebytes"`
+	ReadRate   float64   `json:"readrate"` // bytes per second since the last sample
+	WriteRate  float64   `json:"writerate"`
+}
+
+// samples the process tree until the process exits
+func (n *Node) sample(pid int, done chan struct{}) {
+	ticker := time.NewTicker(sampleInterval)
+	defer ticker.Stop()
+
+	last := Stats{}
+
+	for {
+		if stats, err := sampleTree(pid); err == nil {
+			if !last.Time.IsZero() {
+				elapsed := stats.Time.Sub(last.Time).Seconds()
+				stats.CPU = rate(stats.CPUSeconds, last.CPUSeconds, elapsed) * 100
+				stats.ReadRate = rate(float64(stats.ReadBytes), float64(last.ReadBytes), elapsed)
+				stats.WriteRate = rate(float64(stats.WriteBytes), float64(last.WriteBytes), elapsed)
+			}
+
+			n.setStats(stats)
+			last = stats
+		}
+
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// change per second, counters drop when a child process exits so those are treated as no change
+func rate(now float64, last float64, elapsed float64) float64 {
+	if elapsed <= 0 || now < last {
+		return 0
+	}
+
+	return (now - last) / elapsed
+}
+
+func sampleTree(root int) (Stats, error) {
+	pids, err := processTree(root)
+	if err != nil {
+		return Stats{}, err
+	}
+
+	stats := Stats{Time: time.Now()}
+
+	for _, pid := range pids {
+		fields, err := readStat(pid)
+		if err != nil {
+			// exited since listing
+			continue
+		}
+
+		utime, _ := strconv.ParseUint(fields[11], 10, 64)
+		stime, _ := strconv.ParseUint(fields[12], 10, 64)
+
+		stats.Processes++
+		stats.CPUSeconds += float64(utime+stime) / clockTicks
+
+		status := readKeyValues("/proc/" + strconv.Itoa(pid) + "/status")
+		stats.RSS += parseKB(status["VmRSS"])
+		threads, _ := strconv.Atoi(status["Threads"])
+		stats.Threads += threads
+
+		if fds, err := os.ReadDir("/proc/" + strconv.Itoa(pid) + "/fd"); err == nil {
+			stats.FDs += len(fds)
+		}
+
+		io := readKeyValues("/proc/" + strconv.Itoa(pid) + "/io")
+		read, _ := strconv.ParseUint(io["read_bytes"], 10, 64)
+		write, _ := strconv.ParseUint(io["write_bytes"], 10, 64)
+		stats.ReadBytes += read
+		stats.WriteBytes += write
+	}
+
+	if stats.Processes == 0 {
+		return Stats{}, errors.New("process " + strconv.Itoa(root) + " has exited")
+	}
+
+	return stats, nil
+}
+
+// the root process followed by all of its descendants
+func processTree(root int) ([]int, error) {
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		return nil, err
+	}
+
+	children := make(map[int][]int)
+	for _, e := range entries {
+		pid, err := strconv.Atoi(e.Name())
+		if err != nil {
+			continue
+		}
+
+		fields, err := readStat(pid)
+		if err != nil {
+			continue
+		}
+
+		ppid, _ := strconv.Atoi(fields[1])
+		children[ppid] = append(children[ppid], pid)
+	}
+
+	tree := []int{root}
+	for i := 0; i < len(tree); i++ {
+		tree = append(tree, children[tree[i]]...)
+	}
+
+	return tree, nil
+}
+
+// fields of /proc/<pid>/stat after the command name, starting with the state
+func readStat(pid int) ([]string, error) {
+	data, err := os.ReadFile("/proc/" + strconv.Itoa(pid) + "/stat")
+	if err != nil {
+		return nil, err
+	}
+
+	// the command name is in parentheses and may itself contain spaces or parentheses
+	i := strings.LastIndexByte(string(data), ')')
+	if i < 0 {
+		return nil, errors.New("unexpected format of /proc/" + strconv.Itoa(pid) + "/stat")
+	}
+
+	fields := strings.Fields(string(data[i+1:]))
+	if len(fields) < 22 {
+		return nil, errors.New("unexpected format of /proc/" + strconv.Itoa(pid) + "/stat")
+	}
+
+	return fields, nil
+}
+
+// reads 'key: value' lines such as /proc/<pid>/status, missing or unreadable files give an empty map
+func readKeyValues(path string) map[string]string {
+	values := make(map[string]string)
+
+	f, err := os.Open(path)
+	if err != nil {
+		return values
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if key, val, ok := strings.Cut(scanner.Text(), ":"); ok {
+			values[key] = strings.TrimSpace(val)
+		}
+	}
+
+	return values
+}
+
+// bytes from a value such as '1024 kB'
+func parseKB(val string) uint64 {
+	kb, _ := strconv.ParseUint(strings.TrimSuffix(val, " kB"), 10, 64)
+	return kb * 1024
+}
+
+func formatBytes(b float64) string {
+	units := []string{"B", "KB", "MB", "GB", "TB"}
+
+	i := 0
+	for b >= 1024 && i < len(units)-1 {
+		b /= 1024
+		i++
+	}
+
+	return strconv.FormatFloat(b, 'f', 1, 64) + " " + units[i]
+}
@@ -0,0 +1,63 @@
+package node
+
+import (
+	"os"
+	"strings"
+)
+
+// reads a .properties file such as server.properties, a missing file has no properties
+func readProperties(path string) (map[string]string, error) {
+	props := make(map[string]string)
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return props, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, "!") {
+			continue
+		}
+
+		if key, val, ok := strings.Cut(line, "="); ok {
+			props[strings.TrimSpace(key)] = strings.TrimSpace(val)
+		}
+	}
+
+	return props, nil
+}
+
+// changes values in a .properties file, keeping every other line as it was
+func setProperties(path string, values map[string]string) error {
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	lines := []string{}
+	if len(data) > 0 {
+		lines = strings.Split(strings.TrimRight(string(data), "\n"), "\n")
+	}
+
+	set := make(map[string]bool)
+	for i, line := range lines {
+		key, _, ok := strings.Cut(line, "=")
+		key = strings.TrimSpace(key)
+
+		if val, change := values[key]; ok && change && !strings.HasPrefix(key, "#") {
+			lines[i] = key + "=" + val
+			set[key] = true
+		}
+	}
+
+	for key, val := range values {
+		if !set[key] {
+			lines = append(lines, key+"="+val)
+		}
+	}
+
+	return os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0644)
+}
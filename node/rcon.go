@@ -0,0 +1,122 @@
+package node
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	mrand "math/rand"
+	"net"
+	"strconv"
+	"time"
+
+	"lolarobins.ca/overload/log"
+	"lolarobins.ca/overload/rcon"
+)
+
+const rconTimeout = 5 * time.Second
+
+var errNoRCON = errors.New("rcon is not enabled")
+
+// enables rcon in server.properties with a generated port and password, unless it has been set up before
+func (n *Node) setupRCON() error {
+	path := "nodes/" + n.Id + "/server.properties"
+
+	props, err := readProperties(path)
+	if err != nil {
+		return err
+	}
+
+	if props["rcon.password"] != "" {
+		return nil
+	}
+
+	secret := make([]byte, 16)
+	if _, err := rand.Read(secret); err != nil {
+		return err
+	}
+
+	port := n.Config.Port
+	for port == n.Config.Port {
+		port = strconv.Itoa(mrand.Intn(45000-10000) + 10000)
+	}
+
+	if err := setProperties(path, map[string]string{
+		"enable-rcon":   "true",
+		"rcon.port":     port,
+		"rcon.password": hex.EncodeToString(secret),
+	}); err != nil {
+		return err
+	}
+
+	log.Info("Enabled RCON for " + n.Config.Name + " (" + n.Id + ") on port " + port)
+	return nil
+}
+
+// connects to the node's rcon port, reusing the last connection while it works
+func (n *Node) rconClient() (*rcon.Client, error) {
+	if n.rcon != nil {
+		return n.rcon, nil
+	}
+
+	props, err := readProperties("nodes/" + n.Id + "/server.properties")
+	if err != nil {
+		return nil, err
+	}
+
+	if props["enable-rcon"] != "true" || props["rcon.password"] == "" {
+		return nil, errNoRCON
+	}
+
+	client, err := rcon.Dial(net.JoinHostPort(n.host(), props["rcon.port"]), props["rcon.password"], rconTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	n.rcon = client
+	return client, nil
+}
+
+func (n *Node) closeRCON() {
+	n.rconLock.Lock()
+	defer n.rconLock.Unlock()
+
+	if n.rcon != nil {
+		n.rcon.Close()
+		n.rcon = nil
+	}
+}
+
+// runs a command over rcon and returns the reply, falling back to stdin without a reply when rcon can't be reached
+func (n *Node) Command(command string) (string, bool, error) {
+	if !n.active {
+		return "", false, errors.New("node is not currently active")
+	}
+
+	n.rconLock.Lock()
+
+	client, err := n.rconClient()
+	if err != nil {
+		n.rconLock.Unlock()
+		return "", false, n.SendCommand(command)
+	}
+
+	reply, err := client.Command(command)
+	if err == rcon.ErrTooLong {
+		n.rconLock.Unlock()
+		return "", false, n.SendCommand(command)
+	}
+
+	if err != nil {
+		// the command may already have run, so it isn't sent again over stdin
+		// the connection may have gone stale, drop it so the next command reconnects
+		client.Close()
+		n.rcon = nil
+		n.rconLock.Unlock()
+		return "", false, err
+	}
+
+	n.rconLock.Unlock()
+	n.writeConsole("> " + command)
+
+	return reply, true, nil
+}
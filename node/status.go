@@ -0,0 +1,231 @@
+package node
+
+import (
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"lolarobins.ca/overload/event"
+	"lolarobins.ca/overload/parser"
+	"lolarobins.ca/overload/ping"
+	"lolarobins.ca/overload/query"
+)
+
+// what's known about a node from its process and console output, counters last for the life of overload
+type status struct {
+	lock      sync.Mutex
+	started   time.Time
+	restarts  uint64
+	lines     uint64
+	players   map[string]bool
+	tps       float64
+	forwarded bool
+	stats     Stats
+	ping      *ping.Status
+	query     *query.Stat
+	ready     bool
+	output    time.Time // when the console last printed a line
+	dump      io.Writer // console lines are copied here while a thread dump is captured
+}
+
+func (n *Node) resetStatus(forwarded bool) {
+	s := &n.status
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.started = time.Now()
+	s.output = s.started
+	s.ready = false
+	s.players = make(map[string]bool)
+	s.tps = 0
+	s.forwarded = forwarded
+	s.stats = Stats{}
+	s.ping = nil
+	s.query = nil
+}
+
+func (n *Node) clearStatus() {
+	s := &n.status
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.started = time.Time{}
+	s.output = time.Time{}
+	s.ready = false
+	s.dump = nil
+	s.players = nil
+	s.tps = 0
+	s.forwarded = false
+	s.stats = Stats{}
+	s.ping = nil
+	s.query = nil
+}
+
+// keeps track of what's parsed from the console, and publishes it for everything else
+func (n *Node) handleMatch(m parser.Match) {
+	switch m.Type {
+	case event.PlayerJoin:
+		n.setOnline(m.Data["player"].(string), true)
+	case event.PlayerLeave:
+		n.setOnline(m.Data["player"].(string), false)
+	case event.ServerTPS:
+		n.setTPS(m.Data["1m"].(float64))
+	case event.ServerReady:
+		n.status.lock.Lock()
+		n.status.ready = true
+		n.status.lock.Unlock()
+	}
+
+	event.Publish(m.Type, n.Id, m.Data)
+}
+
+func (n *Node) countLine(line string) {
+	s := &n.status
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.lines++
+	s.output = time.Now()
+
+	if s.dump != nil {
+		io.WriteString(s.dump, line+"\n")
+	}
+}
+
+func (n *Node) setOnline(player string, online bool) {
+	s := &n.status
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.players == nil {
+		return
+	}
+
+	if online {
+		s.players[player] = true
+	} else {
+		delete(s.players, player)
+	}
+}
+
+func (n *Node) setStats(stats Stats) {
+	n.status.lock.Lock()
+	n.status.stats = stats
+	n.status.lock.Unlock()
+}
+
+func (n *Node) setPing(status *ping.Status) {
+	n.status.lock.Lock()
+	n.status.ping = status
+	n.status.lock.Unlock()
+}
+
+func (n *Node) setQuery(stat *query.Stat) {
+	n.status.lock.Lock()
+	n.status.query = stat
+	n.status.lock.Unlock()
+}
+
+func (n *Node) setTPS(tps float64) {
+	n.status.lock.Lock()
+	n.status.tps = tps
+	n.status.lock.Unlock()
+}
+
+// how long the current process has been running, zero when stopped
+func (n *Node) Uptime() time.Duration {
+	n.status.lock.Lock()
+	defer n.status.lock.Unlock()
+
+	if n.status.started.IsZero() {
+		return 0
+	}
+
+	return time.Since(n.status.started)
+}
+
+func (n *Node) Restarts() uint64 {
+	n.status.lock.Lock()
+	defer n.status.lock.Unlock()
+
+	return n.status.restarts
+}
+
+// lines the process has written to its console
+func (n *Node) ConsoleLines() uint64 {
+	n.status.lock.Lock()
+	defer n.status.lock.Unlock()
+
+	return n.status.lines
+}
+
+// players seen joining and not yet leaving, sorted
+func (n *Node) Players() []string {
+	n.status.lock.Lock()
+	defer n.status.lock.Unlock()
+
+	players := []string{}
+	for p := range n.status.players {
+		players = append(players, p)
+	}
+
+	sort.Slice(players, func(i, j int) bool {
+		return strings.ToLower(players[i]) < strings.ToLower(players[j])
+	})
+
+	return players
+}
+
+// the last 1m tps reported on the console, only known once the tps command has been run
+func (n *Node) TPS() (float64, bool) {
+	n.status.lock.Lock()
+	defer n.status.lock.Unlock()
+
+	return n.status.tps, n.status.tps > 0
+}
+
+// the latest resource usage sample, only known while the process is running
+func (n *Node) Stats() (Stats, bool) {
+	n.status.lock.Lock()
+	defer n.status.lock.Unlock()
+
+	return n.status.stats, !n.status.stats.Time.IsZero()
+}
+
+// the last server list ping, only known while the server is answering
+func (n *Node) Ping() (ping.Status, bool) {
+	n.status.lock.Lock()
+	defer n.status.lock.Unlock()
+
+	if n.status.ping == nil {
+		return ping.Status{}, false
+	}
+
+	return *n.status.ping, true
+}
+
+// the last full stat query, only known for servers with enable-query that are answering
+func (n *Node) Query() (query.Stat, bool) {
+	n.status.lock.Lock()
+	defer n.status.lock.Unlock()
+
+	if n.status.query == nil {
+		return query.Stat{}, false
+	}
+
+	return *n.status.query, true
+}
+
+// whether the node's port is currently forwarded through UPnP
+func (n *Node) Forwarded() bool {
+	n.status.lock.Lock()
+	defer n.status.lock.Unlock()
+
+	return n.status.forwarded
+}
@@ -0,0 +1,186 @@
+package node
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"time"
+
+	"lolarobins.ca/overload/event"
+	"lolarobins.ca/overload/log"
+	"lolarobins.ca/overload/ping"
+)
+
+const watchdogInterval = 15 * time.Second
+
+// time a server is given to become ready before the watchdog starts checking it
+const watchdogGrace = 5 * time.Minute
+
+// how long jcmd is given, or how long the console is copied after a SIGQUIT
+const dumpTimeout = 30 * time.Second
+const dumpCapture = 3 * time.Second
+
+// checks the server still responds until the process exits, restarting it once it's considered hung
+func (n *Node) watch(done chan struct{}) {
+	ticker := time.NewTicker(watchdogInterval)
+	defer ticker.Stop()
+
+	failures := 0
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+
+		// settings are read on each check so changes apply to a running node
+		limit, silence := n.Config.Watchdog, time.Duration(n.Config.WatchdogSilence)*time.Second
+
+		n.status.lock.Lock()
+		ready, started, output := n.status.ready, n.status.started, n.status.output
+		n.status.lock.Unlock()
+
+		// a stop takes as long as it takes, and is killed by Stop if it takes too long
+		if n.state != StateRunning || (!ready && time.Since(started) < watchdogGrace) {
+			failures = 0
+			continue
+		}
+
+		reason := ""
+
+		if limit > 0 {
+			if err := n.check(); err != nil {
+				failures++
+				log.Error(n.Config.Name + " (" + n.Id + ") did not respond to the watchdog (" + strconv.Itoa(failures) + "/" + strconv.Itoa(limit) + "): " + err.Error())
+
+				if failures >= limit {
+					reason = strconv.Itoa(failures) + " checks failed in a row, the last with: " + err.Error()
+				}
+			} else {
+				failures = 0
+			}
+		}
+
+		if quiet := time.Since(output); silence > 0 && quiet >= silence {
+			reason = "no console output for " + quiet.Round(time.Second).String()
+		}
+
+		if reason != "" {
+			go n.hung(reason)
+			return
+		}
+	}
+}
+
+// runs 'list' over rcon, which has to wait for the main thread, or pings the server when rcon isn't enabled
+func (n *Node) check() error {
+	n.rconLock.Lock()
+
+	client, err := n.rconClient()
+	if err == nil {
+		if _, err = client.Command("list"); err != nil {
+			client.Close()
+			n.rcon = nil
+		}
+	}
+
+	n.rconLock.Unlock()
+
+	if err != errNoRCON {
+		return err
+	}
+
+	_, err = ping.Ping(net.JoinHostPort(n.host(), n.Config.Port), pingTimeout)
+	return err
+}
+
+// saves what every thread was doing, then restarts the server
+func (n *Node) hung(reason string) {
+	log.Error(n.Config.Name + " (" + n.Id + ") is not responding, " + reason)
+	event.Publish(event.NodeHang, n.Id, map[string]interface{}{"reason": reason})
+
+	if path, err := n.threadDump(); err != nil {
+		log.Error("Capturing thread dump of " + n.Config.Name + " (" + n.Id + "): " + err.Error())
+	} else {
+		log.Info("Saved thread dump of " + n.Config.Name + " (" + n.Id + ") to " + path)
+	}
+
+	log.Info("Restarting " + n.Config.Name + " (" + n.Id + ")")
+
+	if err := n.forceRestart(); err != nil {
+		log.Error("Error restarting " + n.Config.Name + " (" + n.Id + "): " + err.Error())
+	}
+}
+
+// writes a thread dump into the node directory with jcmd, or from the console after a SIGQUIT when there's no jcmd
+func (n *Node) threadDump() (string, error) {
+	if !n.active {
+		return "", errors.New("node is not currently active")
+	}
+
+	name := "threaddump-" + time.Now().Format("20060102-150405") + ".txt"
+	path := "nodes/" + n.Id + "/" + name
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	pid := n.cmd.Process.Pid
+
+	if jcmd, err := n.jcmd(); err == nil {
+		ctx, cancel := context.WithTimeout(context.Background(), dumpTimeout)
+		defer cancel()
+
+		cmd := exec.CommandContext(ctx, jcmd, strconv.Itoa(pid), "Thread.print", "-l")
+		cmd.Stdout = f
+		cmd.Stderr = f
+
+		if err := cmd.Run(); err == nil {
+			return path, nil
+		}
+
+		f.Truncate(0)
+		f.Seek(0, 0)
+	}
+
+	// the jvm prints the dump to its own output, which is the console
+	n.status.lock.Lock()
+	n.status.dump = f
+	n.status.lock.Unlock()
+
+	err = n.cmd.Process.Signal(syscall.SIGQUIT)
+	if err == nil {
+		time.Sleep(dumpCapture)
+	}
+
+	n.status.lock.Lock()
+	n.status.dump = nil
+	n.status.lock.Unlock()
+
+	if err != nil {
+		os.Remove(path)
+		return "", err
+	}
+
+	return path, nil
+}
+
+// jcmd from the same jdk as the node's jvm, or from the path
+func (n *Node) jcmd() (string, error) {
+	if filepath.Base(n.Config.JVM) != n.Config.JVM {
+		path := filepath.Join(filepath.Dir(n.Config.JVM), "jcmd")
+		if _, err := os.Stat(path); err == nil {
+			return path, nil
+		}
+	}
+
+	return exec.LookPath("jcmd")
+}
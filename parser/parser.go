@@ -0,0 +1,182 @@
+package parser
+
+import (
+	"regexp"
+	"strings"
+	"sync"
+
+	"lolarobins.ca/overload/event"
+)
+
+// a console line split into the parts common to server log formats
+type Line struct {
+	Raw     string
+	Time    string // as printed, without a date
+	Thread  string // only printed by vanilla
+	Level   string
+	Message string
+}
+
+// something recognised on the console, published as an event of the same type
+type Match struct {
+	Type string
+	Data map[string]interface{}
+}
+
+// recognises one kind of line, returning its event data or nil when the line isn't one
+type Rule struct {
+	Type  string
+	Match func(p *Parser, line Line) map[string]interface{}
+}
+
+// parses the console of one server process, keeping what later lines depend on
+type Parser struct {
+	uuids     map[string]string
+	ips       map[string]string
+	online    map[string]bool
+	exception *Match
+	stack     []string
+}
+
+// lines of a stack trace kept in an exception event
+const maxStack = 50
+
+var rulesLock = new(sync.RWMutex)
+
+var prefixes = []*regexp.Regexp{
+	// vanilla: [12:34:56] [Server thread/INFO]: message
+	regexp.MustCompile(`^\[(?P<time>\d{2}:\d{2}:\d{2})\] \[(?P<thread>[^\]]*)/(?P<level>[A-Z]+)\]: (?P<message>.*)$`),
+	// paper and waterfall: [12:34:56 INFO]: message
+	regexp.MustCompile(`^\[(?P<time>\d{2}:\d{2}:\d{2}) (?P<level>[A-Z]+)\]: (?P<message>.*)$`),
+	// older bungeecord: 12:34:56 [INFO] message
+	regexp.MustCompile(`^(?P<time>\d{2}:\d{2}:\d{2}) \[(?P<level>[A-Z]+)\] (?P<message>.*)$`),
+}
+
+var exceptionPattern = regexp.MustCompile(`^(?:Exception in thread "[^"]*" )?((?:[a-zA-Z_$][\w$]*\.)+[\w$]*(?:Exception|Error|Throwable))(?:: (.*))?$`)
+
+func New() *Parser {
+	return &Parser{uuids: make(map[string]string), ips: make(map[string]string), online: make(map[string]bool)}
+}
+
+// adds a rule, tried after those registered before it
+func Register(rule Rule) {
+	rulesLock.Lock()
+	defer rulesLock.Unlock()
+
+	rules = append(rules, rule)
+}
+
+// rule from a pattern matched against the message, named groups become the event data
+func Pattern(eventType string, pattern string) Rule {
+	re := regexp.MustCompile(pattern)
+
+	return Rule{
+		Type: eventType,
+		Match: func(p *Parser, line Line) map[string]interface{} {
+			m := re.FindStringSubmatch(line.Message)
+			if m == nil {
+				return nil
+			}
+
+			data := make(map[string]interface{})
+			for i, name := range re.SubexpNames() {
+				if name != "" {
+					data[name] = m[i]
+				}
+			}
+
+			return data
+		},
+	}
+}
+
+func Split(raw string) Line {
+	for _, re := range prefixes {
+		m := re.FindStringSubmatch(raw)
+		if m == nil {
+			continue
+		}
+
+		line := Line{Raw: raw}
+		for i, name := range re.SubexpNames() {
+			switch name {
+			case "time":
+				line.Time = m[i]
+			case "thread":
+				line.Thread = m[i]
+			case "level":
+				line.Level = m[i]
+			case "message":
+				line.Message = m[i]
+			}
+		}
+
+		return line
+	}
+
+	return Line{Raw: raw, Message: raw}
+}
+
+// parses the next line, returning what it completes; an exception is only returned once its stack trace ends
+func (p *Parser) Feed(raw string) []Match {
+	matches := []Match{}
+	line := Split(raw)
+
+	if p.exception != nil {
+		if trimmed := strings.TrimSpace(line.Message); isStack(trimmed) {
+			if len(p.stack) < maxStack {
+				p.stack = append(p.stack, trimmed)
+			}
+			return matches
+		}
+
+		matches = append(matches, *p.Flush())
+	}
+
+	if m := exceptionPattern.FindStringSubmatch(strings.TrimSpace(line.Message)); m != nil {
+		p.exception = &Match{Type: event.ServerException, Data: map[string]interface{}{"exception": m[1], "message": m[2], "level": line.Level}}
+		p.stack = []string{}
+		return matches
+	}
+
+	rulesLock.RLock()
+	defer rulesLock.RUnlock()
+
+	for _, rule := range rules {
+		if data := rule.Match(p, line); data != nil {
+			matches = append(matches, Match{Type: rule.Type, Data: data})
+			break
+		}
+	}
+
+	return matches
+}
+
+// returns an exception still waiting for the end of its stack trace, for when the process exits
+func (p *Parser) Flush() *Match {
+	if p.exception == nil {
+		return nil
+	}
+
+	m := p.exception
+	m.Data["stack"] = p.stack
+
+	p.exception = nil
+	p.stack = nil
+
+	return m
+}
+
+func isStack(line string) bool {
+	return strings.HasPrefix(line, "at ") || strings.HasPrefix(line, "Caused by: ") || strings.HasPrefix(line, "Suppressed: ") || (strings.HasPrefix(line, "... ") && strings.HasSuffix(line, " more"))
+}
+
+// players that have joined and not left, as seen by this parser
+func (p *Parser) Online(player string) bool {
+	return p.online[player]
+}
+
+// uuid logged for a player when they authenticated, if it was
+func (p *Parser) UUID(player string) string {
+	return p.uuids[player]
+}
@@ -0,0 +1,168 @@
+package parser
+
+import (
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"lolarobins.ca/overload/event"
+)
+
+var (
+	uuidPattern        = regexp.MustCompile(`^UUID of player (\w{1,16}) is ([0-9a-f-]{36})$`)
+	loginPattern       = regexp.MustCompile(`^(\w{1,16})\[/([^\]]+)\] logged in with entity id`)
+	joinPattern        = regexp.MustCompile(`^(\w{1,16})(?: \(formerly known as \w{1,16}\))? joined the game$`)
+	leavePattern       = regexp.MustCompile(`^(\w{1,16}) left the game$`)
+	proxyPattern       = regexp.MustCompile(`^\[(\w{1,16}),/([^\]]+)\] (?:<->|->) (?:InitialHandler|UpstreamBridge) has (connected|disconnected)$`)
+	advancementPattern = regexp.MustCompile(`^(\w{1,16}) has (made the advancement|completed the challenge|reached the goal) \[(.+)\]$`)
+	lagPattern         = regexp.MustCompile(`^Can't keep up! Is the server overloaded\? Running (\d+)ms or (\d+) ticks behind`)
+	readyPattern       = regexp.MustCompile(`^Done \((\d+(?:[.,]\d+)?)s\)! For help, type "help"`)
+	proxyReadyPattern  = regexp.MustCompile(`^Listening on /(\S+)$`)
+	tpsPattern         = regexp.MustCompile(`TPS from last 1m, 5m, 15m: \*?([\d.]+),? \*?([\d.]+),? \*?([\d.]+)`)
+	deathPattern       = regexp.MustCompile(`^(\w{1,16}) (.+)$`)
+	savePattern        = regexp.MustCompile(`^(?:\[\w+: )?(?:Saved the game|Save complete\.?)\]?$`)
+)
+
+// start of every vanilla death message after the player's name
+var deathMessages = []string{
+	"was ", "fell ", "drowned", "died", "blew up", "burned to death", "went up in flames", "went off with a bang",
+	"hit the ground too hard", "tried to swim in lava", "experienced kinetic energy", "walked into", "suffocated",
+	"starved to death", "froze to death", "withered away", "discovered the floor was lava", "didn't want to live",
+	"left the confines of this world", "was squished",
+}
+
+var advancementKinds = map[string]string{
+	"made the advancement":    "advancement",
+	"completed the challenge": "challenge",
+	"reached the goal":        "goal",
+}
+
+// recognised on vanilla, paper and waterfall consoles, in the order they're tried
+var rules = []Rule{
+	{Type: event.PlayerJoin, Match: func(p *Parser, line Line) map[string]interface{} {
+		// logged before joining, only kept for the join that follows
+		if m := uuidPattern.FindStringSubmatch(line.Message); m != nil {
+			p.uuids[m[1]] = m[2]
+			return nil
+		}
+
+		if m := loginPattern.FindStringSubmatch(line.Message); m != nil {
+			p.ips[m[1]] = m[2]
+			return nil
+		}
+
+		if m := joinPattern.FindStringSubmatch(line.Message); m != nil {
+			return p.join(m[1])
+		}
+
+		if m := proxyPattern.FindStringSubmatch(line.Message); m != nil && m[3] == "connected" {
+			p.ips[m[1]] = m[2]
+			return p.join(m[1])
+		}
+
+		return nil
+	}},
+	{Type: event.PlayerLeave, Match: func(p *Parser, line Line) map[string]interface{} {
+		if m := leavePattern.FindStringSubmatch(line.Message); m != nil {
+			return p.leave(m[1])
+		}
+
+		if m := proxyPattern.FindStringSubmatch(line.Message); m != nil && m[3] == "disconnected" {
+			return p.leave(m[1])
+		}
+
+		return nil
+	}},
+	Pattern(event.PlayerChat, `^(?:\[Not Secure\] )?<(?P<player>\w{1,16})> (?P<message>.*)$`),
+	{Type: event.PlayerAdvancement, Match: func(p *Parser, line Line) map[string]interface{} {
+		m := advancementPattern.FindStringSubmatch(line.Message)
+		if m == nil {
+			return nil
+		}
+
+		return map[string]interface{}{"player": m[1], "kind": advancementKinds[m[2]], "advancement": m[3]}
+	}},
+	{Type: event.ServerLag, Match: func(p *Parser, line Line) map[string]interface{} {
+		m := lagPattern.FindStringSubmatch(line.Message)
+		if m == nil {
+			return nil
+		}
+
+		delay, _ := strconv.Atoi(m[1])
+		ticks, _ := strconv.Atoi(m[2])
+		return map[string]interface{}{"delay": delay, "ticks": ticks}
+	}},
+	{Type: event.ServerReady, Match: func(p *Parser, line Line) map[string]interface{} {
+		if m := readyPattern.FindStringSubmatch(line.Message); m != nil {
+			seconds, _ := strconv.ParseFloat(strings.Replace(m[1], ",", ".", 1), 64)
+			return map[string]interface{}{"seconds": seconds}
+		}
+
+		if m := proxyReadyPattern.FindStringSubmatch(line.Message); m != nil {
+			return map[string]interface{}{"address": m[1]}
+		}
+
+		return nil
+	}},
+	{Type: event.ServerTPS, Match: func(p *Parser, line Line) map[string]interface{} {
+		m := tpsPattern.FindStringSubmatch(line.Message)
+		if m == nil {
+			return nil
+		}
+
+		data := make(map[string]interface{})
+		for i, key := range []string{"1m", "5m", "15m"} {
+			data[key], _ = strconv.ParseFloat(m[i+1], 64)
+		}
+
+		return data
+	}},
+	{Type: event.ServerSave, Match: func(p *Parser, line Line) map[string]interface{} {
+		// '[Rcon: Saved the game]' when the save was run over rcon
+		if savePattern.MatchString(line.Message) {
+			return map[string]interface{}{}
+		}
+
+		return nil
+	}},
+	{Type: event.PlayerDeath, Match: func(p *Parser, line Line) map[string]interface{} {
+		// death messages vary too much to match exactly, so only lines about players online are considered
+		m := deathPattern.FindStringSubmatch(line.Message)
+		if m == nil || !p.online[m[1]] {
+			return nil
+		}
+
+		for _, msg := range deathMessages {
+			if strings.HasPrefix(m[2], msg) {
+				return map[string]interface{}{"player": m[1], "message": line.Message}
+			}
+		}
+
+		return nil
+	}},
+}
+
+func (p *Parser) join(player string) map[string]interface{} {
+	p.online[player] = true
+	return map[string]interface{}{"player": player, "uuid": p.uuids[player], "ip": address(p.ips[player])}
+}
+
+func (p *Parser) leave(player string) map[string]interface{} {
+	data := map[string]interface{}{"player": player, "uuid": p.uuids[player], "ip": address(p.ips[player])}
+
+	delete(p.online, player)
+	delete(p.uuids, player)
+	delete(p.ips, player)
+
+	return data
+}
+
+// ip without the port it connected from
+func address(addr string) string {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+
+	return addr
+}
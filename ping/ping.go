@@ -0,0 +1,344 @@
+package ping
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+type Player struct {
+	Name string `json:"name"`
+	Id   string `json:"id,omitempty"`
+}
+
+// what a server reports in the multiplayer server list
+type Status struct {
+	Version  string   `json:"version"`
+	Protocol int      `json:"protocol"`
+	Online   int      `json:"online"`
+	Max      int      `json:"max"`
+	Sample   []Player `json:"sample"`
+	MOTD     string   `json:"motd"`
+	Latency  int64    `json:"latency"` // round trip in milliseconds, zero if the server didn't answer the ping
+	Legacy   bool     `json:"legacy"`  // answered the 1.6 ping, which has no player sample
+}
+
+// status response as sent by the server, the description is either a string or a chat component
+type response struct {
+	Version struct {
+		Name     string `json:"name"`
+		Protocol int    `json:"protocol"`
+	} `json:"version"`
+	Players struct {
+		Max    int      `json:"max"`
+		Online int      `json:"online"`
+		Sample []Player `json:"sample"`
+	} `json:"players"`
+	Description json.RawMessage `json:"description"`
+}
+
+// chat component, only the text is kept
+type component struct {
+	Text  string      `json:"text"`
+	Extra []component `json:"extra"`
+}
+
+// largest status response accepted, favicons make these fairly large
+const maxPacket = 2 << 20
+
+// pings a server, falling back to the 1.6 ping for servers that don't understand the modern handshake
+func Ping(address string, timeout time.Duration) (*Status, error) {
+	status, err := Modern(address, timeout)
+	if err == nil {
+		return status, nil
+	}
+
+	if legacy, lerr := Legacy(address, timeout); lerr == nil {
+		return legacy, nil
+	}
+
+	return nil, err
+}
+
+// the server list ping used since 1.7: a handshake, status request, then a ping to time the round trip
+func Modern(address string, timeout time.Duration) (*Status, error) {
+	host, port, err := splitAddress(address)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := net.DialTimeout("tcp", address, timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	// handshake with protocol -1, meaning the client is only asking for the status
+	handshake := new(bytes.Buffer)
+	writeVarInt(handshake, 0x00)
+	writeVarInt(handshake, -1)
+	writeString(handshake, host)
+	binary.Write(handshake, binary.BigEndian, port)
+	writeVarInt(handshake, 1)
+
+	if err := writePacket(conn, handshake.Bytes()); err != nil {
+		return nil, err
+	}
+
+	if err := writePacket(conn, []byte{0x00}); err != nil {
+		return nil, err
+	}
+
+	reader := bufio.NewReader(conn)
+
+	packet, err := readPacket(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	if id, err := binary.ReadUvarint(packet); err != nil || id != 0x00 {
+		return nil, errors.New("unexpected packet in reply to status request")
+	}
+
+	data, err := readString(packet)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := response{}
+	if err := json.Unmarshal(data, &resp); err != nil {
+		return nil, errors.New("status response cannot be parsed")
+	}
+
+	status := &Status{
+		Version:  resp.Version.Name,
+		Protocol: resp.Version.Protocol,
+		Online:   resp.Players.Online,
+		Max:      resp.Players.Max,
+		Sample:   resp.Players.Sample,
+		MOTD:     description(resp.Description),
+	}
+
+	if status.Sample == nil {
+		status.Sample = []Player{}
+	}
+
+	// ping, some servers close the connection instead of answering which still leaves a usable status
+	sent := time.Now()
+	payload := new(bytes.Buffer)
+	writeVarInt(payload, 0x01)
+	binary.Write(payload, binary.BigEndian, sent.UnixMilli())
+
+	if err := writePacket(conn, payload.Bytes()); err == nil {
+		if _, err := readPacket(reader); err == nil {
+			status.Latency = time.Since(sent).Milliseconds()
+		}
+	}
+
+	return status, nil
+}
+
+// the ping used by 1.4 to 1.6, which newer servers still answer
+func Legacy(address string, timeout time.Duration) (*Status, error) {
+	host, port, err := splitAddress(address)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := net.DialTimeout("tcp", address, timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	sent := time.Now()
+
+	// server list ping with the MC|PingHost plugin message
+	hostData := new(bytes.Buffer)
+	hostData.WriteByte(74)
+	writeUTF16(hostData, host)
+	binary.Write(hostData, binary.BigEndian, int32(port))
+
+	req := new(bytes.Buffer)
+	req.Write([]byte{0xFE, 0x01, 0xFA})
+	writeUTF16(req, "MC|PingHost")
+	binary.Write(req, binary.BigEndian, uint16(hostData.Len()))
+	req.Write(hostData.Bytes())
+
+	if _, err := conn.Write(req.Bytes()); err != nil {
+		return nil, err
+	}
+
+	reader := bufio.NewReader(conn)
+
+	if id, err := reader.ReadByte(); err != nil {
+		return nil, err
+	} else if id != 0xFF {
+		return nil, errors.New("unexpected packet in reply to legacy ping")
+	}
+
+	text, err := readUTF16(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	// §1, protocol, version, motd, online and max players separated by nulls
+	fields := strings.Split(text, "\x00")
+	if len(fields) != 6 || fields[0] != "§1" {
+		return nil, errors.New("legacy ping response cannot be parsed")
+	}
+
+	status := &Status{Version: fields[2], MOTD: stripFormatting(fields[3]), Sample: []Player{}, Latency: time.Since(sent).Milliseconds(), Legacy: true}
+	status.Protocol, _ = strconv.Atoi(fields[1])
+	status.Online, _ = strconv.Atoi(fields[4])
+	status.Max, _ = strconv.Atoi(fields[5])
+
+	return status, nil
+}
+
+func splitAddress(address string) (string, uint16, error) {
+	host, portStr, err := net.SplitHostPort(address)
+	if err != nil {
+		return "", 0, err
+	}
+
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return "", 0, errors.New("invalid port '" + portStr + "'")
+	}
+
+	return host, uint16(port), nil
+}
+
+// plain text of a description, which is either a string or a chat component
+func description(raw json.RawMessage) string {
+	text := ""
+	if err := json.Unmarshal(raw, &text); err == nil {
+		return stripFormatting(text)
+	}
+
+	c := component{}
+	if err := json.Unmarshal(raw, &c); err != nil {
+		return ""
+	}
+
+	return stripFormatting(c.flatten())
+}
+
+func (c component) flatten() string {
+	text := c.Text
+	for _, extra := range c.Extra {
+		text += extra.flatten()
+	}
+
+	return text
+}
+
+// removes § colour and style codes
+func stripFormatting(s string) string {
+	out := []rune{}
+
+	runes := []rune(s)
+	for i := 0; i < len(runes); i++ {
+		if runes[i] == '§' {
+			i++
+			continue
+		}
+
+		out = append(out, runes[i])
+	}
+
+	return strings.TrimSpace(string(out))
+}
+
+func writeVarInt(w *bytes.Buffer, v int32) {
+	u := uint32(v)
+	for {
+		if u&^0x7F == 0 {
+			w.WriteByte(byte(u))
+			return
+		}
+
+		w.WriteByte(byte(u&0x7F) | 0x80)
+		u >>= 7
+	}
+}
+
+func writeString(w *bytes.Buffer, s string) {
+	writeVarInt(w, int32(len(s)))
+	w.WriteString(s)
+}
+
+// writes a packet prefixed with its length
+func writePacket(w io.Writer, data []byte) error {
+	packet := new(bytes.Buffer)
+	writeVarInt(packet, int32(len(data)))
+	packet.Write(data)
+
+	_, err := w.Write(packet.Bytes())
+	return err
+}
+
+func readPacket(r *bufio.Reader) (*bytes.Reader, error) {
+	length, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if length == 0 || length > maxPacket {
+		return nil, errors.New("invalid packet length " + strconv.FormatUint(length, 10))
+	}
+
+	data := make([]byte, length)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, err
+	}
+
+	return bytes.NewReader(data), nil
+}
+
+func readString(r *bytes.Reader) ([]byte, error) {
+	length, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if length > uint64(r.Len()) {
+		return nil, errors.New("string is longer than its packet")
+	}
+
+	data := make([]byte, length)
+	_, err = io.ReadFull(r, data)
+	return data, err
+}
+
+// strings in the legacy ping are a length in characters followed by UTF-16BE
+func writeUTF16(w *bytes.Buffer, s string) {
+	chars := utf16.Encode([]rune(s))
+	binary.Write(w, binary.BigEndian, uint16(len(chars)))
+	binary.Write(w, binary.BigEndian, chars)
+}
+
+func readUTF16(r io.Reader) (string, error) {
+	var length uint16
+	if err := binary.Read(r, binary.BigEndian, &length); err != nil {
+		return "", err
+	}
+
+	chars := make([]uint16, length)
+	if err := binary.Read(r, binary.BigEndian, chars); err != nil {
+		return "", err
+	}
+
+	return string(utf16.Decode(chars)), nil
+}
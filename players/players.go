@@ -0,0 +1,327 @@
+package players
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"lolarobins.ca/overload/event"
+	"lolarobins.ca/overload/input"
+	"lolarobins.ca/overload/log"
+)
+
+// one stay of a player on a node, written when they join and again when they leave
+type Session struct {
+	Id     string     `json:"id"`
+	Player string     `json:"player"`
+	UUID   string     `json:"uuid,omitempty"`
+	Node   string     `json:"node"`
+	IP     string     `json:"ip,omitempty"`
+	Join   time.Time  `json:"join"`
+	Leave  *time.Time `json:"leave,omitempty"`
+	Online bool       `json:"online"` // a session without a leave time that isn't online ended while overload wasn't running
+}
+
+type Filter struct {
+	Player string
+	Node   string
+	Since  time.Time // sessions that ended before this are left out
+	Until  time.Time // sessions that started after this are left out
+	Limit  int
+}
+
+const file = "data/sessions.jsonl"
+
+var lock = new(sync.Mutex)
+
+// sessions of players currently online, keyed by node and lowercase name
+var online = make(map[string]*Session)
+
+func Init() {
+	event.Listen(handle)
+
+	input.Command{
+		Function: func(s []string) {
+			if len(s) > 2 {
+				log.Error("Invalid arguments")
+				return
+			}
+
+			sessions := Online()
+			if len(s) == 2 {
+				sessions = filterNode(sessions, s[1])
+			}
+
+			log.Info("Showing " + strconv.Itoa(len(sessions)) + " online players:")
+			for _, session := range sessions {
+				line := session.Player + " on " + session.Node + " for " + session.Duration().Round(time.Second).String()
+				if session.IP != "" {
+					line += " from " + session.IP
+				}
+
+				log.Info(line)
+			}
+		},
+		Command:     "players",
+		Args:        " [id]",
+		Description: "View the players online on all nodes, or one node",
+	}.Register()
+
+	input.Command{
+		Function: func(s []string) {
+			if len(s) != 2 {
+				log.Error("Invalid arguments")
+				return
+			}
+
+			sessions, err := Query(Filter{Player: s[1], Limit: 10})
+			if err != nil {
+				log.Error("Reading player sessions: " + err.Error())
+				return
+			}
+
+			if len(sessions) == 0 {
+				log.Info(s[1] + " has not been seen")
+				return
+			}
+
+			log.Info("Showing the last " + strconv.Itoa(len(sessions)) + " sessions of " + sessions[len(sessions)-1].Player + ":")
+			for _, session := range sessions {
+				line := session.Node + " > " + session.Join.Local().Format("2006-01-02 15:04:05")
+
+				switch {
+				case session.Online:
+					line += ", online now for " + session.Duration().Round(time.Second).String()
+				case session.Leave != nil:
+					line += " to " + session.Leave.Local().Format("2006-01-02 15:04:05") + " (" + session.Duration().Round(time.Second).String() + ")"
+				default:
+					line += ", left while overload was stopped"
+				}
+
+				if session.IP != "" {
+					line += " from " + session.IP
+				}
+
+				log.Info(line)
+			}
+		},
+		Command:     "seen",
+		Args:        " <name>",
+		Description: "View when a player was last online, and for how long",
+	}.Register()
+}
+
+func handle(e event.Event) {
+	switch e.Type {
+	case event.PlayerJoin:
+		player, _ := e.Data["player"].(string)
+		uuid, _ := e.Data["uuid"].(string)
+		ip, _ := e.Data["ip"].(string)
+
+		join(e.Node, player, uuid, ip, e.Time)
+	case event.PlayerLeave:
+		player, _ := e.Data["player"].(string)
+
+		leave(e.Node, player, e.Time)
+	case event.NodeStop, event.NodeCrash:
+		// players don't get a leave message when the server goes down with them on it
+		for _, session := range filterNode(Online(), e.Node) {
+			leave(e.Node, session.Player, e.Time)
+		}
+	}
+}
+
+func key(node string, player string) string {
+	return node + "/" + strings.ToLower(player)
+}
+
+func join(node string, player string, uuid string, ip string, at time.Time) {
+	id := make([]byte, 8)
+	rand.Read(id)
+
+	session := &Session{Id: hex.EncodeToString(id), Player: player, UUID: uuid, Node: node, IP: ip, Join: at}
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	// a join without a leave means the last one was missed
+	if last, ok := online[key(node, player)]; ok {
+		last.Leave = &at
+		write(*last)
+	}
+
+	online[key(node, player)] = session
+	write(*session)
+}
+
+func leave(node string, player string, at time.Time) {
+	lock.Lock()
+	defer lock.Unlock()
+
+	session, ok := online[key(node, player)]
+	if !ok {
+		return
+	}
+
+	delete(online, key(node, player))
+
+	session.Leave = &at
+	write(*session)
+}
+
+// appends a session to the file, later lines for the same session replace earlier ones
+func write(session Session) {
+	session.Online = false
+
+	data, err := json.Marshal(session)
+	if err != nil {
+		log.Error("Marshalling player session: " + err.Error())
+		return
+	}
+
+	f, err := os.OpenFile(file, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		log.Error("Opening player sessions: " + err.Error())
+		return
+	}
+	defer f.Close()
+
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		log.Error("Writing player sessions: " + err.Error())
+	}
+}
+
+// sessions of players online now, by node then name
+func Online() []Session {
+	lock.Lock()
+	defer lock.Unlock()
+
+	sessions := []Session{}
+	for _, session := range online {
+		s := *session
+		s.Online = true
+		sessions = append(sessions, s)
+	}
+
+	sort.Slice(sessions, func(i, j int) bool {
+		if sessions[i].Node != sessions[j].Node {
+			return sessions[i].Node < sessions[j].Node
+		}
+
+		return strings.ToLower(sessions[i].Player) < strings.ToLower(sessions[j].Player)
+	})
+
+	return sessions
+}
+
+// sessions matching the filter ordered by join time, limited to the most recent
+func Query(filter Filter) ([]Session, error) {
+	lock.Lock()
+	defer lock.Unlock()
+
+	sessions := []Session{}
+
+	f, err := os.Open(file)
+	if os.IsNotExist(err) {
+		return sessions, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	index := make(map[string]int)
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		s := Session{}
+		if err := json.Unmarshal(scanner.Bytes(), &s); err != nil {
+			continue
+		}
+
+		if i, ok := index[s.Id]; ok {
+			sessions[i] = s
+		} else {
+			index[s.Id] = len(sessions)
+			sessions = append(sessions, s)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, errors.New("reading '" + file + "': " + err.Error())
+	}
+
+	matched := []Session{}
+	for _, s := range sessions {
+		if current, ok := online[key(s.Node, s.Player)]; ok && current.Id == s.Id {
+			s.Online = true
+		}
+
+		if filter.matches(s) {
+			matched = append(matched, s)
+		}
+	}
+
+	sort.SliceStable(matched, func(i, j int) bool { return matched[i].Join.Before(matched[j].Join) })
+
+	if filter.Limit > 0 && len(matched) > filter.Limit {
+		matched = matched[len(matched)-filter.Limit:]
+	}
+
+	return matched, nil
+}
+
+func (f Filter) matches(s Session) bool {
+	if f.Player != "" && !strings.EqualFold(f.Player, s.Player) {
+		return false
+	}
+
+	if f.Node != "" && f.Node != s.Node {
+		return false
+	}
+
+	if !f.Until.IsZero() && s.Join.After(f.Until) {
+		return false
+	}
+
+	if f.Since.IsZero() || s.Online {
+		return true
+	}
+
+	if s.Leave == nil {
+		return !s.Join.Before(f.Since)
+	}
+
+	return !s.Leave.Before(f.Since)
+}
+
+// time spent online, up to now for players still online and zero when the leave time isn't known
+func (s Session) Duration() time.Duration {
+	if s.Leave != nil {
+		return s.Leave.Sub(s.Join)
+	}
+
+	if s.Online {
+		return time.Since(s.Join)
+	}
+
+	return 0
+}
+
+func filterNode(sessions []Session, node string) []Session {
+	filtered := []Session{}
+	for _, s := range sessions {
+		if s.Node == node {
+			filtered = append(filtered, s)
+		}
+	}
+
+	return filtered
+}
@@ -0,0 +1,155 @@
+package query
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math/rand"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	typeStat      = 0x00
+	typeHandshake = 0x09
+)
+
+// the full stat, which unlike the server list ping isn't cut short on servers with many players
+type Stat struct {
+	MOTD     string   `json:"motd"`
+	GameType string   `json:"gametype"`
+	GameId   string   `json:"gameid"`
+	Version  string   `json:"version"`
+	Software string   `json:"software"`
+	Plugins  []string `json:"plugins"`
+	Map      string   `json:"map"`
+	Online   int      `json:"online"`
+	Max      int      `json:"max"`
+	HostPort string   `json:"hostport"`
+	HostIP   string   `json:"hostip"`
+	Players  []string `json:"players"`
+}
+
+// padding that starts and ends the key and value section of a full stat
+var (
+	keysStart    = []byte("splitnum\x00\x80\x00")
+	playersStart = []byte("\x01player_\x00\x00")
+)
+
+// asks a server with enable-query for its full stat, over UDP
+func FullStat(address string, timeout time.Duration) (*Stat, error) {
+	conn, err := net.DialTimeout("udp", address, timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	// only the lower 4 bits of each byte of the session id are used
+	session := rand.Int31() & 0x0F0F0F0F
+
+	reply, err := request(conn, typeHandshake, session, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	challenge, err := strconv.ParseInt(string(bytes.TrimRight(reply, "\x00")), 10, 32)
+	if err != nil {
+		return nil, errors.New("invalid challenge token in handshake")
+	}
+
+	// the challenge, then padding that asks for the full stat rather than the basic one
+	payload := make([]byte, 8)
+	binary.BigEndian.PutUint32(payload, uint32(int32(challenge)))
+
+	reply, err = request(conn, typeStat, session, payload)
+	if err != nil {
+		return nil, err
+	}
+
+	return parse(reply)
+}
+
+// sends a request and returns the body of the reply after the type and session id
+func request(conn net.Conn, kind byte, session int32, payload []byte) ([]byte, error) {
+	packet := new(bytes.Buffer)
+	packet.Write([]byte{0xFE, 0xFD, kind})
+	binary.Write(packet, binary.BigEndian, session)
+	packet.Write(payload)
+
+	if _, err := conn.Write(packet.Bytes()); err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, 65535)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+
+		// replies to an earlier attempt are skipped
+		if n >= 5 && buf[0] == kind && int32(binary.BigEndian.Uint32(buf[1:5])) == session {
+			return buf[5:n], nil
+		}
+	}
+}
+
+func parse(data []byte) (*Stat, error) {
+	if !bytes.HasPrefix(data, keysStart) {
+		return nil, errors.New("full stat response cannot be parsed")
+	}
+	data = data[len(keysStart):]
+
+	keys, players, ok := bytes.Cut(data, playersStart)
+	if !ok {
+		return nil, errors.New("full stat response has no player section")
+	}
+
+	values := make(map[string]string)
+	fields := strings.Split(string(keys), "\x00")
+	for i := 0; i+1 < len(fields); i += 2 {
+		if fields[i] == "" {
+			break
+		}
+
+		values[fields[i]] = fields[i+1]
+	}
+
+	stat := &Stat{
+		MOTD:     values["hostname"],
+		GameType: values["gametype"],
+		GameId:   values["game_id"],
+		Version:  values["version"],
+		Map:      values["map"],
+		HostPort: values["hostport"],
+		HostIP:   values["hostip"],
+		Plugins:  []string{},
+		Players:  []string{},
+	}
+
+	stat.Online, _ = strconv.Atoi(values["numplayers"])
+	stat.Max, _ = strconv.Atoi(values["maxplayers"])
+
+	// 'software: plugin version; plugin version', or empty on vanilla
+	if software, plugins, ok := strings.Cut(values["plugins"], ": "); ok {
+		stat.Software = software
+		for _, p := range strings.Split(plugins, "; ") {
+			if p = strings.TrimSpace(p); p != "" {
+				stat.Plugins = append(stat.Plugins, p)
+			}
+		}
+	} else {
+		stat.Software = values["plugins"]
+	}
+
+	for _, name := range strings.Split(string(players), "\x00") {
+		if name != "" {
+			stat.Players = append(stat.Players, name)
+		}
+	}
+
+	return stat, nil
+}
@@ -0,0 +1,163 @@
+package rcon
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	typeResponse = 0
+	typeCommand  = 2
+	typeLogin    = 3
+
+	// not a real request type, the server answers it with an error that marks the end of a reply
+	typeEnd = 100
+)
+
+// the server doesn't accept requests any longer than this
+const maxCommand = 1446
+
+// replies are split into packets of at most 4096 bytes of body
+const maxPacket = 4096 + 10
+
+var ErrAuth = errors.New("rcon password was rejected")
+
+// returned before anything is sent, so the command can still be run another way
+var ErrTooLong = errors.New("command is too long to send over rcon")
+
+// an authenticated connection to a server's rcon port, commands are sent one at a time
+type Client struct {
+	lock    sync.Mutex
+	conn    net.Conn
+	timeout time.Duration
+	id      int32
+}
+
+// connects and logs in to a server
+func Dial(address string, password string, timeout time.Duration) (*Client, error) {
+	conn, err := net.DialTimeout("tcp", address, timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Client{conn: conn, timeout: timeout}
+
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	id, err := c.send(typeLogin, password)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	// a failed login is answered with an id of -1
+	for {
+		replyId, _, err := c.read()
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+
+		if replyId == -1 {
+			conn.Close()
+			return nil, ErrAuth
+		}
+
+		if replyId == id {
+			return c, nil
+		}
+	}
+}
+
+// runs a command, returning the server's reply
+func (c *Client) Command(command string) (string, error) {
+	if len(command) > maxCommand {
+		return "", ErrTooLong
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.conn.SetDeadline(time.Now().Add(c.timeout))
+
+	id, err := c.send(typeCommand, command)
+	if err != nil {
+		return "", err
+	}
+
+	// long replies come in several packets, so follow the command with one that can only be answered after it
+	end, err := c.send(typeEnd, "")
+	if err != nil {
+		return "", err
+	}
+
+	reply := new(strings.Builder)
+	for {
+		replyId, body, err := c.read()
+		if err != nil {
+			return "", err
+		}
+
+		switch replyId {
+		case id:
+			reply.WriteString(body)
+		case end:
+			return reply.String(), nil
+		case -1:
+			return "", ErrAuth
+		}
+	}
+}
+
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+func (c *Client) send(kind int32, body string) (int32, error) {
+	c.id++
+	if c.id < 0 {
+		c.id = 1
+	}
+
+	packet := new(bytes.Buffer)
+	binary.Write(packet, binary.LittleEndian, int32(len(body)+10))
+	binary.Write(packet, binary.LittleEndian, c.id)
+	binary.Write(packet, binary.LittleEndian, kind)
+	packet.WriteString(body)
+	packet.Write([]byte{0, 0})
+
+	_, err := c.conn.Write(packet.Bytes())
+	return c.id, err
+}
+
+func (c *Client) read() (int32, string, error) {
+	var length int32
+	if err := binary.Read(c.conn, binary.LittleEndian, &length); err != nil {
+		return 0, "", err
+	}
+
+	if length < 10 || length > maxPacket {
+		return 0, "", errors.New("invalid rcon packet length")
+	}
+
+	data := make([]byte, length)
+	if _, err := io.ReadFull(c.conn, data); err != nil {
+		return 0, "", err
+	}
+
+	id := int32(binary.LittleEndian.Uint32(data[0:4]))
+	kind := int32(binary.LittleEndian.Uint32(data[4:8]))
+
+	// logins are answered with a command type, anything else should be a response
+	if kind != typeResponse && kind != typeCommand {
+		return 0, "", errors.New("unexpected rcon packet type")
+	}
+
+	return id, string(bytes.TrimRight(data[8:], "\x00")), nil
+}
@@ -0,0 +1,202 @@
+package schedule
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// a five field cron expression: minute, hour, day of month, month and day of week, in local time
+type Cron struct {
+	minute uint64
+	hour   uint64
+	dom    uint64
+	month  uint64
+	dow    uint64
+	// a day field starting with '*' leaves the days to the other one, otherwise either can match
+	anyDom bool
+	anyDow bool
+}
+
+type field struct {
+	name  string
+	min   int
+	max   int
+	names map[string]int
+}
+
+var macros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+var fields = []field{
+	{name: "minute", min: 0, max: 59},
+	{name: "hour", min: 0, max: 23},
+	{name: "day of month", min: 1, max: 31},
+	{name: "month", min: 1, max: 12, names: map[string]int{"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6, "jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12}},
+	{name: "day of week", min: 0, max: 7, names: map[string]int{"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6}},
+}
+
+// parses an expression such as '0 4 * * *', '*/15 9-17 * * mon-fri' or '@daily'
+func ParseCron(expr string) (*Cron, error) {
+	expr = strings.TrimSpace(expr)
+	if macro, ok := macros[strings.ToLower(expr)]; ok {
+		expr = macro
+	}
+
+	parts := strings.Fields(expr)
+	if len(parts) != len(fields) {
+		return nil, errors.New("expected 5 fields: minute, hour, day of month, month and day of week")
+	}
+
+	sets := make([]uint64, len(fields))
+	for i, f := range fields {
+		set, err := parseField(strings.ToLower(parts[i]), f)
+		if err != nil {
+			return nil, errors.New("invalid " + f.name + " '" + parts[i] + "': " + err.Error())
+		}
+		sets[i] = set
+	}
+
+	// sunday is both 0 and 7
+	if sets[4]&(1<<7) != 0 {
+		sets[4] |= 1
+	}
+
+	return &Cron{
+		minute: sets[0],
+		hour:   sets[1],
+		dom:    sets[2],
+		month:  sets[3],
+		dow:    sets[4],
+		anyDom: strings.HasPrefix(parts[2], "*"),
+		anyDow: strings.HasPrefix(parts[4], "*"),
+	}, nil
+}
+
+// a comma separated list of values, ranges and steps as a bit for each value
+func parseField(s string, f field) (uint64, error) {
+	var set uint64
+
+	for _, part := range strings.Split(s, ",") {
+		rng, step, stepped := strings.Cut(part, "/")
+
+		every := 1
+		if stepped {
+			n, err := strconv.Atoi(step)
+			if err != nil || n < 1 {
+				return 0, errors.New("invalid step")
+			}
+			every = n
+		}
+
+		low, high := f.min, f.max
+
+		if rng != "*" {
+			from, to, ranged := strings.Cut(rng, "-")
+
+			var err error
+			if low, err = value(from, f); err != nil {
+				return 0, err
+			}
+
+			high = low
+			if ranged {
+				if high, err = value(to, f); err != nil {
+					return 0, err
+				}
+			} else if stepped {
+				// '5/15' runs from 5 to the end in steps
+				high = f.max
+			}
+
+			if low > high {
+				return 0, errors.New("range goes backwards")
+			}
+		}
+
+		for v := low; v <= high; v += every {
+			set |= 1 << v
+		}
+	}
+
+	return set, nil
+}
+
+func value(s string, f field) (int, error) {
+	if v, ok := f.names[s]; ok {
+		return v, nil
+	}
+
+	v, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, errors.New("'" + s + "' is not a number")
+	}
+
+	if v < f.min || v > f.max {
+		return 0, errors.New(s + " is outside " + strconv.Itoa(f.min) + "-" + strconv.Itoa(f.max))
+	}
+
+	return v, nil
+}
+
+// whether the expression matches the minute a time is in
+func (c *Cron) Matches(t time.Time) bool {
+	return c.minute&(1<<t.Minute()) != 0 && c.hour&(1<<t.Hour()) != 0 && c.month&(1<<int(t.Month())) != 0 && c.day(t)
+}
+
+func (c *Cron) day(t time.Time) bool {
+	dom := c.dom&(1<<t.Day()) != 0
+	dow := c.dow&(1<<int(t.Weekday())) != 0
+
+	switch {
+	case c.anyDom && c.anyDow:
+		return true
+	case c.anyDom:
+		return dow
+	case c.anyDow:
+		return dom
+	}
+
+	return dom || dow
+}
+
+// the first minute after a time the expression matches, or the zero time when it never does, such as on february 30th
+func (c *Cron) Next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+
+	for t.Before(limit) {
+		switch {
+		case c.month&(1<<int(t.Month())) == 0:
+			t = later(t, time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location()))
+		case !c.day(t):
+			t = later(t, time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location()))
+		case c.hour&(1<<t.Hour()) == 0:
+			t = later(t, time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location()))
+		case c.minute&(1<<t.Minute()) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+
+	return time.Time{}
+}
+
+// the time to skip ahead to, or the next hour when that's a time skipped by daylight saving,
+// which time.Date moves back to before the skip
+func later(t time.Time, next time.Time) time.Time {
+	if next.After(t) {
+		return next
+	}
+
+	return t.Add(time.Duration(60-t.Minute()) * time.Minute)
+}
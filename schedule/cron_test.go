@@ -0,0 +1,207 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+)
+
+func date(year int, month time.Month, day, hour, minute int) time.Time {
+	return time.Date(year, month, day, hour, minute, 0, 0, time.UTC)
+}
+
+func TestParseCron(t *testing.T) {
+	valid := []string{
+		"* * * * *",
+		"0 4 * * *",
+		"*/15 9-17 * * mon-fri",
+		"5/15 * * * *",
+		"0 0 1,15 jan-jun 0",
+		"0 0 * * 7",
+		"@daily",
+		"@WEEKLY",
+		"  0 0 * * *  ",
+	}
+
+	for _, expr := range valid {
+		if _, err := ParseCron(expr); err != nil {
+			t.Errorf("'%s' should parse: %s", expr, err)
+		}
+	}
+
+	invalid := []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * 32 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"*/0 * * * *",
+		"*/x * * * *",
+		"10-5 * * * *",
+		"a * * * *",
+		"* * * foo *",
+		"@sometimes",
+	}
+
+	for _, expr := range invalid {
+		if _, err := ParseCron(expr); err == nil {
+			t.Errorf("'%s' should not parse", expr)
+		}
+	}
+}
+
+func TestMatches(t *testing.T) {
+	tests := []struct {
+		expr  string
+		time  time.Time
+		match bool
+	}{
+		{"* * * * *", date(2024, 1, 1, 0, 0), true},
+		{"0 4 * * *", date(2024, 1, 1, 4, 0), true},
+		{"0 4 * * *", date(2024, 1, 1, 4, 1), false},
+		{"0 4 * * *", date(2024, 1, 1, 5, 0), false},
+
+		// a step from a value runs to the end of the field
+		{"5/15 * * * *", date(2024, 1, 1, 0, 5), true},
+		{"5/15 * * * *", date(2024, 1, 1, 0, 20), true},
+		{"5/15 * * * *", date(2024, 1, 1, 0, 50), true},
+		{"5/15 * * * *", date(2024, 1, 1, 0, 0), false},
+		{"5/15 * * * *", date(2024, 1, 1, 0, 15), false},
+		{"*/15 * * * *", date(2024, 1, 1, 0, 45), true},
+		{"*/15 * * * *", date(2024, 1, 1, 0, 46), false},
+		{"10-30/10 * * * *", date(2024, 1, 1, 0, 30), true},
+		{"10-30/10 * * * *", date(2024, 1, 1, 0, 40), false},
+
+		// 2024-01-07 is a sunday
+		{"0 0 * * 0", date(2024, 1, 7, 0, 0), true},
+		{"0 0 * * 7", date(2024, 1, 7, 0, 0), true},
+		{"0 0 * * sun", date(2024, 1, 7, 0, 0), true},
+		{"0 0 * * 7", date(2024, 1, 8, 0, 0), false},
+		{"0 0 * * 5-7", date(2024, 1, 7, 0, 0), true},
+		{"0 0 * * mon-fri", date(2024, 1, 8, 0, 0), true},
+		{"0 0 * * mon-fri", date(2024, 1, 6, 0, 0), false},
+
+		// with both day fields restricted, either one matching is enough
+		{"0 0 13 * fri", date(2024, 9, 13, 0, 0), true},
+		{"0 0 13 * fri", date(2024, 9, 6, 0, 0), true},
+		{"0 0 13 * fri", date(2024, 3, 13, 0, 0), true},
+		{"0 0 13 * fri", date(2024, 3, 14, 0, 0), false},
+
+		// a day field starting with '*' leaves the days to the other
+		{"0 0 */2 * fri", date(2024, 9, 6, 0, 0), true},
+		{"0 0 */2 * fri", date(2024, 9, 7, 0, 0), false},
+		{"0 0 13 * *", date(2024, 9, 14, 0, 0), false},
+
+		{"0 0 1 jan *", date(2024, 1, 1, 0, 0), true},
+		{"0 0 1 jan *", date(2024, 2, 1, 0, 0), false},
+		{"@hourly", date(2024, 5, 5, 13, 0), true},
+		{"@hourly", date(2024, 5, 5, 13, 30), false},
+	}
+
+	for _, test := range tests {
+		c, err := ParseCron(test.expr)
+		if err != nil {
+			t.Fatalf("'%s' should parse: %s", test.expr, err)
+		}
+
+		if got := c.Matches(test.time); got != test.match {
+			t.Errorf("'%s' at %s: matched %t, expected %t", test.expr, test.time.Format(time.RFC3339), got, test.match)
+		}
+	}
+}
+
+func TestNext(t *testing.T) {
+	tests := []struct {
+		expr  string
+		after time.Time
+		next  time.Time
+	}{
+		{"* * * * *", date(2024, 1, 1, 0, 0), date(2024, 1, 1, 0, 1)},
+		{"0 4 * * *", date(2024, 1, 1, 3, 59), date(2024, 1, 1, 4, 0)},
+		{"0 4 * * *", date(2024, 1, 1, 4, 0), date(2024, 1, 2, 4, 0)},
+		{"5/15 * * * *", date(2024, 1, 1, 0, 51), date(2024, 1, 1, 1, 5)},
+
+		// across the ends of months and years
+		{"0 0 * * *", date(2024, 1, 31, 12, 0), date(2024, 2, 1, 0, 0)},
+		{"0 0 * * *", date(2024, 12, 31, 23, 59), date(2025, 1, 1, 0, 0)},
+		{"0 0 31 * *", date(2024, 4, 1, 0, 0), date(2024, 5, 31, 0, 0)},
+		{"0 0 29 2 *", date(2024, 3, 1, 0, 0), date(2028, 2, 29, 0, 0)},
+		{"30 12 1 * *", date(2024, 2, 29, 13, 0), date(2024, 3, 1, 12, 30)},
+
+		// 2024-01-07 is a sunday
+		{"0 9 * * 7", date(2024, 1, 1, 0, 0), date(2024, 1, 7, 9, 0)},
+		{"0 0 13 * fri", date(2024, 9, 1, 0, 0), date(2024, 9, 6, 0, 0)},
+		{"0 0 13 * fri", date(2024, 9, 6, 0, 0), date(2024, 9, 13, 0, 0)},
+
+		// never matches
+		{"0 0 30 2 *", date(2024, 1, 1, 0, 0), time.Time{}},
+		{"0 0 31 4,6,9,11 *", date(2024, 1, 1, 0, 0), time.Time{}},
+	}
+
+	for _, test := range tests {
+		c, err := ParseCron(test.expr)
+		if err != nil {
+			t.Fatalf("'%s' should parse: %s", test.expr, err)
+		}
+
+		if got := c.Next(test.after); !got.Equal(test.next) {
+			t.Errorf("'%s' after %s: got %s, expected %s", test.expr, test.after.Format(time.RFC3339), got.Format(time.RFC3339), test.next.Format(time.RFC3339))
+		}
+	}
+}
+
+func TestNextDST(t *testing.T) {
+	loc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip("no time zone database: " + err.Error())
+	}
+
+	at := func(month time.Month, day, hour, minute int) time.Time {
+		return time.Date(2024, month, day, hour, minute, 0, 0, loc)
+	}
+
+	tests := []struct {
+		expr  string
+		after time.Time
+		next  time.Time
+	}{
+		// clocks go from 02:00 to 03:00 on march 10th, so 02:30 doesn't happen that day
+		{"30 2 * * *", at(3, 10, 0, 0), at(3, 11, 2, 30)},
+		{"0 3 * * *", at(3, 10, 1, 59), at(3, 10, 3, 0)},
+		{"*/30 * * * *", at(3, 10, 1, 30), at(3, 10, 3, 0)},
+		{"0 12 * * *", at(3, 9, 12, 0), at(3, 10, 12, 0)},
+
+		// clocks go from 02:00 back to 01:00 on november 3rd, so 01:30 happens twice
+		{"30 1 * * *", at(11, 3, 0, 0), at(11, 3, 1, 30)},
+		{"0 4 * * *", at(11, 3, 0, 0), at(11, 3, 4, 0)},
+		{"0 12 * * *", at(11, 2, 12, 0), at(11, 3, 12, 0)},
+	}
+
+	for _, test := range tests {
+		c, err := ParseCron(test.expr)
+		if err != nil {
+			t.Fatalf("'%s' should parse: %s", test.expr, err)
+		}
+
+		got := c.Next(test.after)
+		if !got.Equal(test.next) {
+			t.Errorf("'%s' after %s: got %s, expected %s", test.expr, test.after.Format(time.RFC3339), got.Format(time.RFC3339), test.next.Format(time.RFC3339))
+		}
+
+		if !got.IsZero() && !c.Matches(got) {
+			t.Errorf("'%s' after %s: %s doesn't match", test.expr, test.after.Format(time.RFC3339), got.Format(time.RFC3339))
+		}
+	}
+
+	// the repeated hour is in local time, so both 01:30s match
+	c, _ := ParseCron("30 1 * * *")
+	first := c.Next(at(11, 3, 0, 0))
+	second := c.Next(first)
+
+	if second.Sub(first) != time.Hour {
+		t.Errorf("'30 1 * * *' after %s: got %s, expected an hour later", first.Format(time.RFC3339), second.Format(time.RFC3339))
+	}
+}
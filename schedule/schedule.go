@@ -0,0 +1,633 @@
+package schedule
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"lolarobins.ca/overload/audit"
+	"lolarobins.ca/overload/backup"
+	"lolarobins.ca/overload/fetch"
+	"lolarobins.ca/overload/input"
+	"lolarobins.ca/overload/log"
+	"lolarobins.ca/overload/node"
+)
+
+const (
+	ActionStart   = "start"
+	ActionStop    = "stop"
+	ActionRestart = "restart"
+	ActionBackup  = "backup"
+	ActionCommand = "command"
+	ActionFetch   = "fetch"
+	ActionScript  = "script"
+)
+
+const (
+	ResultOk      = "ok"
+	ResultFailed  = "failed"
+	ResultSkipped = "skipped"
+)
+
+type Task struct {
+	Id     string `json:"id"`
+	Cron   string `json:"cron"`
+	Target string `json:"target"` // '*', 'node:<id>' or 'tag:<tag>', a bare id is a node
+	Action string `json:"action"`
+	Args   string `json:"args,omitempty"` // the command to send, the jar to fetch, the script to run and its arguments or 'countdown' to warn players of a restart
+
+	cron    *Cron
+	invalid error // why a task loaded from the file is disabled
+}
+
+// the last time a task ran and how it went
+type Run struct {
+	Started  time.Time `json:"started"`
+	Finished time.Time `json:"finished"`
+	Result   string    `json:"result"`
+	Error    string    `json:"error,omitempty"`
+}
+
+const file = "config/schedule.json"
+const runsFile = "data/schedule.json"
+
+// scripts run by tasks are kept here
+const scriptDir = "scripts"
+
+// how long a script can run before it's killed
+const scriptTimeout = 30 * time.Minute
+
+var lock = new(sync.Mutex)
+var tasks = []*Task{}
+var runs = make(map[string]*Run)
+var running = make(map[string]bool)
+
+// returned by an action that had nothing to do on a node
+var errSkipped = errors.New("skipped")
+
+func Init() error {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		if err := save(); err != nil {
+			return errors.New("fatal: unable to read/write in working directory")
+		}
+	} else if err := json.Unmarshal(data, &tasks); err != nil {
+		return errors.New("fatal: '" + file + "' cannot be parsed")
+	}
+
+	// an invalid task is kept in the file but never run, so it doesn't stop the others
+	for _, t := range tasks {
+		if err := t.validate(); err != nil {
+			t.invalid = err
+			log.Error("Task '" + t.Id + "' in '" + file + "' is disabled: " + err.Error())
+		}
+	}
+
+	if data, err := os.ReadFile(runsFile); err == nil {
+		json.Unmarshal(data, &runs)
+	}
+
+	go loop()
+
+	input.Command{
+		Function: func(s []string) {
+			if len(s) == 1 || strings.ToLower(s[1]) == "list" {
+				list := List()
+
+				log.Info("Showing " + strconv.Itoa(len(list)) + " scheduled tasks:")
+				for _, t := range list {
+					line := t.Id + " > " + t.Cron + ", " + t.Action
+					if t.Args != "" {
+						line += " '" + t.Args + "'"
+					}
+					line += " on " + t.Target
+
+					if t.invalid != nil {
+						line += ", disabled: " + t.invalid.Error()
+					} else if next := t.Next(time.Now()); !next.IsZero() {
+						line += ", next " + next.Format("2006-01-02 15:04")
+					}
+
+					if r := LastRun(t.Id); r != nil {
+						line += ", last " + r.Started.Format("2006-01-02 15:04") + " " + r.Result
+						if r.Error != "" {
+							line += ": " + r.Error
+						}
+					}
+
+					log.Info(line)
+				}
+				return
+			}
+
+			switch strings.ToLower(s[1]) {
+			case "add":
+				// a macro such as '@daily' is one argument, otherwise the expression is five
+				fieldCount := 5
+				if len(s) > 3 && strings.HasPrefix(s[3], "@") {
+					fieldCount = 1
+				}
+
+				if len(s) < 5+fieldCount {
+					log.Error("Invalid arguments")
+					return
+				}
+
+				rest := s[3+fieldCount:]
+				t := &Task{
+					Id:     s[2],
+					Cron:   strings.Join(s[3:3+fieldCount], " "),
+					Target: rest[0],
+					Action: strings.ToLower(rest[1]),
+					Args:   strings.TrimSpace(strings.Join(rest[2:], " ")),
+				}
+
+				if err := Add(t); err != nil {
+					log.Error("Error adding task: " + err.Error())
+					return
+				}
+
+				audit.Record(audit.Entry{Actor: audit.Console, Source: audit.CLI, Action: "schedule.add", Target: t.Id, New: t.Cron + " " + t.Target + " " + t.Action + " " + t.Args})
+				log.Info("Added task " + t.Id + ", next running " + t.Next(time.Now()).Format("2006-01-02 15:04"))
+			case "remove":
+				if len(s) != 3 {
+					log.Error("Invalid arguments")
+					return
+				}
+
+				if err := Remove(s[2]); err != nil {
+					log.Error("Error removing task: " + err.Error())
+					return
+				}
+
+				audit.Record(audit.Entry{Actor: audit.Console, Source: audit.CLI, Action: "schedule.remove", Target: s[2]})
+				log.Info("Removed task " + s[2])
+			case "run-now":
+				if len(s) != 3 {
+					log.Error("Invalid arguments")
+					return
+				}
+
+				t, err := Get(s[2])
+				if err != nil {
+					log.Error("Error running task: " + err.Error())
+					return
+				}
+
+				audit.Record(audit.Entry{Actor: audit.Console, Source: audit.CLI, Action: "schedule.run", Target: t.Id})
+				go Execute(t)
+			default:
+				log.Error("Invalid arguments")
+			}
+		},
+		Command:     "schedule",
+		Args:        " [list/add/remove/run-now] [id] [cron] [target] [action] [args]",
+		Description: "View, add, remove or run tasks run on a cron schedule",
+	}.Register()
+
+	return nil
+}
+
+func (t *Task) validate() error {
+	if t.Id == "" || strings.ContainsAny(t.Id, " /\\") {
+		return errors.New("invalid id")
+	}
+
+	cron, err := ParseCron(t.Cron)
+	if err != nil {
+		return err
+	}
+	t.cron = cron
+
+	if t.Target == "" || t.Target == "node:" || t.Target == "tag:" {
+		return errors.New("invalid target, expected '*', 'node:<id>' or 'tag:<tag>'")
+	}
+
+	switch t.Action {
+	case ActionStart, ActionStop, ActionBackup:
+	case ActionRestart:
+		if t.Args != "" && t.Args != "countdown" {
+			return errors.New("a restart only takes 'countdown' to warn players first")
+		}
+	case ActionCommand:
+		if t.Args == "" {
+			return errors.New("no command to send given")
+		}
+	case ActionFetch:
+		if t.Args != "" {
+			implementation, version, ok := strings.Cut(t.Args, " ")
+			if !ok || !fetch.Supported(implementation) || strings.TrimSpace(version) == "" {
+				return errors.New("expected an implementation and version to fetch, or none to update each node's jar")
+			}
+		}
+	case ActionScript:
+		name := strings.Fields(t.Args)
+		if len(name) == 0 || strings.ContainsAny(name[0], "/\\") || strings.HasPrefix(name[0], ".") {
+			return errors.New("expected the name of a script in '" + scriptDir + "'")
+		}
+	default:
+		return errors.New("unknown action '" + t.Action + "', expected start, stop, restart, backup, command, fetch or script")
+	}
+
+	return nil
+}
+
+// the next time a task runs, or the zero time if it never will
+func (t *Task) Next(after time.Time) time.Time {
+	if t.invalid != nil {
+		return time.Time{}
+	}
+
+	return t.cron.Next(after)
+}
+
+func save() error {
+	data, err := json.MarshalIndent(tasks, "", "    ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(file, data, 0600)
+}
+
+func saveRuns() {
+	data, err := json.MarshalIndent(runs, "", "    ")
+	if err != nil {
+		return
+	}
+
+	if err := os.WriteFile(runsFile, data, 0600); err != nil {
+		log.Error("Writing '" + runsFile + "': " + err.Error())
+	}
+}
+
+// tasks sorted by id
+func List() []*Task {
+	lock.Lock()
+	defer lock.Unlock()
+
+	list := append([]*Task{}, tasks...)
+	sort.Slice(list, func(i, j int) bool { return list[i].Id < list[j].Id })
+
+	return list
+}
+
+func Get(id string) (*Task, error) {
+	lock.Lock()
+	defer lock.Unlock()
+
+	for _, t := range tasks {
+		if t.Id == id {
+			return t, nil
+		}
+	}
+
+	return nil, errors.New("task '" + id + "' does not exist")
+}
+
+// the last run of a task, or nil if it hasn't run
+func LastRun(id string) *Run {
+	lock.Lock()
+	defer lock.Unlock()
+
+	if r, ok := runs[id]; ok {
+		copy := *r
+		return &copy
+	}
+
+	return nil
+}
+
+func Add(t *Task) error {
+	if err := t.validate(); err != nil {
+		return err
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	for _, existing := range tasks {
+		if existing.Id == t.Id {
+			return errors.New("task '" + t.Id + "' already exists")
+		}
+	}
+
+	tasks = append(tasks, t)
+	return save()
+}
+
+func Remove(id string) error {
+	lock.Lock()
+	defer lock.Unlock()
+
+	for i, t := range tasks {
+		if t.Id == id {
+			tasks = append(tasks[:i], tasks[i+1:]...)
+			delete(runs, id)
+			saveRuns()
+			return save()
+		}
+	}
+
+	return errors.New("task '" + id + "' does not exist")
+}
+
+// starts the tasks due at the start of each minute
+func loop() {
+	for {
+		now := time.Now()
+		time.Sleep(now.Truncate(time.Minute).Add(time.Minute).Sub(now))
+
+		minute := time.Now().Truncate(time.Minute)
+
+		for _, t := range List() {
+			if t.invalid == nil && t.cron.Matches(minute) {
+				go Execute(t)
+			}
+		}
+	}
+}
+
+// runs a task on each of its targets at once, recording how it went
+func Execute(t *Task) {
+	if t.invalid != nil {
+		log.Error("Task " + t.Id + " is disabled: " + t.invalid.Error())
+		record(t, &Run{Started: time.Now(), Finished: time.Now(), Result: ResultFailed, Error: "disabled: " + t.invalid.Error()})
+		return
+	}
+
+	lock.Lock()
+	if running[t.Id] {
+		lock.Unlock()
+		log.Info("Skipping task " + t.Id + ", it's still running")
+		record(t, &Run{Started: time.Now(), Finished: time.Now(), Result: ResultSkipped, Error: "still running"})
+		return
+	}
+	running[t.Id] = true
+	lock.Unlock()
+
+	defer func() {
+		lock.Lock()
+		delete(running, t.Id)
+		lock.Unlock()
+	}()
+
+	log.Info("Running task " + t.Id + " (" + t.Action + " on " + t.Target + ")")
+	r := &Run{Started: time.Now(), Result: ResultOk}
+
+	if err := perform(t); err == errSkipped {
+		r.Result = ResultSkipped
+		log.Info("Skipped task " + t.Id)
+	} else if err != nil {
+		r.Result = ResultFailed
+		r.Error = err.Error()
+		log.Error("Task " + t.Id + " failed: " + err.Error())
+	} else {
+		log.Info("Finished task " + t.Id)
+	}
+
+	r.Finished = time.Now()
+	record(t, r)
+}
+
+func record(t *Task, r *Run) {
+	lock.Lock()
+	defer lock.Unlock()
+
+	runs[t.Id] = r
+	saveRuns()
+}
+
+// nodes a target refers to, sorted by id
+func Targets(target string) []*node.Node {
+	nodes := []*node.Node{}
+
+	for _, n := range node.All() {
+		switch {
+		case target == "*":
+		case strings.HasPrefix(target, "tag:"):
+			if !hasTag(n, strings.TrimPrefix(target, "tag:")) {
+				continue
+			}
+		case strings.TrimPrefix(target, "node:") != n.Id:
+			continue
+		}
+
+		nodes = append(nodes, n)
+	}
+
+	return nodes
+}
+
+func hasTag(n *node.Node, tag string) bool {
+	for _, t := range n.Config.Tags {
+		if t == tag {
+			return true
+		}
+	}
+
+	return false
+}
+
+func perform(t *Task) error {
+	nodes := Targets(t.Target)
+	if len(nodes) == 0 {
+		return errors.New("no nodes match '" + t.Target + "'")
+	}
+
+	if t.Action == ActionFetch {
+		return fetchJars(t, nodes)
+	}
+
+	errs := make([]error, len(nodes))
+	wait := new(sync.WaitGroup)
+
+	for i, n := range nodes {
+		wait.Add(1)
+
+		go func(i int, n *node.Node) {
+			defer wait.Done()
+			errs[i] = act(t, n)
+		}(i, n)
+	}
+
+	wait.Wait()
+
+	skipped := 0
+	failed := []string{}
+	for i, err := range errs {
+		if err == errSkipped {
+			skipped++
+		} else if err != nil {
+			failed = append(failed, nodes[i].Id+": "+err.Error())
+		}
+	}
+
+	if len(failed) > 0 {
+		return errors.New(strings.Join(failed, "; "))
+	}
+
+	if skipped == len(nodes) {
+		return errSkipped
+	}
+
+	return nil
+}
+
+// runs a task's action on one node
+func act(t *Task, n *node.Node) error {
+	entry := audit.Entry{Actor: t.Id, Source: audit.Scheduler, Target: n.Id}
+
+	switch t.Action {
+	case ActionStart:
+		if n.IsRunning() {
+			return errSkipped
+		}
+
+		entry.Action = "node.start"
+		audit.Record(entry)
+		return n.Start()
+	case ActionStop:
+		if !n.IsRunning() {
+			return errSkipped
+		}
+
+		entry.Action = "node.stop"
+		audit.Record(entry)
+		return n.Stop(node.StopTimeout)
+	case ActionRestart:
+		if t.Args == "countdown" {
+			// a node that's been stopped is left stopped rather than started
+			if !n.IsRunning() {
+				return errSkipped
+			}
+
+			entry.Action = "node.countdown"
+			audit.Record(entry)
+
+			err := n.CountdownRestart()
+			if err == node.ErrRestartSkipped || err == node.ErrRestartCancelled {
+				return errSkipped
+			}
+			return err
+		}
+
+		entry.Action = "node.restart"
+		audit.Record(entry)
+		return n.Restart()
+	case ActionBackup:
+		entry.Action = "node.backup"
+		audit.Record(entry)
+		_, err := backup.Backup(n)
+		return err
+	case ActionCommand:
+		if !n.IsRunning() {
+			return errSkipped
+		}
+
+		entry.Action = "node.command"
+		entry.New = t.Args
+		audit.Record(entry)
+		_, _, err := n.Command(t.Args)
+		return err
+	case ActionScript:
+		entry.Action = "schedule.script"
+		entry.New = t.Args
+		audit.Record(entry)
+		return script(t, n)
+	}
+
+	return errors.New("unknown action '" + t.Action + "'")
+}
+
+// fetches the implementation and version given, or the newest build of each node's jar when it was fetched by overload
+func fetchJars(t *Task, nodes []*node.Node) error {
+	jars := []string{}
+
+	if t.Args != "" {
+		jars = append(jars, strings.Join(strings.Fields(t.Args), "-"))
+	} else {
+		seen := make(map[string]bool)
+		for _, n := range nodes {
+			if !seen[n.Config.Jar] {
+				seen[n.Config.Jar] = true
+				jars = append(jars, strings.TrimSuffix(n.Config.Jar, ".jar"))
+			}
+		}
+	}
+
+	failed := []string{}
+	for _, jar := range jars {
+		implementation, version, ok := strings.Cut(jar, "-")
+		if !ok || !fetch.Supported(implementation) {
+			failed = append(failed, "'"+jar+".jar' was not fetched by overload")
+			continue
+		}
+
+		audit.Record(audit.Entry{Actor: t.Id, Source: audit.Scheduler, Action: "fetch", Target: strings.ToLower(implementation), New: version})
+
+		if err := fetch.Fetch(implementation, version); err != nil {
+			failed = append(failed, implementation+" "+version+": "+err.Error())
+		}
+	}
+
+	if len(failed) > 0 {
+		return errors.New(strings.Join(failed, "; "))
+	}
+
+	return nil
+}
+
+// runs a script from the scripts directory in a node's directory, logging what it prints
+func script(t *Task, n *node.Node) error {
+	args := strings.Fields(t.Args)
+
+	path, err := filepath.Abs(filepath.Join(scriptDir, args[0]))
+	if err != nil {
+		return err
+	}
+
+	if _, err := os.Stat(path); err != nil {
+		return errors.New("script '" + args[0] + "' is not in '" + scriptDir + "'")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), scriptTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, path, args[1:]...)
+	cmd.Dir = "nodes/" + n.Id
+	cmd.Env = append(os.Environ(), "OVERLOAD_NODE="+n.Id, "OVERLOAD_TASK="+t.Id, "OVERLOAD_STATE="+n.State())
+
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	cmd.Stderr = cmd.Stdout
+
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	scanner := bufio.NewScanner(out)
+	for scanner.Scan() {
+		log.Info(t.Id + " (" + n.Id + ") > " + scanner.Text())
+	}
+
+	if err := cmd.Wait(); err != nil {
+		if ctx.Err() != nil {
+			return errors.New("script timed out")
+		}
+		return errors.New("script " + err.Error())
+	}
+
+	return nil
+}
@@ -12,17 +12,30 @@ import (
 )
 
 type ServerSettings struct {
-	UPnP             bool   `json:"upnp"`
-	Hostname         string `json:"hostname"`
-	PanelPort        string `json:"panelport"`
-	PanelPortForward bool   `json:"panelportforward"`
-	Router           string `json:"router"`
+	UPnP              bool   `json:"upnp"`
+	Hostname          string `json:"hostname"`
+	PanelPort         string `json:"panelport"`
+	PanelPortForward  bool   `json:"panelportforward"`
+	Router            string `json:"router"`
+	TLS               bool   `json:"tls"`
+	TLSCert           string `json:"tlscert"` // self-signed when empty
+	TLSKey            string `json:"tlskey"`
+	HTTPRedirect      bool   `json:"httpredirect"`
+	HTTPRedirectPort  string `json:"httpredirectport"`
+	PanelDir          string `json:"paneldir"`          // serves the panel from disk instead of the binary
+	HistoryRawHours   int    `json:"historyrawhours"`   // how long node samples are kept at full resolution
+	HistoryMinuteDays int    `json:"historyminutedays"` // and averaged to each minute
+	HistoryHourDays   int    `json:"historyhourdays"`   // and averaged to each hour
 }
 
 var Settings = ServerSettings{
-	Hostname:         getOutboundIP().String(),
-	PanelPort:        "8080",
-	PanelPortForward: true,
+	Hostname:          getOutboundIP().String(),
+	PanelPort:         "8080",
+	PanelPortForward:  true,
+	HTTPRedirectPort:  "8081",
+	HistoryRawHours:   24,
+	HistoryMinuteDays: 30,
+	HistoryHourDays:   365,
 }
 
 // https://stackoverflow.com/questions/23558425/how-do-i-get-the-local-ip-address-in-go
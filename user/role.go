@@ -0,0 +1,281 @@
+package user
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"sort"
+	"strings"
+
+	"lolarobins.ca/overload/audit"
+	"lolarobins.ca/overload/input"
+	"lolarobins.ca/overload/log"
+)
+
+const (
+	PermView    = "view" // implied by any other permission on a node
+	PermConsole = "console"
+	PermCommand = "command"
+	PermPower   = "power"
+	PermConfig  = "config"
+	PermFiles   = "files"
+	PermAdmin   = "admin" // implies every permission in its scope
+)
+
+var Permissions = []string{PermConsole, PermCommand, PermPower, PermConfig, PermFiles, PermAdmin}
+
+type Role struct {
+	Name        string   `json:"name"`
+	Permissions []string `json:"permissions"`
+}
+
+// a role given to a user within a scope: '*', 'node:<id>' or 'tag:<tag>'
+type Grant struct {
+	Role  string `json:"role"`
+	Scope string `json:"scope"`
+}
+
+var Roles = map[string]*Role{
+	"admin":    {Name: "admin", Permissions: []string{PermAdmin}},
+	"operator": {Name: "operator", Permissions: []string{PermConsole, PermCommand, PermPower, PermConfig, PermFiles}},
+	"viewer":   {Name: "viewer", Permissions: []string{PermConsole}},
+}
+
+func initRoles() error {
+	data, err := os.ReadFile("config/roles.json")
+	if err != nil {
+		if err := SaveRoles(); err != nil {
+			return errors.New("fatal: unable to read/write in working directory")
+		}
+	} else {
+		list := []*Role{}
+		if err := json.Unmarshal(data, &list); err != nil {
+			return errors.New("fatal: 'config/roles.json' cannot be parsed")
+		}
+
+		Roles = make(map[string]*Role)
+		for _, r := range list {
+			Roles[strings.ToLower(r.Name)] = r
+		}
+	}
+
+	input.Command{
+		Function: func(s []string) {
+			if len(s) == 1 {
+				log.Info("Showing roles:")
+
+				for _, r := range ListRoles() {
+					log.Info(r.Name + " > " + strings.Join(r.Permissions, ", "))
+				}
+				return
+			}
+
+			switch strings.ToLower(s[1]) {
+			case "add":
+				if len(s) != 4 {
+					log.Error("Invalid arguments")
+					return
+				}
+
+				if err := CreateRole(s[2], strings.Split(s[3], ",")); err != nil {
+					log.Error("Error creating role: " + err.Error())
+					return
+				}
+
+				audit.Record(audit.Entry{Actor: audit.Console, Source: audit.CLI, Action: "role.add", Target: s[2], New: s[3]})
+				log.Info("Created role " + s[2])
+			case "remove":
+				if len(s) != 3 {
+					log.Error("Invalid arguments")
+					return
+				}
+
+				if err := DeleteRole(s[2]); err != nil {
+					log.Error("Error removing role: " + err.Error())
+					return
+				}
+
+				audit.Record(audit.Entry{Actor: audit.Console, Source: audit.CLI, Action: "role.remove", Target: s[2]})
+				log.Info("Removed role " + s[2])
+			default:
+				log.Error("Invalid arguments")
+			}
+		},
+		Command:     "roles",
+		Args:        " [add/remove] [name] [permission,permission]",
+		Description: "Manage roles, permissions are: " + strings.Join(Permissions, ", "),
+	}.Register()
+
+	return nil
+}
+
+func SaveRoles() error {
+	data, err := json.MarshalIndent(ListRoles(), "", "    ")
+	if err != nil {
+		return errors.New("error marshalling JSON to output to file")
+	}
+
+	if err := os.WriteFile("config/roles.json", data, 0600); err != nil {
+		return errors.New("could not write to file 'config/roles.json'")
+	}
+
+	return nil
+}
+
+func ListRoles() []*Role {
+	lock.RLock()
+	defer lock.RUnlock()
+
+	list := make([]*Role, 0, len(Roles))
+	for _, r := range Roles {
+		list = append(list, r)
+	}
+
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+
+	return list
+}
+
+func GetRole(name string) (*Role, error) {
+	lock.RLock()
+	defer lock.RUnlock()
+
+	r, ok := Roles[strings.ToLower(name)]
+	if !ok {
+		return nil, errors.New("role '" + name + "' does not exist")
+	}
+
+	return r, nil
+}
+
+func CreateRole(name string, perms []string) error {
+	if r, _ := GetRole(name); r != nil {
+		return errors.New("role '" + name + "' already exists")
+	}
+
+	for _, p := range perms {
+		if !validPermission(p) {
+			return errors.New("permission '" + p + "' does not exist")
+		}
+	}
+
+	lock.Lock()
+	Roles[strings.ToLower(name)] = &Role{Name: name, Permissions: perms}
+	lock.Unlock()
+
+	return SaveRoles()
+}
+
+func DeleteRole(name string) error {
+	if _, err := GetRole(name); err != nil {
+		return err
+	}
+
+	lock.Lock()
+	delete(Roles, strings.ToLower(name))
+	lock.Unlock()
+
+	return SaveRoles()
+}
+
+func validPermission(perm string) bool {
+	for _, p := range Permissions {
+		if p == perm {
+			return true
+		}
+	}
+
+	return false
+}
+
+func validScope(scope string) bool {
+	return scope == "*" || (strings.HasPrefix(scope, "node:") && len(scope) > 5) || (strings.HasPrefix(scope, "tag:") && len(scope) > 4)
+}
+
+// whether the grant's scope covers a node, an empty id only matches the global scope
+func (g Grant) covers(id string, tags []string) bool {
+	if g.Scope == "*" {
+		return true
+	}
+
+	if id == "" {
+		return false
+	}
+
+	if g.Scope == "node:"+id {
+		return true
+	}
+
+	for _, t := range tags {
+		if g.Scope == "tag:"+t {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (r *Role) has(perm string) bool {
+	for _, p := range r.Permissions {
+		if p == perm || p == PermAdmin || perm == PermView {
+			return true
+		}
+	}
+
+	return false
+}
+
+// checks a permission on a node given its id and tags, or globally when id is empty
+func (u *User) Can(perm string, id string, tags []string) bool {
+	lock.RLock()
+	defer lock.RUnlock()
+
+	for _, g := range u.Grants {
+		if !g.covers(id, tags) {
+			continue
+		}
+
+		if r, ok := Roles[strings.ToLower(g.Role)]; ok && r.has(perm) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (u *User) Grant(role string, scope string) error {
+	if _, err := GetRole(role); err != nil {
+		return err
+	}
+
+	if !validScope(scope) {
+		return errors.New("invalid scope, expected '*', 'node:<id>' or 'tag:<tag>'")
+	}
+
+	lock.Lock()
+	for _, g := range u.Grants {
+		if strings.EqualFold(g.Role, role) && g.Scope == scope {
+			lock.Unlock()
+			return errors.New("role already granted in this scope")
+		}
+	}
+
+	u.Grants = append(u.Grants, Grant{Role: role, Scope: scope})
+	lock.Unlock()
+
+	return Save()
+}
+
+func (u *User) Ungrant(role string, scope string) error {
+	lock.Lock()
+	for i, g := range u.Grants {
+		if strings.EqualFold(g.Role, role) && g.Scope == scope {
+			u.Grants = append(u.Grants[:i], u.Grants[i+1:]...)
+			lock.Unlock()
+			return Save()
+		}
+	}
+	lock.Unlock()
+
+	return errors.New("role not granted in this scope")
+}
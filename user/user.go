@@ -0,0 +1,430 @@
+package user
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+	"lolarobins.ca/overload/audit"
+	"lolarobins.ca/overload/input"
+	"lolarobins.ca/overload/log"
+)
+
+type Token struct {
+	Name    string    `json:"name"`
+	Hash    string    `json:"hash"`
+	Created time.Time `json:"created"`
+}
+
+type User struct {
+	Name     string  `json:"name"`
+	Password string  `json:"password"`
+	Tokens   []Token `json:"tokens"`
+	Grants   []Grant `json:"grants"`
+}
+
+var Users = make(map[string]*User)
+var lock = new(sync.RWMutex)
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("overload"), bcrypt.DefaultCost)
+
+func Init() error {
+	if err := initRoles(); err != nil {
+		return err
+	}
+
+	data, err := os.ReadFile("config/users.json")
+	if err != nil {
+		if err := Save(); err != nil {
+			return errors.New("fatal: unable to read/write in working directory")
+		}
+	} else {
+		list := []*User{}
+		if err := json.Unmarshal(data, &list); err != nil {
+			return errors.New("fatal: 'config/users.json' cannot be parsed")
+		}
+
+		for _, u := range list {
+			// users from before roles existed had full access
+			if u.Grants == nil {
+				u.Grants = []Grant{{Role: "admin", Scope: "*"}}
+			}
+
+			Users[strings.ToLower(u.Name)] = u
+		}
+	}
+
+	if len(Users) == 0 {
+		log.Info("No users exist, the web panel and API will be inaccessible until one is created with 'users add'")
+	}
+
+	input.Command{
+		Function: func(s []string) {
+			if len(s) == 1 {
+				log.Info("Showing users:")
+
+				for _, u := range List() {
+					grants := []string{}
+					for _, g := range u.Grants {
+						grants = append(grants, g.Role+" ("+g.Scope+")")
+					}
+
+					log.Info(u.Name + " > Tokens: " + strconv.Itoa(len(u.Tokens)) + ", Roles: " + strings.Join(grants, ", "))
+				}
+				return
+			}
+
+			switch strings.ToLower(s[1]) {
+			case "add":
+				if len(s) != 4 {
+					log.Error("Invalid arguments")
+					return
+				}
+
+				if _, err := Create(s[2], s[3]); err != nil {
+					log.Error("Error creating user: " + err.Error())
+					return
+				}
+
+				audit.Record(audit.Entry{Actor: audit.Console, Source: audit.CLI, Action: "user.add", Target: s[2]})
+				log.Info("Created user " + s[2])
+			case "remove":
+				if len(s) != 3 {
+					log.Error("Invalid arguments")
+					return
+				}
+
+				if err := Delete(s[2]); err != nil {
+					log.Error("Error removing user: " + err.Error())
+					return
+				}
+
+				audit.Record(audit.Entry{Actor: audit.Console, Source: audit.CLI, Action: "user.remove", Target: s[2]})
+				log.Info("Removed user " + s[2])
+			case "passwd":
+				if len(s) != 4 {
+					log.Error("Invalid arguments")
+					return
+				}
+
+				u, err := Get(s[2])
+				if err != nil {
+					log.Error("Error changing password: " + err.Error())
+					return
+				}
+
+				if err := u.SetPassword(s[3]); err != nil {
+					log.Error("Error changing password: " + err.Error())
+					return
+				}
+
+				audit.Record(audit.Entry{Actor: audit.Console, Source: audit.CLI, Action: "user.password", Target: u.Name})
+				log.Info("Changed password for " + u.Name)
+			case "token":
+				if len(s) != 4 {
+					log.Error("Invalid arguments")
+					return
+				}
+
+				u, err := Get(s[2])
+				if err != nil {
+					log.Error("Error creating token: " + err.Error())
+					return
+				}
+
+				token, err := u.NewToken(s[3])
+				if err != nil {
+					log.Error("Error creating token: " + err.Error())
+					return
+				}
+
+				audit.Record(audit.Entry{Actor: audit.Console, Source: audit.CLI, Action: "user.token", Target: u.Name, New: s[3]})
+				log.Info("Created token '" + s[3] + "' for " + u.Name + ", it will not be shown again: " + token)
+			case "revoke":
+				if len(s) != 4 {
+					log.Error("Invalid arguments")
+					return
+				}
+
+				u, err := Get(s[2])
+				if err != nil {
+					log.Error("Error revoking token: " + err.Error())
+					return
+				}
+
+				if err := u.RevokeToken(s[3]); err != nil {
+					log.Error("Error revoking token: " + err.Error())
+					return
+				}
+
+				audit.Record(audit.Entry{Actor: audit.Console, Source: audit.CLI, Action: "user.revoke", Target: u.Name, Old: s[3]})
+				log.Info("Revoked token '" + s[3] + "' for " + u.Name)
+			case "grant", "ungrant":
+				if len(s) != 5 {
+					log.Error("Invalid arguments")
+					return
+				}
+
+				u, err := Get(s[2])
+				if err != nil {
+					log.Error("Error changing roles: " + err.Error())
+					return
+				}
+
+				if strings.ToLower(s[1]) == "grant" {
+					err = u.Grant(s[3], s[4])
+				} else {
+					err = u.Ungrant(s[3], s[4])
+				}
+
+				if err != nil {
+					log.Error("Error changing roles: " + err.Error())
+					return
+				}
+
+				if strings.ToLower(s[1]) == "grant" {
+					audit.Record(audit.Entry{Actor: audit.Console, Source: audit.CLI, Action: "user.grant", Target: u.Name, New: s[3] + " " + s[4]})
+				} else {
+					audit.Record(audit.Entry{Actor: audit.Console, Source: audit.CLI, Action: "user.ungrant", Target: u.Name, Old: s[3] + " " + s[4]})
+				}
+
+				log.Info("Changed roles for " + u.Name)
+			case "tokens":
+				if len(s) != 3 {
+					log.Error("Invalid arguments")
+					return
+				}
+
+				u, err := Get(s[2])
+				if err != nil {
+					log.Error("Error listing tokens: " + err.Error())
+					return
+				}
+
+				log.Info("Showing tokens for " + u.Name + ":")
+				for _, t := range u.Tokens {
+					log.Info(t.Name + " > Created: " + t.Created.Format("2006-01-02 15:04:05"))
+				}
+			default:
+				log.Error("Invalid arguments")
+			}
+		},
+		Command:     "users",
+		Args:        " [add/remove/passwd/token/revoke/tokens/grant/ungrant] [name] [password/token name/role] [scope]",
+		Description: "Manage web panel and API users and their tokens",
+	}.Register()
+
+	return nil
+}
+
+func Save() error {
+	lock.RLock()
+	defer lock.RUnlock()
+
+	list := make([]*User, 0, len(Users))
+	for _, u := range Users {
+		list = append(list, u)
+	}
+
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+
+	data, err := json.MarshalIndent(list, "", "    ")
+	if err != nil {
+		return errors.New("error marshalling JSON to output to file")
+	}
+
+	if err := os.WriteFile("config/users.json", data, 0600); err != nil {
+		return errors.New("could not write to file 'config/users.json'")
+	}
+
+	return nil
+}
+
+func List() []*User {
+	lock.RLock()
+	defer lock.RUnlock()
+
+	list := make([]*User, 0, len(Users))
+	for _, u := range Users {
+		list = append(list, u)
+	}
+
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+
+	return list
+}
+
+func Get(name string) (*User, error) {
+	lock.RLock()
+	defer lock.RUnlock()
+
+	u, ok := Users[strings.ToLower(name)]
+	if !ok {
+		return nil, errors.New("user '" + name + "' does not exist")
+	}
+
+	return u, nil
+}
+
+func Create(name string, password string) (*User, error) {
+	if name == "" || strings.ContainsAny(name, " /\\") {
+		return nil, errors.New("invalid user name")
+	}
+
+	if u, _ := Get(name); u != nil {
+		return nil, errors.New("user '" + name + "' already exists")
+	}
+
+	u := &User{Name: name, Tokens: []Token{}, Grants: []Grant{}}
+	if err := u.setPassword(password); err != nil {
+		return nil, err
+	}
+
+	lock.Lock()
+	// the first user administers everything, otherwise nobody could
+	if len(Users) == 0 {
+		u.Grants = append(u.Grants, Grant{Role: "admin", Scope: "*"})
+		log.Info("Granted admin to " + name + " as the first user")
+	}
+
+	Users[strings.ToLower(name)] = u
+	lock.Unlock()
+
+	return u, Save()
+}
+
+func Delete(name string) error {
+	if _, err := Get(name); err != nil {
+		return err
+	}
+
+	lock.Lock()
+	delete(Users, strings.ToLower(name))
+	lock.Unlock()
+
+	return Save()
+}
+
+// checks a name and password pair, returning the user if they match
+func Authenticate(name string, password string) (*User, error) {
+	u, err := Get(name)
+	if err != nil {
+		// compare anyway so a missing user takes as long as a wrong password
+		bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+		return nil, errors.New("invalid user name or password")
+	}
+
+	lock.RLock()
+	hash := u.Password
+	lock.RUnlock()
+
+	if bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) != nil {
+		return nil, errors.New("invalid user name or password")
+	}
+
+	return u, nil
+}
+
+// finds the user owning an api token
+func FromToken(token string) (*User, error) {
+	hash := hashToken(token)
+
+	lock.RLock()
+	defer lock.RUnlock()
+
+	for _, u := range Users {
+		for _, t := range u.Tokens {
+			if subtle.ConstantTimeCompare([]byte(t.Hash), []byte(hash)) == 1 {
+				return u, nil
+			}
+		}
+	}
+
+	return nil, errors.New("invalid token")
+}
+
+func (u *User) SetPassword(password string) error {
+	if err := u.setPassword(password); err != nil {
+		return err
+	}
+
+	return Save()
+}
+
+func (u *User) setPassword(password string) error {
+	if len(password) < 8 {
+		return errors.New("password must be at least 8 characters")
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+
+	lock.Lock()
+	u.Password = string(hash)
+	lock.Unlock()
+
+	return nil
+}
+
+// generates a new api token, only the hash is kept so the token is returned once
+func (u *User) NewToken(name string) (string, error) {
+	lock.RLock()
+	for _, t := range u.Tokens {
+		if t.Name == name {
+			lock.RUnlock()
+			return "", errors.New("token '" + name + "' already exists")
+		}
+	}
+	lock.RUnlock()
+
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+
+	token := "ovl_" + hex.EncodeToString(buf)
+
+	lock.Lock()
+	u.Tokens = append(u.Tokens, Token{Name: name, Hash: hashToken(token), Created: time.Now().UTC()})
+	lock.Unlock()
+
+	return token, Save()
+}
+
+func (u *User) RevokeToken(name string) error {
+	lock.Lock()
+	for i, t := range u.Tokens {
+		if t.Name == name {
+			u.Tokens = append(u.Tokens[:i], u.Tokens[i+1:]...)
+			lock.Unlock()
+			return Save()
+		}
+	}
+	lock.Unlock()
+
+	return errors.New("token '" + name + "' does not exist")
+}
+
+// password hash, used to invalidate sessions when the password changes
+func (u *User) PasswordHash() string {
+	lock.RLock()
+	defer lock.RUnlock()
+
+	return u.Password
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
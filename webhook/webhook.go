@@ -0,0 +1,414 @@
+package webhook
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"lolarobins.ca/overload/event"
+	"lolarobins.ca/overload/input"
+	"lolarobins.ca/overload/log"
+)
+
+const (
+	FormatJSON    = "json"
+	FormatDiscord = "discord"
+	FormatSlack   = "slack"
+)
+
+type Hook struct {
+	Name      string   `json:"name"`
+	URL       string   `json:"url"`
+	Format    string   `json:"format"`    // json, discord or slack
+	Events    []string `json:"events"`    // event types, a trailing '*' matches by prefix
+	Nodes     []string `json:"nodes"`     // every node when empty
+	RateLimit int      `json:"ratelimit"` // messages a minute, more than this are dropped
+
+	queue chan event.Event
+	sent  []time.Time
+	lock  sync.Mutex
+}
+
+const file = "config/webhooks.json"
+
+// type of the event sent by 'webhooks test', never published
+const testEvent = "webhook.test"
+
+const (
+	attempts       = 5
+	firstBackoff   = 2 * time.Second
+	maxBackoff     = time.Minute
+	queueSize      = 100
+	defaultRate    = 30
+	requestTimeout = 10 * time.Second
+)
+
+var Hooks = []*Hook{}
+var client = &http.Client{Timeout: requestTimeout}
+
+func Init() error {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		example := []*Hook{}
+		data, _ := json.MarshalIndent(example, "", "    ")
+
+		if err := os.WriteFile(file, data, 0600); err != nil {
+			return errors.New("fatal: unable to read/write in working directory")
+		}
+	} else if err := json.Unmarshal(data, &Hooks); err != nil {
+		return errors.New("fatal: '" + file + "' cannot be parsed")
+	}
+
+	// an invalid webhook is left out rather than stopping the others from being sent
+	valid := []*Hook{}
+	for _, h := range Hooks {
+		if err := h.validate(); err != nil {
+			log.Error("Webhook '" + h.Name + "' in '" + file + "' is disabled: " + err.Error())
+			continue
+		}
+
+		h.queue = make(chan event.Event, queueSize)
+		go h.deliver()
+
+		valid = append(valid, h)
+	}
+	Hooks = valid
+
+	event.Listen(dispatch)
+
+	input.Command{
+		Function: func(s []string) {
+			if len(s) == 1 {
+				log.Info("Showing webhooks defined in " + file + ":")
+
+				for _, h := range Hooks {
+					nodes := "all nodes"
+					if len(h.Nodes) > 0 {
+						nodes = strings.Join(h.Nodes, ", ")
+					}
+
+					log.Info(h.Name + " > Format: " + h.Format + ", Events: " + strings.Join(h.Events, ", ") + ", Nodes: " + nodes + ", Limit: " + strconv.Itoa(h.rate()) + "/min")
+				}
+				return
+			}
+
+			if strings.ToLower(s[1]) != "test" || len(s) != 3 {
+				log.Error("Invalid arguments")
+				return
+			}
+
+			h, err := Get(s[2])
+			if err != nil {
+				log.Error("Error testing webhook: " + err.Error())
+				return
+			}
+
+			log.Info("Sending test message to " + h.Name)
+
+			go func() {
+				e := event.Event{Type: testEvent, Time: time.Now().UTC(), Data: map[string]interface{}{"webhook": h.Name}}
+				if err := h.send(e); err != nil {
+					log.Error("Error testing webhook " + h.Name + ": " + err.Error())
+					return
+				}
+
+				log.Info("Test message delivered to " + h.Name)
+			}()
+		},
+		Command:     "webhooks",
+		Args:        " [test] [name]",
+		Description: "View the webhooks in " + file + ", or send a test message to one",
+	}.Register()
+
+	return nil
+}
+
+func Get(name string) (*Hook, error) {
+	for _, h := range Hooks {
+		if strings.EqualFold(h.Name, name) {
+			return h, nil
+		}
+	}
+
+	return nil, errors.New("webhook '" + name + "' does not exist")
+}
+
+func (h *Hook) validate() error {
+	if h.Name == "" {
+		return errors.New("missing name")
+	}
+
+	if !strings.HasPrefix(h.URL, "https://") && !strings.HasPrefix(h.URL, "http://") {
+		return errors.New("url must be http or https")
+	}
+
+	switch h.Format {
+	case "":
+		h.Format = FormatJSON
+	case FormatJSON, FormatDiscord, FormatSlack:
+	default:
+		return errors.New("unknown format '" + h.Format + "'")
+	}
+
+	return nil
+}
+
+func (h *Hook) rate() int {
+	if h.RateLimit <= 0 {
+		return defaultRate
+	}
+
+	return h.RateLimit
+}
+
+func (h *Hook) wants(e event.Event) bool {
+	if len(h.Nodes) > 0 && !contains(h.Nodes, e.Node) {
+		return false
+	}
+
+	for _, filter := range h.Events {
+		if event.Matches(filter, e.Type) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func dispatch(e event.Event) {
+	for _, h := range Hooks {
+		if !h.wants(e) {
+			continue
+		}
+
+		select {
+		case h.queue <- e:
+		default:
+			log.Error("Webhook " + h.Name + " is too far behind, dropping " + e.Type + " event")
+		}
+	}
+}
+
+// sends queued events one at a time so they arrive in order, and a slow hook doesn't hold up the rest
+func (h *Hook) deliver() {
+	for e := range h.queue {
+		if !h.allow() {
+			log.Error("Webhook " + h.Name + " is over its limit of " + strconv.Itoa(h.rate()) + " messages a minute, dropping " + e.Type + " event")
+			continue
+		}
+
+		if err := h.send(e); err != nil {
+			log.Error("Delivering " + e.Type + " event to webhook " + h.Name + ": " + err.Error())
+		}
+	}
+}
+
+// whether another message fits within the last minute's limit
+func (h *Hook) allow() bool {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	cutoff := time.Now().Add(-time.Minute)
+	for len(h.sent) > 0 && h.sent[0].Before(cutoff) {
+		h.sent = h.sent[1:]
+	}
+
+	if len(h.sent) >= h.rate() {
+		return false
+	}
+
+	h.sent = append(h.sent, time.Now())
+	return true
+}
+
+// posts an event, retrying with backoff when the request fails or the server asks to slow down
+func (h *Hook) send(e event.Event) error {
+	body, err := json.Marshal(h.payload(e))
+	if err != nil {
+		return err
+	}
+
+	backoff := firstBackoff
+
+	for attempt := 1; ; attempt++ {
+		retryAfter, err := h.post(body)
+		if err == nil {
+			return nil
+		}
+
+		if attempt == attempts || retryAfter < 0 {
+			return err
+		}
+
+		if retryAfter > 0 {
+			backoff = retryAfter
+		}
+
+		time.Sleep(backoff)
+
+		backoff *= 2
+		if backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+// makes one request, returning how long to wait before retrying or a negative duration if it shouldn't be
+func (h *Hook) post(body []byte) (time.Duration, error) {
+	resp, err := client.Post(h.URL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+
+	if resp.StatusCode < 300 {
+		return 0, nil
+	}
+
+	err = errors.New("server responded with " + resp.Status)
+
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests:
+		if seconds, perr := strconv.ParseFloat(resp.Header.Get("Retry-After"), 64); perr == nil && seconds > 0 {
+			return time.Duration(seconds * float64(time.Second)), err
+		}
+		return 0, err
+	case resp.StatusCode >= 500:
+		return 0, err
+	}
+
+	// anything else won't get better by trying again
+	return -1, err
+}
+
+func (h *Hook) payload(e event.Event) interface{} {
+	switch h.Format {
+	case FormatDiscord:
+		return map[string]interface{}{
+			"username": "overload",
+			"embeds": []map[string]interface{}{{
+				"title":       e.Type,
+				"description": Describe(e),
+				"color":       colour(e.Type),
+				"timestamp":   e.Time.Format(time.RFC3339),
+			}},
+		}
+	case FormatSlack:
+		return map[string]interface{}{"text": Describe(e)}
+	}
+
+	return map[string]interface{}{
+		"type":    e.Type,
+		"node":    e.Node,
+		"time":    e.Time,
+		"data":    e.Data,
+		"message": Describe(e),
+	}
+}
+
+// a readable message for an event
+func Describe(e event.Event) string {
+	node := "Node " + e.Node
+	str := func(key string) string {
+		if s, ok := e.Data[key].(string); ok {
+			return s
+		}
+
+		data, _ := json.Marshal(e.Data[key])
+		return string(data)
+	}
+
+	switch e.Type {
+	case testEvent:
+		return "Test message from overload for webhook " + str("webhook")
+	case event.NodeStart:
+		return node + " started"
+	case event.NodeStop:
+		return node + " stopped"
+	case event.NodeCrash:
+		return node + " crashed: " + str("error")
+	case event.NodeRestart:
+		return node + " is restarting"
+	case event.NodeHang:
+		return node + " stopped responding and is being restarted: " + str("reason")
+	case event.NodeConfig:
+		return node + " had " + str("key") + " changed from '" + str("old") + "' to '" + str("new") + "'"
+	case event.PlayerJoin:
+		return str("player") + " joined " + e.Node
+	case event.PlayerLeave:
+		return str("player") + " left " + e.Node
+	case event.PlayerChat:
+		return "[" + e.Node + "] <" + str("player") + "> " + str("message")
+	case event.PlayerDeath:
+		return "[" + e.Node + "] " + str("message")
+	case event.ServerReady:
+		return node + " is ready"
+	case event.ServerException:
+		return node + " logged " + str("exception") + ": " + str("message")
+	case event.BackupDone:
+		return node + " was backed up to " + str("backup")
+	case event.BackupFail:
+		return "Backing up " + e.Node + " failed: " + str("error")
+	case event.BackupRestore:
+		return node + " was restored from " + str("backup")
+	case event.FetchDone:
+		return "Fetched " + str("implementation") + " " + str("version")
+	case event.FetchFail:
+		return "Failed to fetch " + str("implementation") + " " + str("version") + ": " + str("error")
+	}
+
+	msg := e.Type
+	if e.Node != "" {
+		msg += " on " + e.Node
+	}
+
+	keys := []string{}
+	for k := range e.Data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for i, k := range keys {
+		if i == 0 {
+			msg += ":"
+		}
+
+		data, _ := json.Marshal(e.Data[k])
+		msg += " " + k + "=" + string(data)
+	}
+
+	return msg
+}
+
+// embed colours for discord, red for failures
+func colour(eventType string) int {
+	switch eventType {
+	case event.NodeCrash, event.NodeHang, event.FetchFail, event.BackupFail, event.ServerException:
+		return 0xE74C3C
+	case event.NodeRestart, event.ServerLag:
+		return 0xF1C40F
+	case event.NodeStart, event.ServerReady, event.FetchDone, event.BackupDone:
+		return 0x2ECC71
+	}
+
+	return 0x95A5A6
+}
+
+func contains(list []string, val string) bool {
+	for _, v := range list {
+		if v == val {
+			return true
+		}
+	}
+
+	return false
+}
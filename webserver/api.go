@@ -0,0 +1,610 @@
+package webserver
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"lolarobins.ca/overload/audit"
+	"lolarobins.ca/overload/fetch"
+	"lolarobins.ca/overload/log"
+	"lolarobins.ca/overload/node"
+	"lolarobins.ca/overload/ping"
+	"lolarobins.ca/overload/query"
+	"lolarobins.ca/overload/user"
+)
+
+type request struct {
+	*http.Request
+	User   *user.User
+	Params map[string]string
+}
+
+type route struct {
+	Method     string
+	Path       string
+	Public     bool
+	Permission string // checked against the node in the id parameter, or globally without one
+	Handler    func(http.ResponseWriter, *request)
+}
+
+type nodeView struct {
+	Id      string          `json:"id"`
+	Running bool            `json:"running"`
+	State   string          `json:"state"`
+	Config  node.NodeConfig `json:"config"`
+	Stats   *node.Stats     `json:"stats,omitempty"`
+	Ping    *ping.Status    `json:"ping,omitempty"`
+	Query   *query.Stat     `json:"query,omitempty"`
+}
+
+type apiErr struct {
+	Err string `json:"error"`
+}
+
+// every endpoint under /api/v1 and /metrics, path segments in braces are parameters
+var routes = []route{
+	{Method: "POST", Path: "/api/v1/login", Public: true, Handler: login},
+	{Method: "POST", Path: "/api/v1/logout", Handler: logout},
+	{Method: "GET", Path: "/api/v1/user", Handler: currentUser},
+	{Method: "GET", Path: "/api/v1/nodes", Handler: listNodes},
+	{Method: "GET", Path: "/api/v1/nodes/{id}", Permission: user.PermView, Handler: getNode},
+	{Method: "POST", Path: "/api/v1/nodes/{id}/start", Permission: user.PermPower, Handler: startNode},
+	{Method: "POST", Path: "/api/v1/nodes/{id}/stop", Permission: user.PermPower, Handler: stopNode},
+	{Method: "POST", Path: "/api/v1/nodes/{id}/kill", Permission: user.PermPower, Handler: killNode},
+	{Method: "POST", Path: "/api/v1/nodes/{id}/restart", Permission: user.PermPower, Handler: restartNode},
+	{Method: "POST", Path: "/api/v1/nodes/{id}/restart/countdown", Permission: user.PermPower, Handler: countdownRestart},
+	{Method: "DELETE", Path: "/api/v1/nodes/{id}/restart/countdown", Permission: user.PermPower, Handler: cancelCountdown},
+	{Method: "POST", Path: "/api/v1/nodes/{id}/command", Permission: user.PermCommand, Handler: sendCommand},
+	{Method: "GET", Path: "/api/v1/nodes/{id}/console", Permission: user.PermConsole, Handler: streamConsole},
+	{Method: "GET", Path: "/api/v1/nodes/{id}/config", Permission: user.PermConfig, Handler: getConfig},
+	{Method: "PATCH", Path: "/api/v1/nodes/{id}/config", Permission: user.PermConfig, Handler: setConfig},
+	{Method: "GET", Path: "/api/v1/nodes/{id}/metrics", Permission: user.PermView, Handler: nodeMetrics},
+	{Method: "GET", Path: "/api/v1/nodes/{id}/files", Permission: user.PermFiles, Handler: listFiles},
+	{Method: "DELETE", Path: "/api/v1/nodes/{id}/files", Permission: user.PermFiles, Handler: deleteFile},
+	{Method: "GET", Path: "/api/v1/nodes/{id}/files/content", Permission: user.PermFiles, Handler: downloadFile},
+	{Method: "PUT", Path: "/api/v1/nodes/{id}/files/content", Permission: user.PermFiles, Handler: writeFile},
+	{Method: "POST", Path: "/api/v1/nodes/{id}/files/folder", Permission: user.PermFiles, Handler: makeFolder},
+	{Method: "POST", Path: "/api/v1/nodes/{id}/files/rename", Permission: user.PermFiles, Handler: renameFile},
+	{Method: "POST", Path: "/api/v1/nodes/{id}/files/zip", Permission: user.PermFiles, Handler: zipFiles},
+	{Method: "POST", Path: "/api/v1/nodes/{id}/files/unzip", Permission: user.PermFiles, Handler: unzipFile},
+	{Method: "GET", Path: "/api/v1/players", Handler: onlinePlayers},
+	{Method: "GET", Path: "/api/v1/sessions", Handler: querySessions},
+	{Method: "GET", Path: "/api/v1/jars", Handler: listJars},
+	{Method: "POST", Path: "/api/v1/fetch", Permission: user.PermAdmin, Handler: fetchJar},
+	{Method: "GET", Path: "/api/v1/events", Handler: streamEvents},
+	{Method: "GET", Path: "/api/v1/audit", Permission: user.PermAdmin, Handler: queryAudit},
+	{Method: "GET", Path: "/api/v1/openapi.json", Handler: serveOpenAPI},
+	{Method: "GET", Path: "/metrics", Handler: serveMetrics},
+}
+
+// matches a request path against a route path, returning the parameters if it matches
+func match(pattern string, path string) (map[string]string, bool) {
+	patternParts := strings.Split(strings.Trim(pattern, "/"), "/")
+	pathParts := strings.Split(strings.Trim(path, "/"), "/")
+
+	if len(patternParts) != len(pathParts) {
+		return nil, false
+	}
+
+	params := make(map[string]string)
+	for i, part := range patternParts {
+		if strings.HasPrefix(part, "{") && strings.HasSuffix(part, "}") {
+			if pathParts[i] == "" {
+				return nil, false
+			}
+
+			params[strings.Trim(part, "{}")] = pathParts[i]
+		} else if part != pathParts[i] {
+			return nil, false
+		}
+	}
+
+	return params, true
+}
+
+func apiHandler(w http.ResponseWriter, r *http.Request) {
+	found := false
+
+	for _, rt := range routes {
+		params, ok := match(rt.Path, r.URL.Path)
+		if !ok {
+			continue
+		}
+
+		found = true
+
+		if rt.Method != r.Method {
+			continue
+		}
+
+		req := &request{Request: r, Params: params}
+
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		defer observe(rt.Path, r.Method, sw, time.Now())
+		w = sw
+
+		if !rt.Public {
+			if req.User = authenticate(r); req.User == nil {
+				writeError(w, http.StatusUnauthorized, errors.New("authentication required"))
+				return
+			}
+		}
+
+		if rt.Permission != "" && !req.can(rt.Permission) {
+			writeError(w, http.StatusForbidden, errors.New("permission denied"))
+			return
+		}
+
+		rt.Handler(w, req)
+		return
+	}
+
+	if found {
+		writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+	} else {
+		writeError(w, http.StatusNotFound, errors.New("endpoint not found"))
+	}
+}
+
+// checks a permission against the node in the id parameter, or globally without one
+func (r *request) can(perm string) bool {
+	id, ok := r.Params["id"]
+	if !ok {
+		return r.User.Can(perm, "", nil)
+	}
+
+	if n, err := node.Get(id); err == nil {
+		return r.User.Can(perm, n.Id, n.Config.Tags)
+	}
+
+	return r.User.Can(perm, id, nil)
+}
+
+// records an action taken by the requesting user in the audit log
+func (r *request) audit(e audit.Entry) {
+	e.Actor = r.User.Name
+	e.Source = audit.API
+	audit.Record(e)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		log.Error("Marshalling API response: " + err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(data)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, apiErr{Err: err.Error()})
+}
+
+func readJSON(r *request, v interface{}) error {
+	data, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+
+	if err := json.Unmarshal(data, v); err != nil {
+		return errors.New("request body cannot be parsed")
+	}
+
+	return nil
+}
+
+func viewNode(n *node.Node) nodeView {
+	view := nodeView{Id: n.Id, Running: n.IsRunning(), State: n.State(), Config: n.Config}
+
+	if stats, ok := n.Stats(); ok {
+		view.Stats = &stats
+	}
+
+	if status, ok := n.Ping(); ok {
+		view.Ping = &status
+	}
+
+	if stat, ok := n.Query(); ok {
+		view.Query = &stat
+	}
+
+	return view
+}
+
+// fetches the node named by the id parameter, writing an error if it doesn't exist
+func requestNode(w http.ResponseWriter, r *request) *node.Node {
+	n, err := node.Get(r.Params["id"])
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return nil
+	}
+
+	return n
+}
+
+func login(w http.ResponseWriter, r *request) {
+	body := struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+	}{}
+
+	if err := readJSON(r, &body); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	u, err := user.Authenticate(body.Username, body.Password)
+	if err != nil {
+		log.Info("Failed web panel login for '" + body.Username + "' from " + r.RemoteAddr)
+		writeError(w, http.StatusUnauthorized, err)
+		return
+	}
+
+	id, err := newSession(u)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	setSessionCookie(w, id, int(sessionLength.Seconds()))
+	writeJSON(w, http.StatusOK, map[string]string{"username": u.Name})
+}
+
+func logout(w http.ResponseWriter, r *request) {
+	if cookie, err := r.Cookie(sessionCookie); err == nil {
+		endSession(cookie.Value)
+	}
+
+	setSessionCookie(w, "", -1)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// the current user along with their effective permissions, so the panel can hide what they can't use
+func currentUser(w http.ResponseWriter, r *request) {
+	global := []string{}
+	nodes := make(map[string][]string)
+
+	for _, perm := range user.Permissions {
+		if r.User.Can(perm, "", nil) {
+			global = append(global, perm)
+		}
+	}
+
+	for _, n := range node.All() {
+		perms := []string{}
+		for _, perm := range user.Permissions {
+			if r.User.Can(perm, n.Id, n.Config.Tags) {
+				perms = append(perms, perm)
+			}
+		}
+
+		if len(perms) > 0 {
+			nodes[n.Id] = perms
+		}
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"username":    r.User.Name,
+		"grants":      r.User.Grants,
+		"permissions": global,
+		"nodes":       nodes,
+	})
+}
+
+func listNodes(w http.ResponseWriter, r *request) {
+	list := []nodeView{}
+	for _, n := range node.All() {
+		if r.User.Can(user.PermView, n.Id, n.Config.Tags) {
+			list = append(list, viewNode(n))
+		}
+	}
+
+	writeJSON(w, http.StatusOK, list)
+}
+
+func getNode(w http.ResponseWriter, r *request) {
+	if n := requestNode(w, r); n != nil {
+		writeJSON(w, http.StatusOK, viewNode(n))
+	}
+}
+
+func startNode(w http.ResponseWriter, r *request) {
+	n := requestNode(w, r)
+	if n == nil {
+		return
+	}
+
+	if err := n.Start(); err != nil {
+		writeError(w, http.StatusConflict, err)
+		return
+	}
+
+	r.audit(audit.Entry{Action: "node.start", Target: n.Id})
+
+	writeJSON(w, http.StatusOK, viewNode(n))
+}
+
+func stopNode(w http.ResponseWriter, r *request) {
+	n := requestNode(w, r)
+	if n == nil {
+		return
+	}
+
+	if err := n.SendCommand("stop"); err != nil {
+		writeError(w, http.StatusConflict, err)
+		return
+	}
+
+	r.audit(audit.Entry{Action: "node.stop", Target: n.Id})
+
+	writeJSON(w, http.StatusOK, viewNode(n))
+}
+
+func killNode(w http.ResponseWriter, r *request) {
+	n := requestNode(w, r)
+	if n == nil {
+		return
+	}
+
+	if err := n.Kill(); err != nil {
+		writeError(w, http.StatusConflict, err)
+		return
+	}
+
+	r.audit(audit.Entry{Action: "node.kill", Target: n.Id})
+
+	writeJSON(w, http.StatusOK, viewNode(n))
+}
+
+func restartNode(w http.ResponseWriter, r *request) {
+	n := requestNode(w, r)
+	if n == nil {
+		return
+	}
+
+	r.audit(audit.Entry{Action: "node.restart", Target: n.Id})
+
+	go func() {
+		if err := n.Restart(); err != nil {
+			log.Error("Error restarting node: " + err.Error())
+		}
+	}()
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// warns players and restarts the node once the countdown ends, or as its policy says when players are or aren't online
+func countdownRestart(w http.ResponseWriter, r *request) {
+	n := requestNode(w, r)
+	if n == nil {
+		return
+	}
+
+	if !n.IsRunning() {
+		writeError(w, http.StatusConflict, errors.New("node is not currently active"))
+		return
+	}
+
+	if n.CountingDown() {
+		writeError(w, http.StatusConflict, errors.New("node is already counting down to a restart"))
+		return
+	}
+
+	r.audit(audit.Entry{Action: "node.countdown", Target: n.Id})
+
+	go func() {
+		if err := n.CountdownRestart(); err != nil && err != node.ErrRestartSkipped && err != node.ErrRestartCancelled {
+			log.Error("Error restarting node: " + err.Error())
+		}
+	}()
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func cancelCountdown(w http.ResponseWriter, r *request) {
+	n := requestNode(w, r)
+	if n == nil {
+		return
+	}
+
+	if err := n.CancelCountdown(); err != nil {
+		writeError(w, http.StatusConflict, err)
+		return
+	}
+
+	r.audit(audit.Entry{Action: "node.countdown.cancel", Target: n.Id})
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func sendCommand(w http.ResponseWriter, r *request) {
+	n := requestNode(w, r)
+	if n == nil {
+		return
+	}
+
+	body := struct {
+		Command string `json:"command"`
+	}{}
+
+	if err := readJSON(r, &body); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	reply, replied, err := n.Command(body.Command)
+	if err != nil {
+		writeError(w, http.StatusConflict, err)
+		return
+	}
+
+	r.audit(audit.Entry{Action: "node.command", Target: n.Id, New: body.Command})
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{"output": reply, "rcon": replied})
+}
+
+func getConfig(w http.ResponseWriter, r *request) {
+	if n := requestNode(w, r); n != nil {
+		writeJSON(w, http.StatusOK, n.Config)
+	}
+}
+
+// keys that can grant more access on the node, choose what it runs or lift the limits it runs under, so they need admin rather than config
+var adminConfig = map[string]bool{"tags": true, "jvm": true, "jar": true, "cpulimit": true, "memorylimit": true, "pidlimit": true}
+
+// applies each key in the body through node.SetConfig, so the same validation as the cli applies
+func setConfig(w http.ResponseWriter, r *request) {
+	n := requestNode(w, r)
+	if n == nil {
+		return
+	}
+
+	body := make(map[string]json.RawMessage)
+	if err := readJSON(r, &body); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	values := make(map[string]string)
+	for key, raw := range body {
+		val := string(raw)
+
+		var str string
+		if json.Unmarshal(raw, &str) == nil {
+			val = str
+		}
+
+		key = strings.ToLower(key)
+
+		// the panel sends every key, only apply what changed
+		if n.ConfigValue(key) == val {
+			continue
+		}
+
+		if adminConfig[key] && !r.can(user.PermAdmin) {
+			writeError(w, http.StatusForbidden, errors.New(key+": permission denied"))
+			return
+		}
+
+		values[key] = val
+	}
+
+	// every value is checked before any is saved, so a bad one doesn't leave the others half applied
+	for key, val := range values {
+		if err := node.ValidateConfig(key, val); err != nil {
+			writeError(w, http.StatusBadRequest, errors.New(key+": "+err.Error()))
+			return
+		}
+	}
+
+	for key, val := range values {
+		old := n.ConfigValue(key)
+
+		if err := n.SetConfig(key, val); err != nil {
+			writeError(w, http.StatusInternalServerError, errors.New(key+": "+err.Error()))
+			return
+		}
+
+		r.audit(audit.Entry{Action: "node.config." + key, Target: n.Id, Old: old, New: n.ConfigValue(key)})
+	}
+
+	writeJSON(w, http.StatusOK, n.Config)
+}
+
+// server jars available to nodes
+func listJars(w http.ResponseWriter, r *request) {
+	files, err := os.ReadDir("jar")
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, errors.New("could not read 'jar' directory"))
+		return
+	}
+
+	list := []string{}
+	for _, f := range files {
+		if !f.IsDir() && strings.HasSuffix(f.Name(), ".jar") {
+			list = append(list, f.Name())
+		}
+	}
+
+	writeJSON(w, http.StatusOK, list)
+}
+
+func fetchJar(w http.ResponseWriter, r *request) {
+	body := struct {
+		Implementation string `json:"implementation"`
+		Version        string `json:"version"`
+	}{}
+
+	if err := readJSON(r, &body); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if !fetch.Supported(body.Implementation) {
+		writeError(w, http.StatusBadRequest, errors.New("implementation not found"))
+		return
+	}
+
+	if body.Version == "" {
+		body.Version = "latest"
+	}
+
+	r.audit(audit.Entry{Action: "fetch", Target: strings.ToLower(body.Implementation), New: body.Version})
+
+	go func() {
+		if err := fetch.Fetch(body.Implementation, body.Version); err != nil {
+			log.Error("Fetching " + body.Implementation + ": " + err.Error())
+		}
+	}()
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func queryAudit(w http.ResponseWriter, r *request) {
+	query := r.URL.Query()
+	filter := audit.Filter{
+		Actor:  query.Get("actor"),
+		Source: query.Get("source"),
+		Action: query.Get("action"),
+		Target: query.Get("target"),
+		Limit:  100,
+	}
+
+	if since := query.Get("since"); since != "" {
+		t, err := time.Parse(time.RFC3339, since)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, errors.New("since must be an RFC 3339 timestamp"))
+			return
+		}
+
+		filter.Since = t
+	}
+
+	if limit := query.Get("limit"); limit != "" {
+		l, err := strconv.Atoi(limit)
+		if err != nil || l < 0 {
+			writeError(w, http.StatusBadRequest, errors.New("invalid limit"))
+			return
+		}
+
+		filter.Limit = l
+	}
+
+	entries, err := audit.Query(filter)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, entries)
+}
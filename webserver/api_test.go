@@ -0,0 +1,84 @@
+package webserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestSetConfigValidatesFirst(t *testing.T) {
+	n := testNode(t, "config-test")
+	name := n.Config.Name
+
+	// whichever key is applied first, none are saved when one is invalid
+	for i := 0; i < 10; i++ {
+		w := httptest.NewRecorder()
+		setConfig(w, testRequest(testUser("admin", n.Id), n.Id, "PATCH", "/", `{"name": "Renamed", "autostart": true, "memory": "lots", "port": "25570"}`))
+
+		if w.Code != http.StatusBadRequest {
+			t.Fatalf("got status %d, expected %d", w.Code, http.StatusBadRequest)
+		}
+	}
+
+	if n.Config.Name != name || n.Config.Autostart || n.Config.Port == "25570" {
+		t.Errorf("config was partly changed: %+v", n.Config)
+	}
+
+	saved := struct {
+		Name string `json:"name"`
+	}{}
+	data, _ := os.ReadFile("nodes/config-test/node.json")
+	json.Unmarshal(data, &saved)
+
+	if saved.Name != name {
+		t.Errorf("node.json was changed to name '%s'", saved.Name)
+	}
+
+	if _, err := os.Stat("data/audit.jsonl"); err == nil {
+		t.Error("a change that wasn't made was audited")
+	}
+
+	w := httptest.NewRecorder()
+	setConfig(w, testRequest(testUser("admin", n.Id), n.Id, "PATCH", "/", `{"name": "Renamed", "memory": "2048"}`))
+
+	if w.Code != http.StatusOK || n.Config.Name != "Renamed" || n.Config.Memory != 2048 {
+		t.Errorf("valid change: got status %d and config %+v", w.Code, n.Config)
+	}
+}
+
+func TestSetConfigAdminKeys(t *testing.T) {
+	n := testNode(t, "limits-test")
+	n.Config.CPULimit = 50
+	n.Config.MemoryLimit = 4096
+	n.Config.PidLimit = 200
+
+	for _, key := range []string{"cpulimit", "memorylimit", "pidlimit", "tags", "jvm", "jar"} {
+		w := httptest.NewRecorder()
+		setConfig(w, testRequest(testUser("operator", n.Id), n.Id, "PATCH", "/", `{"`+key+`": "0"}`))
+
+		if w.Code != http.StatusForbidden {
+			t.Errorf("%s by operator: got status %d, expected %d", key, w.Code, http.StatusForbidden)
+		}
+	}
+
+	if n.Config.CPULimit != 50 || n.Config.MemoryLimit != 4096 || n.Config.PidLimit != 200 {
+		t.Errorf("limits were changed by an operator: %+v", n.Config)
+	}
+
+	// other keys only need config
+	w := httptest.NewRecorder()
+	setConfig(w, testRequest(testUser("operator", n.Id), n.Id, "PATCH", "/", `{"memory": 2048}`))
+
+	if w.Code != http.StatusOK {
+		t.Errorf("memory by operator: got status %d, expected %d", w.Code, http.StatusOK)
+	}
+
+	w = httptest.NewRecorder()
+	setConfig(w, testRequest(testUser("admin", n.Id), n.Id, "PATCH", "/", `{"cpulimit": 0}`))
+
+	if w.Code != http.StatusOK || n.Config.CPULimit != 0 {
+		t.Errorf("cpulimit by admin: got status %d and limit %d", w.Code, n.Config.CPULimit)
+	}
+}
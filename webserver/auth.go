@@ -0,0 +1,125 @@
+package webserver
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"io/fs"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"lolarobins.ca/overload/settings"
+	"lolarobins.ca/overload/user"
+)
+
+type session struct {
+	user     string
+	password string
+	expires  time.Time
+}
+
+const sessionCookie = "overload_session"
+const sessionLength = 12 * time.Hour
+
+var sessions = make(map[string]*session)
+var sessionLock = new(sync.Mutex)
+
+func newSession(u *user.User) (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+
+	id := hex.EncodeToString(buf)
+
+	sessionLock.Lock()
+	defer sessionLock.Unlock()
+
+	// clear out expired sessions while we're here
+	for k, s := range sessions {
+		if time.Now().After(s.expires) {
+			delete(sessions, k)
+		}
+	}
+
+	sessions[id] = &session{user: u.Name, password: u.PasswordHash(), expires: time.Now().Add(sessionLength)}
+
+	return id, nil
+}
+
+func endSession(id string) {
+	sessionLock.Lock()
+	delete(sessions, id)
+	sessionLock.Unlock()
+}
+
+// resolves the user behind a request from either an api token or a session cookie
+func authenticate(r *http.Request) *user.User {
+	if header := r.Header.Get("Authorization"); strings.HasPrefix(header, "Bearer ") {
+		u, err := user.FromToken(strings.TrimPrefix(header, "Bearer "))
+		if err != nil {
+			return nil
+		}
+
+		return u
+	}
+
+	cookie, err := r.Cookie(sessionCookie)
+	if err != nil {
+		return nil
+	}
+
+	sessionLock.Lock()
+	s, ok := sessions[cookie.Value]
+	sessionLock.Unlock()
+
+	if !ok || time.Now().After(s.expires) {
+		return nil
+	}
+
+	u, err := user.Get(s.user)
+
+	// user was removed or has changed their password since logging in
+	if err != nil || u.PasswordHash() != s.password {
+		endSession(cookie.Value)
+		return nil
+	}
+
+	return u
+}
+
+func setSessionCookie(w http.ResponseWriter, id string, maxAge int) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    id,
+		Path:     "/",
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   settings.Settings.TLS,
+		SameSite: http.SameSiteStrictMode,
+	})
+}
+
+// panel handler, everything but the login page and its stylesheet requires a session
+func panelHandler(panel fs.FS) http.Handler {
+	files := http.FileServer(http.FS(panel))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/login" || r.URL.Path == "/style.css" {
+			if r.URL.Path == "/login" {
+				r.URL.Path = "/login.html"
+			}
+
+			files.ServeHTTP(w, r)
+			return
+		}
+
+		if authenticate(r) == nil {
+			http.Redirect(w, r, "/login", http.StatusSeeOther)
+			return
+		}
+
+		files.ServeHTTP(w, r)
+	})
+}
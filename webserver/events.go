@@ -0,0 +1,175 @@
+package webserver
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"lolarobins.ca/overload/event"
+	"lolarobins.ca/overload/node"
+	"lolarobins.ca/overload/user"
+)
+
+// closed when the server shuts down, as streams would otherwise hold it open
+var closing = make(chan struct{})
+
+// server-sent event stream, optionally filtered with ?node=a,b and ?type=node.start,player.*
+func streamEvents(w http.ResponseWriter, r *request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, errors.New("streaming unsupported"))
+		return
+	}
+
+	nodes := splitFilter(r.URL.Query().Get("node"))
+	types := splitFilter(r.URL.Query().Get("type"))
+
+	visible := func(e event.Event) bool {
+		if len(types) > 0 && !matchesAny(types, e.Type) {
+			return false
+		}
+
+		if e.Node == "" {
+			return len(nodes) == 0
+		}
+
+		if len(nodes) > 0 && !contains(nodes, e.Node) {
+			return false
+		}
+
+		var tags []string
+		if n, err := node.Get(e.Node); err == nil {
+			tags = n.Config.Tags
+		}
+
+		return r.User.Can(user.PermView, e.Node, tags)
+	}
+
+	// subscribe before replaying so nothing falls in between
+	sub := event.Subscribe()
+	defer sub.Unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	var last uint64
+	if id, err := strconv.ParseUint(r.Header.Get("Last-Event-ID"), 10, 64); err == nil {
+		for _, e := range event.Since(id) {
+			if visible(e) {
+				writeEvent(w, e)
+			}
+			last = e.Id
+		}
+	}
+
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(30 * time.Second)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case e, ok := <-sub.C:
+			if !ok {
+				return
+			}
+
+			if e.Id > last && visible(e) {
+				writeEvent(w, e)
+				flusher.Flush()
+			}
+		case <-heartbeat.C:
+			w.Write([]byte(": heartbeat\n\n"))
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		case <-closing:
+			return
+		}
+	}
+}
+
+// server-sent stream of a node's console, starting with its recent history
+func streamConsole(w http.ResponseWriter, r *request) {
+	n := requestNode(w, r)
+	if n == nil {
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, errors.New("streaming unsupported"))
+		return
+	}
+
+	history, lines, cancel := n.FollowConsole()
+	defer cancel()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	for _, line := range history {
+		w.Write([]byte("data: " + line + "\n\n"))
+	}
+
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(30 * time.Second)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case line := <-lines:
+			w.Write([]byte("data: " + line + "\n\n"))
+			flusher.Flush()
+		case <-heartbeat.C:
+			w.Write([]byte(": heartbeat\n\n"))
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		case <-closing:
+			return
+		}
+	}
+}
+
+func writeEvent(w http.ResponseWriter, e event.Event) {
+	data, _ := json.Marshal(e)
+	w.Write([]byte("id: " + strconv.FormatUint(e.Id, 10) + "\nevent: " + e.Type + "\ndata: " + string(data) + "\n\n"))
+}
+
+func splitFilter(val string) []string {
+	list := []string{}
+	for _, v := range strings.Split(val, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+
+	return list
+}
+
+func matchesAny(filters []string, eventType string) bool {
+	for _, f := range filters {
+		if event.Matches(f, eventType) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func contains(list []string, val string) bool {
+	for _, v := range list {
+		if v == val {
+			return true
+		}
+	}
+
+	return false
+}
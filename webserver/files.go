@@ -0,0 +1,349 @@
+package webserver
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"lolarobins.ca/overload/audit"
+	"lolarobins.ca/overload/files"
+	"lolarobins.ca/overload/node"
+	"lolarobins.ca/overload/user"
+)
+
+// resolves a path from the request within the node's directory, writing an error if it escapes
+func nodePath(w http.ResponseWriter, n *node.Node, rel string) (string, bool) {
+	path, err := files.Resolve("nodes/"+n.Id, rel)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return "", false
+	}
+
+	return path, true
+}
+
+// paths in the node's directory only an admin can change, as node.json sets what the node runs and who can manage it
+func protectedPaths(r *request, n *node.Node) []string {
+	if r.can(user.PermAdmin) {
+		return nil
+	}
+
+	path, err := files.Resolve("nodes/"+n.Id, "node.json")
+	if err != nil {
+		return nil
+	}
+
+	return []string{path}
+}
+
+// writes an error if a resolved path can't be changed by the requesting user
+func protectedPath(w http.ResponseWriter, r *request, n *node.Node, path string) bool {
+	for _, p := range protectedPaths(r, n) {
+		if filepath.Clean(path) == p {
+			writeError(w, http.StatusForbidden, errors.New("'node.json' can only be changed by an admin of the node"))
+			return true
+		}
+	}
+
+	return false
+}
+
+func fileError(w http.ResponseWriter, err error) {
+	if os.IsNotExist(err) {
+		writeError(w, http.StatusNotFound, errors.New("file does not exist"))
+	} else {
+		writeError(w, http.StatusBadRequest, err)
+	}
+}
+
+func listFiles(w http.ResponseWriter, r *request) {
+	n := requestNode(w, r)
+	if n == nil {
+		return
+	}
+
+	path, ok := nodePath(w, n, r.URL.Query().Get("path"))
+	if !ok {
+		return
+	}
+
+	entries, err := files.List(path)
+	if err != nil {
+		fileError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, entries)
+}
+
+func downloadFile(w http.ResponseWriter, r *request) {
+	n := requestNode(w, r)
+	if n == nil {
+		return
+	}
+
+	path, ok := nodePath(w, n, r.URL.Query().Get("path"))
+	if !ok {
+		return
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		fileError(w, err)
+		return
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil || info.IsDir() {
+		writeError(w, http.StatusBadRequest, errors.New("path is a directory"))
+		return
+	}
+
+	if r.URL.Query().Get("download") != "" {
+		w.Header().Set("Content-Disposition", "attachment; filename="+strconv.Quote(filepath.Base(path)))
+	}
+
+	http.ServeContent(w, r.Request, filepath.Base(path), info.ModTime(), f)
+}
+
+// uploads or edits a file, the request body is the new contents
+func writeFile(w http.ResponseWriter, r *request) {
+	n := requestNode(w, r)
+	if n == nil {
+		return
+	}
+
+	rel := r.URL.Query().Get("path")
+	path, ok := nodePath(w, n, rel)
+	if !ok {
+		return
+	}
+
+	if files.IsRoot("nodes/"+n.Id, path) {
+		writeError(w, http.StatusBadRequest, errors.New("path is a directory"))
+		return
+	}
+
+	if protectedPath(w, r, n, path) {
+		return
+	}
+
+	if err := files.Write(path, r.Body); err != nil {
+		fileError(w, err)
+		return
+	}
+
+	r.audit(audit.Entry{Action: "file.write", Target: n.Id + ":" + rel})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func makeFolder(w http.ResponseWriter, r *request) {
+	n := requestNode(w, r)
+	if n == nil {
+		return
+	}
+
+	rel := r.URL.Query().Get("path")
+	path, ok := nodePath(w, n, rel)
+	if !ok {
+		return
+	}
+
+	if protectedPath(w, r, n, path) {
+		return
+	}
+
+	if err := os.MkdirAll(path, 0755); err != nil {
+		fileError(w, err)
+		return
+	}
+
+	r.audit(audit.Entry{Action: "file.mkdir", Target: n.Id + ":" + rel})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func renameFile(w http.ResponseWriter, r *request) {
+	n := requestNode(w, r)
+	if n == nil {
+		return
+	}
+
+	body := struct {
+		From string `json:"from"`
+		To   string `json:"to"`
+	}{}
+
+	if err := readJSON(r, &body); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	from, ok := nodePath(w, n, body.From)
+	if !ok {
+		return
+	}
+
+	to, ok := nodePath(w, n, body.To)
+	if !ok {
+		return
+	}
+
+	if files.IsRoot("nodes/"+n.Id, from) || files.IsRoot("nodes/"+n.Id, to) {
+		writeError(w, http.StatusBadRequest, errors.New("the node directory itself cannot be renamed"))
+		return
+	}
+
+	if protectedPath(w, r, n, from) || protectedPath(w, r, n, to) {
+		return
+	}
+
+	if _, err := os.Lstat(to); err == nil {
+		writeError(w, http.StatusConflict, errors.New("'"+body.To+"' already exists"))
+		return
+	}
+
+	if err := os.Rename(from, to); err != nil {
+		fileError(w, err)
+		return
+	}
+
+	r.audit(audit.Entry{Action: "file.rename", Target: n.Id, Old: body.From, New: body.To})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func deleteFile(w http.ResponseWriter, r *request) {
+	n := requestNode(w, r)
+	if n == nil {
+		return
+	}
+
+	rel := r.URL.Query().Get("path")
+	path, ok := nodePath(w, n, rel)
+	if !ok {
+		return
+	}
+
+	if files.IsRoot("nodes/"+n.Id, path) {
+		writeError(w, http.StatusBadRequest, errors.New("the node directory itself cannot be deleted"))
+		return
+	}
+
+	if protectedPath(w, r, n, path) {
+		return
+	}
+
+	if _, err := os.Lstat(path); err != nil {
+		fileError(w, err)
+		return
+	}
+
+	if err := os.RemoveAll(path); err != nil {
+		fileError(w, err)
+		return
+	}
+
+	r.audit(audit.Entry{Action: "file.delete", Target: n.Id + ":" + rel})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func zipFiles(w http.ResponseWriter, r *request) {
+	n := requestNode(w, r)
+	if n == nil {
+		return
+	}
+
+	body := struct {
+		Paths []string `json:"paths"`
+		To    string   `json:"to"`
+	}{}
+
+	if err := readJSON(r, &body); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if len(body.Paths) == 0 {
+		writeError(w, http.StatusBadRequest, errors.New("no paths to zip"))
+		return
+	}
+
+	root, ok := nodePath(w, n, "")
+	if !ok {
+		return
+	}
+
+	paths := []string{}
+	for _, p := range body.Paths {
+		path, ok := nodePath(w, n, p)
+		if !ok {
+			return
+		}
+
+		paths = append(paths, path)
+	}
+
+	dest, ok := nodePath(w, n, body.To)
+	if !ok {
+		return
+	}
+
+	if protectedPath(w, r, n, dest) {
+		return
+	}
+
+	if _, err := os.Lstat(dest); err == nil {
+		writeError(w, http.StatusConflict, errors.New("'"+body.To+"' already exists"))
+		return
+	}
+
+	if err := files.Zip(root, paths, dest); err != nil {
+		fileError(w, err)
+		return
+	}
+
+	r.audit(audit.Entry{Action: "file.zip", Target: n.Id + ":" + body.To})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func unzipFile(w http.ResponseWriter, r *request) {
+	n := requestNode(w, r)
+	if n == nil {
+		return
+	}
+
+	body := struct {
+		Path string `json:"path"`
+		To   string `json:"to"`
+	}{}
+
+	if err := readJSON(r, &body); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	src, ok := nodePath(w, n, body.Path)
+	if !ok {
+		return
+	}
+
+	dest, ok := nodePath(w, n, body.To)
+	if !ok {
+		return
+	}
+
+	if err := files.Unzip("nodes/"+n.Id, src, dest, protectedPaths(r, n)...); err != nil {
+		if err == files.ErrProtected {
+			writeError(w, http.StatusForbidden, errors.New("'node.json' can only be changed by an admin of the node"))
+			return
+		}
+
+		fileError(w, err)
+		return
+	}
+
+	r.audit(audit.Entry{Action: "file.unzip", Target: n.Id + ":" + body.Path, New: body.To})
+	w.WriteHeader(http.StatusNoContent)
+}
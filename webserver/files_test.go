@@ -0,0 +1,114 @@
+package webserver
+
+import (
+	"archive/zip"
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"lolarobins.ca/overload/node"
+	"lolarobins.ca/overload/user"
+)
+
+// creates a node in a working directory of its own, removed once the test finishes
+func testNode(t *testing.T, id string) *node.Node {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+
+	for _, d := range []string{"config", "data", "nodes"} {
+		if err := os.Mkdir(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	n, err := node.Create(id)
+	if err != nil {
+		t.Fatal("creating node: " + err.Error())
+	}
+
+	return n
+}
+
+// a user with one of the built in roles on a node
+func testUser(role string, id string) *user.User {
+	return &user.User{Name: role, Grants: []user.Grant{{Role: role, Scope: "node:" + id}}}
+}
+
+func testRequest(u *user.User, id string, method string, target string, body string) *request {
+	return &request{Request: httptest.NewRequest(method, target, bytes.NewBufferString(body)), User: u, Params: map[string]string{"id": id}}
+}
+
+func TestNodeJSONNeedsAdmin(t *testing.T) {
+	n := testNode(t, "files-test")
+	config, _ := os.ReadFile("nodes/files-test/node.json")
+
+	// an archive that would replace node.json when extracted at the root
+	archive := new(bytes.Buffer)
+	zw := zip.NewWriter(archive)
+	fw, _ := zw.Create("node.json")
+	fw.Write([]byte(`{"jvm": "/bin/sh"}`))
+	zw.Close()
+	os.WriteFile("nodes/files-test/evil.zip", archive.Bytes(), 0644)
+	os.WriteFile("nodes/files-test/other.txt", []byte("other"), 0644)
+
+	tests := []struct {
+		name    string
+		handler func(http.ResponseWriter, *request)
+		method  string
+		target  string
+		body    string
+	}{
+		{"write", writeFile, "PUT", "/?path=node.json", `{"jvm": "/bin/sh"}`},
+		{"write with a relative path", writeFile, "PUT", "/?path=./world/../node.json", `{"jvm": "/bin/sh"}`},
+		{"delete", deleteFile, "DELETE", "/?path=node.json", ""},
+		{"folder", makeFolder, "POST", "/?path=node.json", ""},
+		{"rename over", renameFile, "POST", "/", `{"from": "other.txt", "to": "node.json"}`},
+		{"rename away", renameFile, "POST", "/", `{"from": "node.json", "to": "moved.json"}`},
+		{"zip over", zipFiles, "POST", "/", `{"paths": ["other.txt"], "to": "node.json"}`},
+		{"unzip over", unzipFile, "POST", "/", `{"path": "evil.zip", "to": ""}`},
+	}
+
+	for _, test := range tests {
+		for _, role := range []string{"operator", "admin"} {
+			w := httptest.NewRecorder()
+			test.handler(w, testRequest(testUser(role, n.Id), n.Id, test.method, test.target, test.body))
+
+			if role == "operator" && w.Code != http.StatusForbidden {
+				t.Errorf("%s by %s: got status %d, expected %d", test.name, role, w.Code, http.StatusForbidden)
+			}
+
+			if role == "admin" && w.Code == http.StatusForbidden {
+				t.Errorf("%s by %s: forbidden", test.name, role)
+			}
+
+			if role == "operator" {
+				if data, _ := os.ReadFile("nodes/files-test/node.json"); !bytes.Equal(data, config) {
+					t.Fatalf("%s by %s: node.json was changed", test.name, role)
+				}
+			}
+
+			// put back whatever the admin changed
+			os.RemoveAll("nodes/files-test/node.json")
+			os.WriteFile("nodes/files-test/node.json", config, 0644)
+			os.WriteFile("nodes/files-test/other.txt", []byte("other"), 0644)
+		}
+	}
+
+	// other files are still open to the files permission
+	w := httptest.NewRecorder()
+	writeFile(w, testRequest(testUser("operator", n.Id), n.Id, "PUT", "/?path=server.properties", "motd=hi"))
+
+	if w.Code != http.StatusNoContent {
+		t.Errorf("writing server.properties: got status %d, expected %d", w.Code, http.StatusNoContent)
+	}
+}
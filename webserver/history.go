@@ -0,0 +1,83 @@
+package webserver
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"lolarobins.ca/overload/history"
+)
+
+// points returned when no step is given
+const defaultPoints = 300
+
+type metricsView struct {
+	Node   string          `json:"node"`
+	From   time.Time       `json:"from"`
+	To     time.Time       `json:"to"`
+	Step   int64           `json:"step"` // seconds
+	Points []history.Point `json:"points"`
+}
+
+// a node's cpu, memory, player and tps history, the last hour unless a range is given
+func nodeMetrics(w http.ResponseWriter, r *request) {
+	n := requestNode(w, r)
+	if n == nil {
+		return
+	}
+
+	query := r.URL.Query()
+	to := time.Now().UTC().Truncate(time.Second)
+	from := to.Add(-time.Hour)
+
+	for _, param := range []string{"from", "to"} {
+		val := query.Get(param)
+		if val == "" {
+			continue
+		}
+
+		t, err := time.Parse(time.RFC3339, val)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, errors.New(param+" must be an RFC 3339 timestamp"))
+			return
+		}
+
+		if param == "from" {
+			from = t
+		} else {
+			to = t
+		}
+	}
+
+	if !from.Before(to) {
+		writeError(w, http.StatusBadRequest, errors.New("from must be before to"))
+		return
+	}
+
+	step := to.Sub(from) / defaultPoints
+	if val := query.Get("step"); val != "" {
+		// a duration such as '5m', or seconds
+		d, err := time.ParseDuration(val)
+		if err != nil {
+			seconds, serr := strconv.Atoi(val)
+			if serr != nil {
+				writeError(w, http.StatusBadRequest, errors.New("step must be a duration or seconds"))
+				return
+			}
+
+			d = time.Duration(seconds) * time.Second
+		}
+
+		if d <= 0 {
+			writeError(w, http.StatusBadRequest, errors.New("step must be positive"))
+			return
+		}
+
+		step = d
+	}
+
+	points, step := history.Query(n.Id, from, to, step)
+
+	writeJSON(w, http.StatusOK, metricsView{Node: n.Id, From: from, To: to, Step: int64(step / time.Second), Points: points})
+}
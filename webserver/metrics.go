@@ -0,0 +1,194 @@
+package webserver
+
+import (
+	"net/http"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"lolarobins.ca/overload/fetch"
+	"lolarobins.ca/overload/metrics"
+	"lolarobins.ca/overload/node"
+	"lolarobins.ca/overload/settings"
+	"lolarobins.ca/overload/user"
+)
+
+var requestDuration = &metrics.Histogram{
+	Name:    "overload_http_request_duration_seconds",
+	Help:    "Time taken to respond to web panel and API requests.",
+	Labels:  []string{"route", "method", "code"},
+	Buckets: []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10},
+}
+
+// keeps the status code of a response for metrics, passing flushes through for event streams
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// records how long a request took, leaving out event streams which stay open until the client leaves
+func observe(route string, method string, w *statusWriter, start time.Time) {
+	if strings.HasPrefix(w.Header().Get("Content-Type"), "text/event-stream") {
+		return
+	}
+
+	requestDuration.Observe(time.Since(start).Seconds(), route, method, strconv.Itoa(w.status))
+}
+
+func instrument(route string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		defer observe(route, r.Method, sw, time.Now())
+
+		h.ServeHTTP(sw, r)
+	})
+}
+
+func serveMetrics(w http.ResponseWriter, r *request) {
+	w.Header().Set("Content-Type", metrics.ContentType)
+	m := metrics.NewWriter(w)
+
+	nodes := []*node.Node{}
+	for _, n := range node.All() {
+		if r.User.Can(user.PermView, n.Id, n.Config.Tags) {
+			nodes = append(nodes, n)
+		}
+	}
+
+	// nodes
+	m.Header("overload_node_up", "gauge", "Whether the node's process is running.")
+	for _, n := range nodes {
+		m.Sample("overload_node_up", boolFloat(n.IsRunning()), "node", n.Id)
+	}
+
+	m.Header("overload_node_state", "gauge", "State of the node, one series per state.")
+	for _, n := range nodes {
+		state := n.State()
+		for _, s := range []string{node.StateStopped, node.StateRunning, node.StateStopping, node.StateCrashed} {
+			m.Sample("overload_node_state", boolFloat(s == state), "node", n.Id, "state", s)
+		}
+	}
+
+	m.Header("overload_node_restarts_total", "counter", "Restarts of the node since overload started.")
+	for _, n := range nodes {
+		m.Sample("overload_node_restarts_total", float64(n.Restarts()), "node", n.Id)
+	}
+
+	m.Header("overload_node_uptime_seconds", "gauge", "Time since the node's process started, zero when stopped.")
+	for _, n := range nodes {
+		m.Sample("overload_node_uptime_seconds", n.Uptime().Seconds(), "node", n.Id)
+	}
+
+	m.Header("overload_node_console_lines_total", "counter", "Lines written to the node's console, rate() gives lines per second.")
+	for _, n := range nodes {
+		m.Sample("overload_node_console_lines_total", float64(n.ConsoleLines()), "node", n.Id)
+	}
+
+	m.Header("overload_node_players", "gauge", "Players online from the server list ping, or counted from join and leave messages.")
+	for _, n := range nodes {
+		if status, ok := n.Ping(); ok {
+			m.Sample("overload_node_players", float64(status.Online), "node", n.Id)
+		} else if n.IsRunning() {
+			m.Sample("overload_node_players", float64(len(n.Players())), "node", n.Id)
+		}
+	}
+
+	m.Header("overload_node_max_players", "gauge", "Player limit from the server list ping.")
+	for _, n := range nodes {
+		if status, ok := n.Ping(); ok {
+			m.Sample("overload_node_max_players", float64(status.Max), "node", n.Id)
+		}
+	}
+
+	m.Header("overload_node_tps", "gauge", "Ticks per second over the last minute, known once the tps command has been run.")
+	for _, n := range nodes {
+		if tps, ok := n.TPS(); ok {
+			m.Sample("overload_node_tps", tps, "node", n.Id)
+		}
+	}
+
+	stats := make(map[string]node.Stats)
+	for _, n := range nodes {
+		if s, ok := n.Stats(); ok {
+			stats[n.Id] = s
+		}
+	}
+
+	statsMetrics := []struct {
+		name  string
+		kind  string
+		help  string
+		value func(s node.Stats) float64
+	}{
+		{"overload_node_cpu_seconds_total", "counter", "CPU time used by the node's process tree.", func(s node.Stats) float64 { return s.CPUSeconds }},
+		{"overload_node_resident_memory_bytes", "gauge", "Resident memory of the node's process tree.", func(s node.Stats) float64 { return float64(s.RSS) }},
+		{"overload_node_threads", "gauge", "Threads in the node's process tree.", func(s node.Stats) float64 { return float64(s.Threads) }},
+		{"overload_node_open_fds", "gauge", "Open file descriptors in the node's process tree.", func(s node.Stats) float64 { return float64(s.FDs) }},
+		{"overload_node_disk_read_bytes_total", "counter", "Bytes read from disk by the node's process tree.", func(s node.Stats) float64 { return float64(s.ReadBytes) }},
+		{"overload_node_disk_write_bytes_total", "counter", "Bytes written to disk by the node's process tree.", func(s node.Stats) float64 { return float64(s.WriteBytes) }},
+	}
+
+	for _, sm := range statsMetrics {
+		m.Header(sm.name, sm.kind, sm.help)
+		for _, n := range nodes {
+			if s, ok := stats[n.Id]; ok {
+				m.Sample(sm.name, sm.value(s), "node", n.Id)
+			}
+		}
+	}
+
+	m.Header("overload_node_memory_limit_bytes", "gauge", "Maximum heap given to the node's JVM.")
+	for _, n := range nodes {
+		m.Sample("overload_node_memory_limit_bytes", float64(n.Config.Memory)*1024*1024, "node", n.Id)
+	}
+
+	// upnp
+	m.Gauge("overload_upnp_enabled", "Whether UPnP port forwarding is enabled.", boolFloat(settings.Settings.UPnP))
+
+	m.Header("overload_upnp_forwarded", "gauge", "Whether a port is currently forwarded through UPnP.")
+	if settings.Settings.PanelPortForward {
+		m.Sample("overload_upnp_forwarded", boolFloat(panelForwarded), "kind", "panel", "port", settings.Settings.PanelPort)
+	}
+	for _, n := range nodes {
+		if n.Config.PortForward {
+			m.Sample("overload_upnp_forwarded", boolFloat(n.Forwarded()), "kind", "node", "node", n.Id, "port", n.Config.Port)
+		}
+	}
+
+	// fetch
+	active, done, failed := fetch.Jobs()
+	m.Gauge("overload_fetch_jobs_active", "Jar fetches in progress.", float64(active))
+	m.Header("overload_fetch_jobs_total", "counter", "Finished jar fetches since overload started.")
+	m.Sample("overload_fetch_jobs_total", float64(done), "result", "done")
+	m.Sample("overload_fetch_jobs_total", float64(failed), "result", "failed")
+
+	// overload itself
+	mem := runtime.MemStats{}
+	runtime.ReadMemStats(&mem)
+
+	m.Gauge("go_goroutines", "Number of goroutines that currently exist.", float64(runtime.NumGoroutine()))
+	m.Gauge("go_memstats_heap_alloc_bytes", "Bytes of allocated heap objects.", float64(mem.HeapAlloc))
+	m.Gauge("go_memstats_sys_bytes", "Bytes of memory obtained from the OS.", float64(mem.Sys))
+
+	requestDuration.Write(m)
+}
+
+func boolFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+
+	return 0
+}
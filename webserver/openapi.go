@@ -0,0 +1,16 @@
+package webserver
+
+import (
+	_ "embed"
+	"net/http"
+)
+
+// describes every route in the routes table, openapi_test.go fails when they drift apart
+//
+//go:embed openapi.json
+var openapi []byte
+
+func serveOpenAPI(w http.ResponseWriter, r *request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(openapi)
+}
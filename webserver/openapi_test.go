@@ -0,0 +1,139 @@
+package webserver
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+	"testing"
+)
+
+type spec struct {
+	Paths map[string]map[string]json.RawMessage `json:"paths"`
+}
+
+type operation struct {
+	Security  *[]map[string][]string `json:"security"`
+	Responses map[string]interface{} `json:"responses"`
+}
+
+func loadSpec(t *testing.T) spec {
+	s := spec{}
+	if err := json.Unmarshal(openapi, &s); err != nil {
+		t.Fatal("openapi.json cannot be parsed: " + err.Error())
+	}
+
+	return s
+}
+
+func TestSpecMatchesRoutes(t *testing.T) {
+	s := loadSpec(t)
+
+	inSpec := make(map[string]bool)
+	for path, item := range s.Paths {
+		for method := range item {
+			if method == "parameters" {
+				continue
+			}
+
+			inSpec[strings.ToUpper(method)+" "+path] = true
+		}
+	}
+
+	inRoutes := make(map[string]bool)
+	for _, rt := range routes {
+		inRoutes[rt.Method+" "+rt.Path] = true
+	}
+
+	missing := []string{}
+	for r := range inRoutes {
+		if !inSpec[r] {
+			missing = append(missing, r)
+		}
+	}
+
+	extra := []string{}
+	for r := range inSpec {
+		if !inRoutes[r] {
+			extra = append(extra, r)
+		}
+	}
+
+	sort.Strings(missing)
+	sort.Strings(extra)
+
+	for _, r := range missing {
+		t.Error("route not described in openapi.json: " + r)
+	}
+
+	for _, r := range extra {
+		t.Error("openapi.json describes a route that doesn't exist: " + r)
+	}
+}
+
+func TestSpecSecurity(t *testing.T) {
+	s := loadSpec(t)
+
+	for _, rt := range routes {
+		raw, ok := s.Paths[rt.Path][strings.ToLower(rt.Method)]
+		if !ok {
+			continue
+		}
+
+		op := operation{}
+		if err := json.Unmarshal(raw, &op); err != nil {
+			t.Fatal(rt.Method + " " + rt.Path + " cannot be parsed: " + err.Error())
+		}
+
+		if len(op.Responses) == 0 {
+			t.Error(rt.Method + " " + rt.Path + " has no responses")
+		}
+
+		public := op.Security != nil && len(*op.Security) == 0
+		if public != rt.Public {
+			t.Error(rt.Method + " " + rt.Path + " security in openapi.json does not match the route")
+		}
+	}
+}
+
+func TestSpecReferences(t *testing.T) {
+	doc := make(map[string]interface{})
+	if err := json.Unmarshal(openapi, &doc); err != nil {
+		t.Fatal("openapi.json cannot be parsed: " + err.Error())
+	}
+
+	var walk func(v interface{})
+	walk = func(v interface{}) {
+		switch v := v.(type) {
+		case map[string]interface{}:
+			if ref, ok := v["$ref"].(string); ok && !resolves(doc, ref) {
+				t.Error("unresolved reference in openapi.json: " + ref)
+			}
+
+			for _, child := range v {
+				walk(child)
+			}
+		case []interface{}:
+			for _, child := range v {
+				walk(child)
+			}
+		}
+	}
+
+	walk(doc)
+}
+
+func resolves(doc map[string]interface{}, ref string) bool {
+	var cur interface{} = doc
+	for _, part := range strings.Split(strings.TrimPrefix(ref, "#/"), "/") {
+		m, ok := cur.(map[string]interface{})
+		if !ok {
+			return false
+		}
+
+		if cur, ok = m[part]; !ok {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,579 @@
+"use strict";
+
+const state = {
+	user: null,
+	nodes: [],
+	selected: null,
+	console: null,
+	dir: "",
+	editing: null,
+	metrics: null,
+};
+
+const charts = [
+	{key: "cpu", label: "CPU", format: (v) => v.toFixed(0) + "%"},
+	{key: "rss", label: "Memory", format: (v) => formatSize(v)},
+	{key: "players", label: "Players", format: (v) => v.toFixed(1).replace(/\.0$/, "")},
+	{key: "tps", label: "TPS", format: (v) => v.toFixed(1)},
+];
+
+async function api(method, path, body) {
+	const options = {method: method, headers: {}};
+	if (body !== undefined) {
+		options.headers["Content-Type"] = "application/json";
+		options.body = JSON.stringify(body);
+	}
+
+	const resp = await fetch("/api/v1" + path, options);
+	if (resp.status === 401) {
+		window.location = "/login";
+		throw new Error("authentication required");
+	}
+
+	const text = await resp.text();
+	const data = text ? JSON.parse(text) : null;
+	if (!resp.ok) {
+		throw new Error(data && data.error ? data.error : resp.statusText);
+	}
+
+	return data;
+}
+
+// requests without json bodies, for file contents
+async function raw(method, path, body) {
+	const resp = await fetch("/api/v1" + path, {method: method, body: body});
+	if (resp.status === 401) {
+		window.location = "/login";
+		throw new Error("authentication required");
+	}
+
+	if (!resp.ok) {
+		const data = await resp.json().catch(() => null);
+		throw new Error(data && data.error ? data.error : resp.statusText);
+	}
+
+	return resp;
+}
+
+function nodeUrl(suffix) {
+	return "/nodes/" + encodeURIComponent(state.selected) + suffix;
+}
+
+function can(perm, id) {
+	const perms = id ? state.user.nodes[id] || [] : state.user.permissions;
+	return perms.includes(perm) || perms.includes("admin");
+}
+
+async function loadNodes() {
+	state.nodes = await api("GET", "/nodes");
+
+	const body = document.querySelector("#nodes tbody");
+	body.replaceChildren();
+
+	for (const n of state.nodes) {
+		const row = document.createElement("tr");
+		row.classList.toggle("selected", n.id === state.selected);
+		row.addEventListener("click", () => selectNode(n.id));
+
+		for (const text of [n.config.name + " (" + n.id + ")", n.state, n.config.port]) {
+			const cell = document.createElement("td");
+			cell.textContent = text;
+			row.appendChild(cell);
+		}
+
+		body.appendChild(row);
+	}
+
+	if (state.selected) {
+		showNode();
+	}
+}
+
+async function loadJars() {
+	const list = document.getElementById("jars");
+	list.replaceChildren();
+
+	for (const jar of await api("GET", "/jars")) {
+		const option = document.createElement("option");
+		option.value = jar;
+		list.appendChild(option);
+	}
+}
+
+function selectNode(id) {
+	if (state.selected !== id) {
+		state.selected = id;
+		state.dir = "";
+		followConsole();
+		fillConfig();
+		closeEditor();
+		loadFiles();
+		followMetrics();
+	}
+
+	for (const row of document.querySelectorAll("#nodes tbody tr")) {
+		row.classList.toggle("selected", row.firstChild.textContent.endsWith("(" + id + ")"));
+	}
+
+	showNode();
+}
+
+function selectedNode() {
+	return state.nodes.find((n) => n.id === state.selected);
+}
+
+function showNode() {
+	const n = selectedNode();
+	const section = document.getElementById("node");
+	section.hidden = !n;
+	if (!n) {
+		return;
+	}
+
+	document.getElementById("node-name").textContent = n.config.name + " (" + n.id + ")";
+
+	const badge = document.getElementById("node-state");
+	badge.textContent = n.state;
+	badge.className = "state " + n.state;
+
+	for (const el of section.querySelectorAll("[data-perm]")) {
+		el.hidden = !can(el.dataset.perm, n.id);
+	}
+
+	for (const button of section.querySelectorAll(".controls button")) {
+		const action = button.dataset.action;
+		button.disabled = action === "start" ? n.running : !n.running;
+	}
+}
+
+function followConsole() {
+	if (state.console) {
+		state.console.close();
+		state.console = null;
+	}
+
+	const pre = document.getElementById("console");
+	pre.textContent = "";
+
+	if (!can("console", state.selected)) {
+		return;
+	}
+
+	state.console = new EventSource("/api/v1/nodes/" + encodeURIComponent(state.selected) + "/console");
+	state.console.onopen = () => {
+		pre.textContent = "";
+	};
+	state.console.onmessage = (e) => {
+		const follow = pre.scrollTop + pre.clientHeight >= pre.scrollHeight - 4;
+		pre.append(e.data + "\n");
+		if (follow) {
+			pre.scrollTop = pre.scrollHeight;
+		}
+	};
+}
+
+async function fillConfig() {
+	const form = document.getElementById("config");
+	document.getElementById("config-status").textContent = "";
+
+	if (!can("config", state.selected)) {
+		return;
+	}
+
+	const config = await api("GET", "/nodes/" + encodeURIComponent(state.selected) + "/config");
+	for (const input of form.querySelectorAll("input")) {
+		if (input.type === "checkbox") {
+			input.checked = config[input.name];
+		} else if (input.name === "tags") {
+			input.value = config.tags.join(",");
+		} else {
+			input.value = config[input.name];
+		}
+	}
+}
+
+function followMetrics() {
+	clearInterval(state.metrics);
+	loadMetrics();
+	state.metrics = setInterval(loadMetrics, 60000);
+}
+
+async function loadMetrics() {
+	const id = state.selected;
+	const to = new Date();
+	const from = new Date(to - document.getElementById("metrics-range").value * 1000);
+
+	let data;
+	try {
+		data = await api("GET", "/nodes/" + encodeURIComponent(id) + "/metrics?from=" + from.toISOString() + "&to=" + to.toISOString());
+	} catch (e) {
+		return;
+	}
+
+	if (state.selected !== id) {
+		return;
+	}
+
+	const container = document.getElementById("charts");
+	container.replaceChildren();
+
+	for (const chart of charts) {
+		const figure = document.createElement("figure");
+		const caption = document.createElement("figcaption");
+		const canvas = document.createElement("canvas");
+		figure.append(caption, canvas);
+		container.appendChild(figure);
+
+		const points = data.points.filter((p) => p[chart.key] !== undefined);
+		caption.textContent = chart.label + (points.length ? ": " + chart.format(points[points.length - 1][chart.key]) : ": no data");
+		drawChart(canvas, chart, points, Date.parse(data.from), Date.parse(data.to), data.step * 1000);
+	}
+}
+
+// a line of the points from start to end, broken where samples are missing
+function drawChart(canvas, chart, points, start, end, step) {
+	canvas.width = canvas.clientWidth * devicePixelRatio;
+	canvas.height = canvas.clientHeight * devicePixelRatio;
+
+	const ctx = canvas.getContext("2d");
+	ctx.scale(devicePixelRatio, devicePixelRatio);
+
+	const width = canvas.clientWidth;
+	const height = canvas.clientHeight;
+	const max = Math.max(...points.map((p) => p[chart.key]), chart.key === "tps" ? 20 : 1);
+
+	ctx.fillStyle = "#8a8a99";
+	ctx.font = "11px system-ui, sans-serif";
+	ctx.fillText(chart.format(max), 2, 11);
+
+	ctx.strokeStyle = "#8c73ff";
+	ctx.lineWidth = 1.5;
+	ctx.beginPath();
+
+	let last = null;
+	for (const p of points) {
+		const time = Date.parse(p.time);
+		const x = (time - start) / (end - start) * width;
+		const y = height - p[chart.key] / max * (height - 14);
+
+		if (last === null || time - last > step * 2) {
+			ctx.moveTo(x, y);
+		} else {
+			ctx.lineTo(x, y);
+		}
+
+		last = time;
+	}
+
+	ctx.stroke();
+}
+
+function joinPath(dir, name) {
+	return dir ? dir + "/" + name : name;
+}
+
+function formatSize(size) {
+	const units = ["B", "KB", "MB", "GB"];
+	let i = 0;
+	while (size >= 1024 && i < units.length - 1) {
+		size /= 1024;
+		i++;
+	}
+
+	return (i === 0 ? size : size.toFixed(1)) + " " + units[i];
+}
+
+async function fileAction(fn) {
+	const error = document.getElementById("file-error");
+	error.textContent = "";
+
+	try {
+		await fn();
+	} catch (e) {
+		error.textContent = e.message;
+	}
+
+	await loadFiles();
+}
+
+async function loadFiles() {
+	if (!can("files", state.selected)) {
+		return;
+	}
+
+	document.getElementById("file-path").textContent = "/" + state.dir;
+	document.getElementById("file-up").disabled = state.dir === "";
+
+	let entries;
+	try {
+		entries = await api("GET", nodeUrl("/files?path=" + encodeURIComponent(state.dir)));
+	} catch (e) {
+		document.getElementById("file-error").textContent = e.message;
+		return;
+	}
+
+	const body = document.querySelector("#files tbody");
+	body.replaceChildren();
+
+	for (const entry of entries) {
+		const path = joinPath(state.dir, entry.name);
+		const row = document.createElement("tr");
+
+		const select = document.createElement("input");
+		select.type = "checkbox";
+		select.value = path;
+
+		const name = document.createElement("button");
+		name.className = "link";
+		name.textContent = entry.name + (entry.dir ? "/" : "");
+		name.addEventListener("click", () => {
+			if (entry.dir) {
+				state.dir = path;
+				loadFiles();
+			} else {
+				openEditor(path, entry.size);
+			}
+		});
+
+		const actions = [];
+		const action = (label, fn) => {
+			const button = document.createElement("button");
+			button.className = "link";
+			button.textContent = label;
+			button.addEventListener("click", fn);
+			actions.push(button);
+		};
+
+		if (!entry.dir) {
+			action("Download", () => {
+				window.location = "/api/v1" + nodeUrl("/files/content?download=1&path=" + encodeURIComponent(path));
+			});
+		}
+
+		if (!entry.dir && entry.name.toLowerCase().endsWith(".zip")) {
+			action("Unzip", () => {
+				const to = prompt("Extract into", joinPath(state.dir, entry.name.slice(0, -4)));
+				if (to !== null) {
+					fileAction(() => api("POST", nodeUrl("/files/unzip"), {path: path, to: to}));
+				}
+			});
+		}
+
+		action("Rename", () => {
+			const to = prompt("Rename to", path);
+			if (to !== null && to !== path) {
+				fileAction(() => api("POST", nodeUrl("/files/rename"), {from: path, to: to}));
+			}
+		});
+
+		action("Delete", () => {
+			if (confirm("Delete " + path + (entry.dir ? " and everything in it" : "") + "?")) {
+				fileAction(() => api("DELETE", nodeUrl("/files?path=" + encodeURIComponent(path))));
+			}
+		});
+
+		const cells = [[select], [name], [entry.dir ? "" : formatSize(entry.size)], [new Date(entry.modified).toLocaleString()], actions];
+		for (const children of cells) {
+			const cell = document.createElement("td");
+			cell.append(...children);
+			row.appendChild(cell);
+		}
+
+		body.appendChild(row);
+	}
+}
+
+async function openEditor(path, size) {
+	if (size > 1024 * 1024 && !confirm("This file is large, open it anyway?")) {
+		return;
+	}
+
+	try {
+		const resp = await raw("GET", nodeUrl("/files/content?path=" + encodeURIComponent(path)));
+		const form = document.getElementById("editor");
+		form.elements.content.value = await resp.text();
+		document.getElementById("editor-path").textContent = "/" + path;
+		form.hidden = false;
+		state.editing = path;
+	} catch (e) {
+		document.getElementById("file-error").textContent = e.message;
+	}
+}
+
+function closeEditor() {
+	document.getElementById("editor").hidden = true;
+	state.editing = null;
+}
+
+async function nodeAction(action) {
+	const error = document.getElementById("node-error");
+	error.textContent = "";
+
+	try {
+		await api("POST", "/nodes/" + encodeURIComponent(state.selected) + "/" + action);
+	} catch (e) {
+		error.textContent = e.message;
+	}
+
+	await loadNodes();
+}
+
+function followEvents() {
+	const events = new EventSource("/api/v1/events?type=node.*,fetch.*");
+
+	for (const type of ["node.start", "node.stop", "node.crash", "node.restart", "node.hang", "node.config"]) {
+		events.addEventListener(type, () => loadNodes());
+	}
+
+	const progress = document.getElementById("fetch-progress");
+	const status = document.getElementById("fetch-status");
+
+	events.addEventListener("fetch.start", (e) => {
+		const data = JSON.parse(e.data).data;
+		progress.hidden = false;
+		progress.removeAttribute("value");
+		status.textContent = "Fetching " + data.implementation + " " + data.version;
+	});
+	events.addEventListener("fetch.progress", (e) => {
+		const data = JSON.parse(e.data).data;
+		if (data.total > 0) {
+			progress.max = data.total;
+			progress.value = data.bytes;
+		}
+	});
+	events.addEventListener("fetch.done", (e) => {
+		const data = JSON.parse(e.data).data;
+		progress.hidden = true;
+		status.textContent = "Fetched " + data.implementation + " " + data.version + " (build " + data.build + ")";
+		loadJars();
+	});
+	events.addEventListener("fetch.fail", (e) => {
+		const data = JSON.parse(e.data).data;
+		progress.hidden = true;
+		status.textContent = "Fetching " + data.implementation + " failed: " + data.error;
+	});
+}
+
+document.getElementById("logout").addEventListener("click", async () => {
+	await api("POST", "/logout");
+	window.location = "/login";
+});
+
+for (const button of document.querySelectorAll(".controls button")) {
+	button.addEventListener("click", () => nodeAction(button.dataset.action));
+}
+
+document.getElementById("command").addEventListener("submit", async (e) => {
+	e.preventDefault();
+	const input = e.target.elements.command;
+	if (!input.value) {
+		return;
+	}
+
+	try {
+		const result = await api("POST", "/nodes/" + encodeURIComponent(state.selected) + "/command", {command: input.value});
+		input.value = "";
+
+		// replies over rcon don't show up in the console stream
+		if (result.output) {
+			const pre = document.getElementById("console");
+			pre.append(result.output.replace(/\u00a7./g, "").trimEnd() + "\n");
+			pre.scrollTop = pre.scrollHeight;
+		}
+	} catch (err) {
+		document.getElementById("node-error").textContent = err.message;
+	}
+});
+
+document.getElementById("config").addEventListener("submit", async (e) => {
+	e.preventDefault();
+	const status = document.getElementById("config-status");
+	const changes = {};
+
+	for (const input of e.target.querySelectorAll("input")) {
+		changes[input.name] = input.type === "checkbox" ? input.checked : input.value;
+	}
+
+	try {
+		await api("PATCH", "/nodes/" + encodeURIComponent(state.selected) + "/config", changes);
+		status.textContent = "Saved";
+	} catch (err) {
+		status.textContent = err.message;
+	}
+});
+
+document.getElementById("file-up").addEventListener("click", () => {
+	state.dir = state.dir.includes("/") ? state.dir.slice(0, state.dir.lastIndexOf("/")) : "";
+	loadFiles();
+});
+
+document.getElementById("file-new-folder").addEventListener("click", () => {
+	const name = prompt("Folder name");
+	if (name) {
+		fileAction(() => api("POST", nodeUrl("/files/folder?path=" + encodeURIComponent(joinPath(state.dir, name)))));
+	}
+});
+
+document.getElementById("file-new-file").addEventListener("click", () => {
+	const name = prompt("File name");
+	if (name) {
+		const path = joinPath(state.dir, name);
+		fileAction(async () => {
+			await raw("PUT", nodeUrl("/files/content?path=" + encodeURIComponent(path)), "");
+			await openEditor(path, 0);
+		});
+	}
+});
+
+document.getElementById("file-upload").addEventListener("change", (e) => {
+	const uploads = Array.from(e.target.files);
+	e.target.value = "";
+
+	fileAction(async () => {
+		for (const file of uploads) {
+			await raw("PUT", nodeUrl("/files/content?path=" + encodeURIComponent(joinPath(state.dir, file.name))), file);
+		}
+	});
+});
+
+document.getElementById("file-zip").addEventListener("click", () => {
+	const paths = Array.from(document.querySelectorAll("#files tbody input:checked")).map((input) => input.value);
+	if (paths.length === 0) {
+		return;
+	}
+
+	const to = prompt("Archive name", joinPath(state.dir, "archive.zip"));
+	if (to) {
+		fileAction(() => api("POST", nodeUrl("/files/zip"), {paths: paths, to: to}));
+	}
+});
+
+document.getElementById("editor").addEventListener("submit", (e) => {
+	e.preventDefault();
+	const content = e.target.elements.content.value;
+	fileAction(() => raw("PUT", nodeUrl("/files/content?path=" + encodeURIComponent(state.editing)), content));
+});
+
+document.getElementById("editor-close").addEventListener("click", closeEditor);
+
+document.getElementById("metrics-range").addEventListener("change", loadMetrics);
+
+document.getElementById("fetch-form").addEventListener("submit", async (e) => {
+	e.preventDefault();
+	const form = e.target.elements;
+
+	try {
+		await api("POST", "/fetch", {implementation: form.implementation.value, version: form.version.value || "latest"});
+	} catch (err) {
+		document.getElementById("fetch-status").textContent = err.message;
+	}
+});
+
+(async () => {
+	state.user = await api("GET", "/user");
+	document.getElementById("user").textContent = state.user.username;
+	document.getElementById("fetch").hidden = !can("admin");
+
+	await Promise.all([loadNodes(), loadJars()]);
+	followEvents();
+})();
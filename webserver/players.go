@@ -0,0 +1,84 @@
+package webserver
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"lolarobins.ca/overload/node"
+	"lolarobins.ca/overload/players"
+	"lolarobins.ca/overload/user"
+)
+
+// sessions on nodes the user can view
+func (r *request) visibleSessions(sessions []players.Session) []players.Session {
+	visible := []players.Session{}
+	for _, s := range sessions {
+		tags := []string{}
+		if n, err := node.Get(s.Node); err == nil {
+			tags = n.Config.Tags
+		}
+
+		if r.User.Can(user.PermView, s.Node, tags) {
+			visible = append(visible, s)
+		}
+	}
+
+	return visible
+}
+
+func onlinePlayers(w http.ResponseWriter, r *request) {
+	writeJSON(w, http.StatusOK, r.visibleSessions(players.Online()))
+}
+
+func querySessions(w http.ResponseWriter, r *request) {
+	query := r.URL.Query()
+	filter := players.Filter{
+		Player: query.Get("player"),
+		Node:   query.Get("node"),
+	}
+
+	for _, param := range []string{"since", "until"} {
+		val := query.Get(param)
+		if val == "" {
+			continue
+		}
+
+		t, err := time.Parse(time.RFC3339, val)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, errors.New(param+" must be an RFC 3339 timestamp"))
+			return
+		}
+
+		if param == "since" {
+			filter.Since = t
+		} else {
+			filter.Until = t
+		}
+	}
+
+	limit := 100
+	if val := query.Get("limit"); val != "" {
+		l, err := strconv.Atoi(val)
+		if err != nil || l < 0 {
+			writeError(w, http.StatusBadRequest, errors.New("invalid limit"))
+			return
+		}
+
+		limit = l
+	}
+
+	sessions, err := players.Query(filter)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	sessions = r.visibleSessions(sessions)
+	if limit > 0 && len(sessions) > limit {
+		sessions = sessions[len(sessions)-limit:]
+	}
+
+	writeJSON(w, http.StatusOK, sessions)
+}
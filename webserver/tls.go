@@ -0,0 +1,182 @@
+package webserver
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"lolarobins.ca/overload/log"
+	"lolarobins.ca/overload/node"
+	"lolarobins.ca/overload/settings"
+)
+
+const selfSignedCert = "config/tls-cert.pem"
+const selfSignedKey = "config/tls-key.pem"
+
+// serves the certificate and key files, reloading them when they change on disk
+type certLoader struct {
+	certFile string
+	keyFile  string
+	lock     sync.Mutex
+	cert     *tls.Certificate
+	modified time.Time
+	checked  time.Time
+}
+
+func newCertLoader() (*certLoader, error) {
+	c := &certLoader{certFile: settings.Settings.TLSCert, keyFile: settings.Settings.TLSKey}
+
+	if c.certFile == "" || c.keyFile == "" {
+		c.certFile = selfSignedCert
+		c.keyFile = selfSignedKey
+
+		// a certificate without its key, or the other way around, can't be used so both are replaced
+		_, certErr := os.Stat(c.certFile)
+		_, keyErr := os.Stat(c.keyFile)
+
+		if os.IsNotExist(certErr) || os.IsNotExist(keyErr) {
+			log.Info("No TLS certificate configured, generating a self-signed certificate")
+
+			if err := generateCert(c.certFile, c.keyFile); err != nil {
+				return nil, errors.New("generating self-signed certificate: " + err.Error())
+			}
+		}
+	}
+
+	if err := c.load(); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+func (c *certLoader) modTime() (time.Time, error) {
+	certInfo, err := os.Stat(c.certFile)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	keyInfo, err := os.Stat(c.keyFile)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	if keyInfo.ModTime().After(certInfo.ModTime()) {
+		return keyInfo.ModTime(), nil
+	}
+
+	return certInfo.ModTime(), nil
+}
+
+func (c *certLoader) load() error {
+	modified, err := c.modTime()
+	if err != nil {
+		return err
+	}
+
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return errors.New("loading '" + c.certFile + "': " + err.Error())
+	}
+
+	c.cert = &cert
+	c.modified = modified
+
+	return nil
+}
+
+func (c *certLoader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	// only check the files every few seconds rather than every handshake
+	if time.Since(c.checked) > 5*time.Second {
+		c.checked = time.Now()
+
+		if modified, err := c.modTime(); err == nil && !modified.Equal(c.modified) {
+			if err := c.load(); err != nil {
+				log.Error("Reloading TLS certificate: " + err.Error())
+			} else {
+				log.Info("Reloaded TLS certificate '" + c.certFile + "'")
+			}
+		}
+	}
+
+	return c.cert, nil
+}
+
+func generateCert(certFile string, keyFile string) error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return err
+	}
+
+	template := x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{Organization: []string{"overload"}, CommonName: settings.Settings.Hostname},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().AddDate(10, 0, 0),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+	}
+
+	hosts := []string{settings.Settings.Hostname}
+	if settings.Settings.UPnP && node.Router != nil {
+		if ip, err := node.Router.ExternalIP(); err == nil {
+			hosts = append(hosts, ip)
+		}
+	}
+
+	for _, h := range hosts {
+		if ip := net.ParseIP(h); ip != nil && !ip.IsLoopback() {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else if ip == nil && h != "" {
+			template.DNSNames = append(template.DNSNames, h)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		return err
+	}
+
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600); err != nil {
+		return err
+	}
+
+	return os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644)
+}
+
+// plain http listener which sends everything to the https panel
+func redirectHandler(w http.ResponseWriter, r *http.Request) {
+	host, _, err := net.SplitHostPort(r.Host)
+	if err != nil {
+		host = r.Host
+	}
+
+	http.Redirect(w, r, "https://"+net.JoinHostPort(host, settings.Settings.PanelPort)+r.URL.RequestURI(), http.StatusMovedPermanently)
+}
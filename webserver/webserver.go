@@ -2,7 +2,11 @@ package webserver
 
 import (
 	"context"
+	"crypto/tls"
+	"embed"
+	"io/fs"
 	"net/http"
+	"os"
 	"strconv"
 	"sync"
 
@@ -11,24 +15,57 @@ import (
 	"lolarobins.ca/overload/settings"
 )
 
+//go:embed panel
+var embeddedPanel embed.FS
+
 var srv *http.Server
+var redirectSrv *http.Server
 var ShutdownLock = new(sync.Mutex)
+var panelForwarded bool
 
 func Init() error {
-	srv = &http.Server{Addr: settings.Settings.Hostname + ":" + settings.Settings.PanelPort}
-	panel := http.FileServer(http.Dir("web"))
+	mux := http.NewServeMux()
+	srv = &http.Server{Addr: settings.Settings.Hostname + ":" + settings.Settings.PanelPort, Handler: mux}
+	srv.RegisterOnShutdown(func() { close(closing) })
+	// panel itself, from disk when developing it
+	var panel fs.FS
+	if settings.Settings.PanelDir != "" {
+		panel = os.DirFS(settings.Settings.PanelDir)
+		log.Info("Serving web panel from '" + settings.Settings.PanelDir + "'")
+	} else {
+		panel, _ = fs.Sub(embeddedPanel, "panel")
+	}
 
-	// panel itself
-	http.Handle("/", panel)
+	mux.Handle("/", instrument("panel", panelHandler(panel)))
 
 	// api
-	// TODO
+	mux.HandleFunc("/api/", apiHandler)
+	mux.HandleFunc("/metrics", apiHandler)
+
+	// tls
+	scheme := "http"
+	if settings.Settings.TLS {
+		loader, err := newCertLoader()
+		if err != nil {
+			return err
+		}
+
+		srv.TLSConfig = &tls.Config{GetCertificate: loader.getCertificate, MinVersion: tls.VersionTLS12}
+		scheme = "https"
+	}
 
 	// port forward
 	port, _ := strconv.Atoi(settings.Settings.PanelPort)
 
+	// logins over plain http can be read by anyone in between, so the panel is only forwarded with tls
+	forward := settings.Settings.UPnP && settings.Settings.PanelPortForward
+	if forward && !settings.Settings.TLS {
+		log.Error("Not port forwarding the web panel as TLS is disabled, passwords and tokens would be sent over the internet unencrypted; set 'tls' to true in config/settings.json to forward it")
+		forward = false
+	}
+
 	ip := settings.Settings.Hostname
-	if settings.Settings.UPnP && settings.Settings.PanelPortForward {
+	if forward {
 		if inuse, _ := node.Router.IsForwardedTCP(uint16(port)); inuse {
 			log.Info("Port " + settings.Settings.PanelPort + " is already forwared and may overlap with another public port")
 		}
@@ -38,16 +75,24 @@ func Init() error {
 			return err
 		} else {
 			ip, _ = node.Router.ExternalIP()
+			panelForwarded = true
 		}
 	}
 
-	log.Info("Web panel and API visible on http://" + ip + ":" + settings.Settings.PanelPort + "/")
+	log.Info("Web panel and API visible on " + scheme + "://" + ip + ":" + settings.Settings.PanelPort + "/")
 
 	ShutdownLock.Lock()
 
 	// serve page and api
 	go func() {
-		if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+		var err error
+		if settings.Settings.TLS {
+			err = srv.ListenAndServeTLS("", "")
+		} else {
+			err = srv.ListenAndServe()
+		}
+
+		if err != http.ErrServerClosed {
 			log.Error("Error in webserver goroutine: " + err.Error())
 		}
 
@@ -56,14 +101,32 @@ func Init() error {
 		// clear port forward
 		if settings.Settings.UPnP && settings.Settings.PanelPortForward {
 			node.Router.Clear(uint16(port))
+			panelForwarded = false
 		}
 
 		ShutdownLock.Unlock()
 	}()
 
+	// redirect plain http to the panel
+	if settings.Settings.TLS && settings.Settings.HTTPRedirect {
+		redirectSrv = &http.Server{Addr: settings.Settings.Hostname + ":" + settings.Settings.HTTPRedirectPort, Handler: http.HandlerFunc(redirectHandler)}
+
+		go func() {
+			if err := redirectSrv.ListenAndServe(); err != http.ErrServerClosed {
+				log.Error("Error in HTTP redirect goroutine: " + err.Error())
+			}
+		}()
+
+		log.Info("Redirecting http://" + settings.Settings.Hostname + ":" + settings.Settings.HTTPRedirectPort + "/ to the web panel")
+	}
+
 	return nil
 }
 
 func Stop() error {
+	if redirectSrv != nil {
+		redirectSrv.Shutdown(context.Background())
+	}
+
 	return srv.Shutdown(context.Background())
 }